@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/orderbynull/lottip/chat"
+)
+
+// analyzeStallTimeout is how long bytes sent by one side may wait to be processed before bytes of the other side are fed
+const analyzeStallTimeout = time.Second
+
+// analyzeMaxPages limits data buffered per connection while waiting for missing segments
+const analyzeMaxPages = 256
+
+var errConnClosed = errors.New("connection closed")
+
+// captureReader reads packets of pcap or pcapng file.
+type captureReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+// openCapture returns reader of pcap or pcapng file along with function returning link type of packet
+func openCapture(r io.Reader) (captureReader, func(ci gopacket.CaptureInfo) layers.LinkType, error) {
+	buffered := bufio.NewReader(r)
+
+	magic, err := buffered.Peek(4)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Section header block starts pcapng file
+	if binary.LittleEndian.Uint32(magic) == 0x0a0d0d0a {
+		reader, err := pcapgo.NewNgReader(buffered, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return reader, func(ci gopacket.CaptureInfo) layers.LinkType {
+			if iface, err := reader.Interface(ci.InterfaceIndex); err == nil {
+				return iface.LinkType
+			}
+			return reader.LinkType()
+		}, nil
+	}
+
+	reader, err := pcapgo.NewReader(buffered)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reader, func(gopacket.CaptureInfo) layers.LinkType { return reader.LinkType() }, nil
+}
+
+// captureAnalyzer reassembles TCP streams of MySQL connections found in capture
+// and feeds them through proxy as if connections were relayed live.
+// Connections started before capture are skipped since their handshake is missing.
+type captureAnalyzer struct {
+	proxy    *MySQLProxyServer
+	port     layers.TCPPort
+	conns    map[string]*analyzedConn // Connections being reassembled by ConnId
+	all      []*analyzedConn
+	wg       sync.WaitGroup
+	analyzed int
+	skipped  int
+}
+
+// analyzeCapture decodes MySQL connections to server port found in pcap or pcapng file at path.
+// Events are published by proxy, their time is time packets were captured at.
+func analyzeCapture(path string, port int, proxy *MySQLProxyServer) (*captureAnalyzer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, linkType, err := openCapture(file)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &captureAnalyzer{proxy: proxy, port: layers.TCPPort(port), conns: make(map[string]*analyzedConn)}
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(a))
+	assembler.MaxBufferedPagesPerConnection = analyzeMaxPages
+
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("%s: %s", path, err.Error())
+			break
+		}
+
+		packet := gopacket.NewPacket(data, linkType(ci), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok || packet.NetworkLayer() == nil || tcp.SrcPort != a.port && tcp.DstPort != a.port {
+			continue
+		}
+
+		assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, ci.Timestamp)
+	}
+	assembler.FlushAll()
+
+	// Sides never seen in capture won't send anything
+	for _, conn := range a.all {
+		conn.finish(false)
+		conn.finish(true)
+	}
+	a.wg.Wait()
+
+	return a, nil
+}
+
+// New implements tcpassembly.StreamFactory, it's called for each direction of connection
+func (a *captureAnalyzer) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	fromServer := layers.TCPPort(binary.BigEndian.Uint16(tcpFlow.Src().Raw())) == a.port
+
+	src := &net.TCPAddr{IP: net.IP(netFlow.Src().Raw()), Port: int(binary.BigEndian.Uint16(tcpFlow.Src().Raw()))}
+	dst := &net.TCPAddr{IP: net.IP(netFlow.Dst().Raw()), Port: int(binary.BigEndian.Uint16(tcpFlow.Dst().Raw()))}
+	client, server := src, dst
+	if fromServer {
+		client, server = dst, src
+	}
+
+	connId := client.String() + chat.ConnIdSeparator + server.String()
+	conn, ok := a.conns[connId]
+	if !ok {
+		conn = &analyzedConn{
+			client: newReplayConn(server, client),
+			server: newReplayConn(client, server),
+		}
+		a.conns[connId] = conn
+		a.all = append(a.all, conn)
+	}
+
+	return &analyzedStream{a, conn, connId, fromServer}
+}
+
+// start starts decoding connection if its start was captured
+func (a *captureAnalyzer) start(conn *analyzedConn, captured bool) {
+	conn.decided = true
+	if !captured {
+		conn.skipped = true
+		a.skipped++
+		return
+	}
+
+	a.analyzed++
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.proxy.proxyConnection(conn.client, conn.server, conn.now)
+		conn.client.Close()
+		conn.server.Close()
+	}()
+}
+
+// analyzedStream receives bytes sent by one side of connection.
+type analyzedStream struct {
+	analyzer   *captureAnalyzer
+	conn       *analyzedConn
+	connId     string
+	fromServer bool
+}
+
+// Reassembled implements tcpassembly.Stream
+func (s *analyzedStream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	for _, r := range reassemblies {
+		if !s.conn.decided {
+			s.analyzer.start(s.conn, r.Start)
+		}
+
+		if s.conn.skipped {
+			continue
+		}
+
+		if r.Skip != 0 {
+			log.Printf("%s: bytes missing in capture", s.connId)
+		}
+
+		if len(r.Bytes) > 0 {
+			s.conn.feed(r.Seen, s.fromServer, r.Bytes)
+		}
+	}
+}
+
+// ReassemblyComplete implements tcpassembly.Stream
+func (s *analyzedStream) ReassemblyComplete() {
+	s.conn.finish(s.fromServer)
+
+	// Address may be reused by connection started later
+	s.conn.complete++
+	if s.conn.complete == 2 && s.analyzer.conns[s.connId] == s.conn {
+		delete(s.analyzer.conns, s.connId)
+	}
+}
+
+// analyzedConn represents connection read from capture.
+// Bytes sent by one side are processed by proxy before the other side's bytes are fed,
+// so request is decoded before response to it like it happens live.
+type analyzedConn struct {
+	client   *replayConn // Proxy reads bytes sent by client from it
+	server   *replayConn // Proxy reads bytes sent by server from it
+	decided  bool
+	skipped  bool
+	complete int // Number of directions reassembled completely
+
+	mu         sync.Mutex
+	time       time.Time // Capture time of bytes fed last
+	fed        [2]int64  // Number of bytes fed by client and by server
+	fromServer bool      // Side fed last
+}
+
+// now returns capture time of bytes being processed
+func (c *analyzedConn) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.time
+}
+
+// feed passes bytes sent by one side at time t to proxy
+func (c *analyzedConn) feed(t time.Time, fromServer bool, data []byte) {
+	if fromServer != c.fromServer {
+		c.wait(c.fromServer)
+	}
+
+	c.mu.Lock()
+	c.time, c.fromServer = t, fromServer
+	c.fed[dataSide(fromServer)] += int64(len(data))
+	c.mu.Unlock()
+
+	if fromServer {
+		c.server.feed(data)
+	} else {
+		c.client.feed(data)
+	}
+}
+
+// finish ends bytes sent by one side once bytes of the other side are processed,
+// so connection isn't torn down before the last response is decoded
+func (c *analyzedConn) finish(fromServer bool) {
+	c.wait(!fromServer)
+
+	if fromServer {
+		c.server.finish()
+	} else {
+		c.client.finish()
+	}
+}
+
+// wait waits till proxy forwards all bytes fed by one side, or stops making progress
+func (c *analyzedConn) wait(fromServer bool) {
+	dst := c.server
+	if fromServer {
+		dst = c.client
+	}
+
+	c.mu.Lock()
+	fed := c.fed[dataSide(fromServer)]
+	c.mu.Unlock()
+
+	for dst.forwarded() < fed {
+		select {
+		case <-dst.progress:
+		case <-dst.done:
+			return
+		case <-time.After(analyzeStallTimeout):
+			return
+		}
+	}
+}
+
+// replayConn is connection end proxy talks to while capture is analyzed.
+// Proxy reads bytes fed from capture and writes bytes it forwards, which are only counted.
+// Feeding never blocks, so proxy waiting for the other side can't hold analysis up.
+type replayConn struct {
+	local    net.Addr
+	remote   net.Addr
+	progress chan struct{} // Signalled on each write
+	done     chan struct{} // Closed on close
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	eof     bool
+	closed  bool
+	written int64
+}
+
+func newReplayConn(local, remote net.Addr) *replayConn {
+	c := &replayConn{
+		local:    local,
+		remote:   remote,
+		progress: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// feed appends bytes to be read by proxy
+func (c *replayConn) feed(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed && !c.eof {
+		c.buf = append(c.buf, data...)
+		c.cond.Broadcast()
+	}
+}
+
+// finish makes proxy read EOF once fed bytes are read
+func (c *replayConn) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.eof = true
+	c.cond.Broadcast()
+}
+
+// forwarded returns number of bytes written by proxy
+func (c *replayConn) forwarded() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.written
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.buf) == 0 && !c.eof && !c.closed {
+		c.cond.Wait()
+	}
+
+	if c.closed {
+		return 0, errConnClosed
+	}
+
+	if len(c.buf) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+func (c *replayConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, errConnClosed
+	}
+	c.written += int64(len(p))
+	c.mu.Unlock()
+
+	select {
+	case c.progress <- struct{}{}:
+	default:
+	}
+
+	return len(p), nil
+}
+
+func (c *replayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		c.buf = nil
+		c.cond.Broadcast()
+		close(c.done)
+	}
+
+	return nil
+}
+
+func (c *replayConn) LocalAddr() net.Addr                { return c.local }
+func (c *replayConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/pcapng"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeMySQLPacket builds MySQL packet with given sequence number
+func makeMySQLPacket(seq byte, payload ...byte) []byte {
+	l := len(payload)
+	return append([]byte{byte(l), byte(l >> 8), byte(l >> 16), seq}, payload...)
+}
+
+// mySQLPacket represents MySQL packet sent by either side of connection
+type mySQLPacket struct {
+	FromServer bool
+	Data       []byte
+}
+
+// mySQLSession returns packets of connection authenticating as root to database shop and running single query
+func mySQLSession(query string) []mySQLPacket {
+	// CLIENT_CONNECT_WITH_DB | CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH
+	capabilities := uint32(0x00088208)
+
+	greeting := []byte{0x0a}
+	greeting = append(greeting, "8.0.0\x00"...)
+	greeting = append(greeting, 0x07, 0x00, 0x00, 0x00)
+	greeting = append(greeting, make([]byte, 8+1)...)
+	greeting = append(greeting, byte(capabilities), byte(capabilities>>8), 0x21, 0x02, 0x00, byte(capabilities>>16), byte(capabilities>>24), 21)
+	greeting = append(greeting, make([]byte, 10+13)...)
+	greeting = append(greeting, "mysql_native_password\x00"...)
+
+	response := []byte{byte(capabilities), byte(capabilities >> 8), byte(capabilities >> 16), byte(capabilities >> 24)}
+	response = append(response, 0x00, 0x00, 0x00, 0x01, 0x21)
+	response = append(response, make([]byte, 23)...)
+	response = append(response, "root\x00"...)
+	response = append(response, 0x00)
+	response = append(response, "shop\x00"...)
+
+	ok := []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}
+
+	return []mySQLPacket{
+		{true, makeMySQLPacket(0, greeting...)},
+		{false, makeMySQLPacket(1, response...)},
+		{true, makeMySQLPacket(2, ok...)},
+		{false, makeMySQLPacket(0, append([]byte{0x03}, query...)...)},
+		{true, makeMySQLPacket(1, ok...)},
+	}
+}
+
+// writeMySQLSession writes connection authenticating as root to database shop and running single query
+func writeMySQLSession(t *testing.T, stream *pcapng.Stream, at time.Time, query string) {
+	packets := mySQLSession(query)
+	for i, packet := range packets {
+		assert.Nil(t, stream.Write(at.Add(time.Duration(i)*time.Second), packet.FromServer, packet.Data))
+	}
+	assert.Nil(t, stream.Close(at.Add(time.Duration(len(packets))*time.Second)))
+}
+
+// withoutBlocks returns pcapng capture without its first n blocks
+func withoutBlocks(capture []byte, n int) []byte {
+	for ; n > 0; n-- {
+		capture = capture[binary.LittleEndian.Uint32(capture[4:]):]
+	}
+
+	return capture
+}
+
+func TestAnalyzeCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-analyze")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3306}
+	other := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3307}
+
+	capture := &bytes.Buffer{}
+	writer, err := pcapng.NewWriter(capture, "test")
+	assert.Nil(t, err)
+	writeMySQLSession(t, writer.NewStream(client, server), at, "SELECT 1")
+	writeMySQLSession(t, writer.NewStream(client, other), at, "SELECT 2")
+
+	// Connection started before capture lacks section header, interface description and three-way handshake
+	started := &bytes.Buffer{}
+	writer, err = pcapng.NewWriter(started, "test")
+	assert.Nil(t, err)
+	writeMySQLSession(t, writer.NewStream(&net.TCPAddr{IP: client.IP, Port: 50001}, server), at, "SELECT 3")
+	capture.Write(withoutBlocks(started.Bytes(), 2+3))
+
+	path := filepath.Join(dir, "capture.pcapng")
+	assert.Nil(t, ioutil.WriteFile(path, capture.Bytes(), 0644))
+
+	events := make(chan chat.Event, 100)
+	proxy := &MySQLProxyServer{events: &eventPublisher{events: events, counters: &chat.Counters{}, wait: true}, recordData: true}
+
+	analyzer, err := analyzeCapture(path, 3306, proxy)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, analyzer.analyzed)
+	assert.Equal(t, 1, analyzer.skipped)
+
+	var states []byte
+	var cmds []chat.Cmd
+	var results []chat.CmdResult
+	var chunks []chat.Data
+	for len(events) > 0 {
+		event := <-events
+		switch {
+		case event.ConnState != nil:
+			assert.Equal(t, "10.0.0.1:50000 => 10.0.0.2:3306", event.ConnState.ConnId)
+			states = append(states, event.ConnState.State)
+		case event.Cmd != nil:
+			cmds = append(cmds, *event.Cmd)
+		case event.CmdResult != nil:
+			results = append(results, *event.CmdResult)
+		case event.Data != nil:
+			chunks = append(chunks, *event.Data)
+		}
+	}
+
+	assert.Equal(t, []byte{protocol.ConnStateStarted, protocol.ConnStateFinished}, states)
+
+	// Events are timed as packets were captured
+	if assert.Len(t, cmds, 1) && assert.Len(t, results, 1) {
+		assert.Equal(t, "SELECT 1", cmds[0].Query)
+		assert.Equal(t, "shop", cmds[0].Database)
+		assert.Equal(t, "root", cmds[0].User)
+		assert.Equal(t, at.Add(3*time.Second), cmds[0].Time)
+		assert.Equal(t, at.Add(4*time.Second), results[0].Time)
+		assert.Equal(t, "1.000", results[0].Duration)
+	}
+
+	// Handshake packets are timed as captured too
+	if assert.True(t, len(chunks) > 2) {
+		assert.Equal(t, at, chunks[0].Time)
+		assert.Equal(t, at.Add(time.Second), chunks[1].Time)
+	}
+
+	_, err = analyzeCapture(filepath.Join(dir, "missing.pcap"), 3306, proxy)
+	assert.NotNil(t, err)
+}
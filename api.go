@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/store"
+)
+
+const (
+	apiConnectionsRoute = "/api/connections"
+	apiCommandsRoute    = "/api/commands"
+	apiCommandRoute     = "/api/command"
+	apiStatsRoute       = "/api/stats"
+	apiPcapRoute        = "/api/pcap"
+
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+)
+
+var errHistoryDisabled = errors.New("history is not kept, start lottip with --store")
+var errInvalidCursor = errors.New("invalid cursor")
+var errNoData = errors.New("no relayed bytes found, start lottip with --pcap to record them")
+
+// apiPage represents single page of listed items.
+type apiPage struct {
+	Items      interface{}
+	NextCursor string `json:",omitempty"` // Cursor of the next page, empty on the last page
+}
+
+// registerAPIRoutes registers endpoints for querying captured history kept in s.
+// Lists are ordered by time and paginated with cursor returned along with each page.
+func registerAPIRoutes(hub *chat.Hub, s *store.Store) {
+	http.HandleFunc(apiConnectionsRoute, apiHandler(s, connectionsQuery, listConnections))
+	http.HandleFunc(apiCommandsRoute, apiHandler(s, commandsQuery, listCommands))
+	http.HandleFunc(apiCommandRoute, apiHandler(s, commandQuery, getCommand))
+	http.HandleFunc(apiStatsRoute, apiHandler(s, statsQuery, func(h *history, query url.Values) (interface{}, error) {
+		value, err := getStats(h, query)
+		if err != nil {
+			return nil, err
+		}
+
+		stats := value.(*historyStats)
+		stats.Events = hub.Counters().Load()
+
+		return stats, nil
+	}))
+	http.HandleFunc(apiPcapRoute, exportPcap(hub, s))
+}
+
+// exportPcap returns handler writing bytes relayed over connections as pcapng: ?conn=&from=&to=&source=
+// Bytes are taken from memory of live hub with source=live and from history with source=store,
+// history is used by default if it's kept.
+func exportPcap(hub *chat.Hub, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		from, err := parseTimeParam(query, "from")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		to, err := parseTimeParam(query, "to")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		source := query.Get("source")
+		if source == "" {
+			source = "live"
+			if s != nil {
+				source = "store"
+			}
+		}
+
+		var chunks []*chat.Data
+		switch source {
+		case "live":
+			chunks = hub.Data(query.Get("conn"), from, to)
+
+		case "store":
+			if s == nil {
+				writeAPIError(w, http.StatusServiceUnavailable, errHistoryDisabled)
+				return
+			}
+
+			if chunks, err = loadData(s, query.Get("conn"), from, to); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+		default:
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid source: %s", source))
+			return
+		}
+
+		if len(chunks) == 0 {
+			writeAPIError(w, http.StatusNotFound, errNoData)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-pcapng")
+		w.Header().Set("Content-Disposition", `attachment; filename="lottip.pcapng"`)
+		if err := writePcap(w, chunks); err != nil {
+			log.Printf("pcap export: %s", err.Error())
+		}
+	}
+}
+
+// listConnections lists connections: ?from=&to=&user=&service=&database=&limit=&cursor=
+func listConnections(h *history, query url.Values) (interface{}, error) {
+	filter := parseConnectionFilter(query)
+
+	connections := []*ConnectionEntry{}
+	for _, conn := range h.connections {
+		if filter.match(conn) {
+			connections = append(connections, conn)
+		}
+	}
+
+	return paginate(query, len(connections), func(i int) string {
+		return cursorOf(connections[i].Started, 0, connections[i].ConnId)
+	}, func(from, to int) interface{} {
+		return connections[from:to]
+	})
+}
+
+// listCommands lists commands: ?conn=&from=&to=&database=&user=&service=&q=&errors=1&min_duration=&limit=&cursor=
+func listCommands(h *history, query url.Values) (interface{}, error) {
+	filter, err := parseCommandFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := []*CommandEntry{}
+	for _, entry := range h.commands {
+		if filter.match(entry) {
+			commands = append(commands, entry)
+		}
+	}
+
+	return paginate(query, len(commands), func(i int) string {
+		return cursorOf(commands[i].Time, commands[i].CmdId, commands[i].ConnId)
+	}, func(from, to int) interface{} {
+		return commands[from:to]
+	})
+}
+
+// getCommand returns single command with its result: ?conn=&id=
+func getCommand(h *history, query url.Values) (interface{}, error) {
+	cmdId, err := strconv.Atoi(query.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %s", query.Get("id"))
+	}
+
+	// Scan stops at the first command found, so the earliest one wins if connection address was reused
+	for i := len(h.commands) - 1; i >= 0; i-- {
+		if h.commands[i].ConnId == query.Get("conn") && h.commands[i].CmdId == cmdId {
+			return h.commands[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// getStats aggregates commands, takes the same filters as listCommands
+func getStats(h *history, query url.Values) (interface{}, error) {
+	filter, err := parseCommandFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.stats(filter), nil
+}
+
+// connectionsQuery narrows history to connections needed for requested page
+func connectionsQuery(q *historyQuery, query url.Values) (err error) {
+	q.connections = parseConnectionFilter(query)
+	if q.limit, err = parseLimit(query); err != nil {
+		return err
+	}
+	q.after, err = parseCursor(query)
+
+	return err
+}
+
+// commandsQuery narrows history to commands needed for requested page
+func commandsQuery(q *historyQuery, query url.Values) (err error) {
+	if q.commands, err = parseCommandFilter(query); err != nil {
+		return err
+	}
+	q.connId = q.commands.connId
+
+	if q.limit, err = parseLimit(query); err != nil {
+		return err
+	}
+	if q.after, err = parseCursor(query); err != nil {
+		return err
+	}
+
+	// Events before the cursor aren't needed
+	if t, ok := cursorTime(q.after); ok && t.After(q.from) {
+		q.from = t
+	}
+
+	return nil
+}
+
+// commandQuery narrows history to single command
+func commandQuery(q *historyQuery, query url.Values) (err error) {
+	if q.cmdId, err = strconv.Atoi(query.Get("id")); err != nil || q.cmdId < 1 {
+		return fmt.Errorf("invalid id: %s", query.Get("id"))
+	}
+
+	if q.connId = query.Get("conn"); q.connId == "" {
+		return errors.New("conn is required")
+	}
+
+	return nil
+}
+
+// statsQuery narrows history to connection commands are aggregated of
+func statsQuery(q *historyQuery, query url.Values) error {
+	q.connId = query.Get("conn")
+
+	return nil
+}
+
+// apiHandler returns handler which loads history of time range requested with from and to parameters
+// narrowed by narrow and writes value returned by fn as JSON. Nil value means nothing is found.
+func apiHandler(s *store.Store, narrow func(q *historyQuery, query url.Values) error, fn func(h *history, query url.Values) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s == nil {
+			writeAPIError(w, http.StatusServiceUnavailable, errHistoryDisabled)
+			return
+		}
+
+		query := r.URL.Query()
+
+		from, err := parseTimeParam(query, "from")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		to, err := parseTimeParam(query, "to")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		q := &historyQuery{from: from, to: to}
+		if err := narrow(q, query); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		h, err := loadHistory(s, q)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		value, err := fn(h, query)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if value == nil {
+			writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, value)
+	}
+}
+
+// parseCommandFilter builds command filter from query parameters
+func parseCommandFilter(query url.Values) (*commandFilter, error) {
+	filter := &commandFilter{
+		connId:     query.Get("conn"),
+		database:   query.Get("database"),
+		user:       query.Get("user"),
+		service:    query.Get("service"),
+		digest:     query.Get("digest"),
+		text:       query.Get("q"),
+		errorsOnly: query.Get("errors") == "1" || query.Get("errors") == "true",
+	}
+
+	if value := query.Get("min_duration"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_duration: %s", value)
+		}
+		filter.minDuration = duration
+	}
+
+	return filter, nil
+}
+
+// parseConnectionFilter builds connection filter from query parameters
+func parseConnectionFilter(query url.Values) *connectionFilter {
+	return &connectionFilter{
+		user:     query.Get("user"),
+		service:  query.Get("service"),
+		database: query.Get("database"),
+	}
+}
+
+// parseTimeParam parses RFC 3339 time parameter, returns zero time if it's not set
+func parseTimeParam(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s, RFC 3339 time expected: %s", name, value)
+	}
+
+	return t, nil
+}
+
+// paginate returns page of count items ordered by cursor values.
+// Page starts after item which cursor is passed in cursor parameter, limit parameter sets page size.
+func paginate(query url.Values, count int, cursor func(i int) string, items func(from, to int) interface{}) (*apiPage, error) {
+	limit, err := parseLimit(query)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseCursor(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items are ordered by time, so item next to cursor is the first one with greater cursor
+	from := 0
+	if after != "" {
+		for from < count && compareCursors(cursor(from), after) <= 0 {
+			from++
+		}
+	}
+
+	to := from + limit
+	if to > count {
+		to = count
+	}
+
+	page := &apiPage{Items: items(from, to)}
+	if to < count {
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(cursor(to - 1)))
+	}
+
+	return page, nil
+}
+
+// parseLimit returns page size set with limit parameter
+func parseLimit(query url.Values) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return apiDefaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit: %s", value)
+	}
+	if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+
+	return limit, nil
+}
+
+// parseCursor returns cursor passed in cursor parameter, empty if it's not set
+func parseCursor(query url.Values) (string, error) {
+	after, err := base64.RawURLEncoding.DecodeString(query.Get("cursor"))
+	if err != nil {
+		return "", errInvalidCursor
+	}
+
+	return string(after), nil
+}
+
+// cursorTime returns time of item cursor was returned for
+func cursorTime(cursor string) (time.Time, bool) {
+	if len(cursor) < 20 {
+		return time.Time{}, false
+	}
+
+	nanos, err := strconv.ParseInt(cursor[:20], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
+
+// cursorOf returns cursor of item, cursors of items ordered by time, id and connection compare the same way
+func cursorOf(t time.Time, id int, connId string) string {
+	return fmt.Sprintf("%020d|%010d|%s", t.UnixNano(), id, connId)
+}
+
+// compareCursors compares cursors returned by cursorOf
+func compareCursors(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+// writeJSON writes value as JSON response with given status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeAPIError writes error as JSON response with given status
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct{ Error string }{err.Error()})
+}
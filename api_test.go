@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/orderbynull/lottip/store"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+type apiAssert struct {
+	Query    string
+	Status   int
+	Commands []int // Ids of listed commands
+	Cursor   bool  // Whether next page exists
+}
+
+// openTestHistory returns store with two connections, the second one has a failed slow command
+func openTestHistory(t *testing.T, start time.Time) (*store.Store, func()) {
+	dir, err := ioutil.TempDir("", "lottip-api")
+	assert.Nil(t, err)
+
+	s, err := store.Open(dir, store.Options{})
+	assert.Nil(t, err)
+
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	events := []chat.Event{
+		{ConnState: &chat.ConnState{ConnId: "a", State: protocol.ConnStateStarted, Time: at(0), Info: &chat.ConnInfo{User: "root", Database: "shop"}}},
+		{Cmd: &chat.Cmd{ConnId: "a", CmdId: 1, Time: at(1), Database: "shop", User: "root", Query: "SELECT 1", Digest: "select", ExpectsResult: true}},
+		{CmdResult: &chat.CmdResult{ConnId: "a", CmdId: 1, Time: at(1), Result: protocol.ResponseOk, Duration: "0.001000"}},
+		{ConnState: &chat.ConnState{ConnId: "b", State: protocol.ConnStateStarted, Time: at(2), Info: &chat.ConnInfo{User: "app", Service: "billing"}}},
+		{Cmd: &chat.Cmd{ConnId: "b", CmdId: 1, Time: at(3), User: "app", Service: "billing", Query: "SELECT * FROM orders", Digest: "orders", ExpectsResult: true}},
+		{Cmd: &chat.Cmd{ConnId: "a", CmdId: 2, Time: at(4), Database: "shop", User: "root", Query: "SELECT 2", Digest: "select", ExpectsResult: true}},
+		{CmdResult: &chat.CmdResult{ConnId: "b", CmdId: 1, Time: at(5), Result: protocol.ResponseErr, Duration: "2.000000"}},
+		{ConnState: &chat.ConnState{ConnId: "b", State: protocol.ConnStateFinished, Time: at(6)}},
+	}
+
+	for _, event := range events {
+		switch {
+		case event.Cmd != nil:
+			assert.Nil(t, s.Append(event.Cmd.Time, chat.EventCmd, event.Cmd))
+		case event.CmdResult != nil:
+			assert.Nil(t, s.Append(event.CmdResult.Time, chat.EventCmdResult, event.CmdResult))
+		case event.ConnState != nil:
+			assert.Nil(t, s.Append(event.ConnState.Time, chat.EventConnState, event.ConnState))
+		}
+	}
+
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAPICommands(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, cleanup := openTestHistory(t, start)
+	defer cleanup()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiCommandsRoute, apiHandler(s, commandsQuery, listCommands))
+
+	tests := []apiAssert{
+		{"", http.StatusOK, []int{1, 1, 2}, false},
+		{"conn=a", http.StatusOK, []int{1, 2}, false},
+		{"database=shop&q=select+2", http.StatusOK, []int{2}, false},
+		{"service=billing", http.StatusOK, []int{1}, false},
+		{"user=nobody", http.StatusOK, nil, false},
+		{"digest=select", http.StatusOK, []int{1, 2}, false},
+		{"errors=1", http.StatusOK, []int{1}, false},
+		{"min_duration=1s", http.StatusOK, []int{1}, false},
+		{"from=" + url.QueryEscape(start.Add(2*time.Second).Format(time.RFC3339)), http.StatusOK, []int{1, 2}, false},
+		{"limit=2", http.StatusOK, []int{1, 1}, true},
+		{"limit=0", http.StatusBadRequest, nil, false},
+		{"min_duration=slow", http.StatusBadRequest, nil, false},
+		{"from=yesterday", http.StatusBadRequest, nil, false},
+		{"cursor=!!", http.StatusBadRequest, nil, false},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", apiCommandsRoute+"?"+test.Query, nil))
+
+		assert.Equal(t, test.Status, recorder.Code, test.Query)
+		if recorder.Code != http.StatusOK {
+			continue
+		}
+
+		// Empty page is listed as empty array, not as null
+		assert.NotContains(t, recorder.Body.String(), `"Items":null`, test.Query)
+
+		page := struct {
+			Items      []CommandEntry
+			NextCursor string
+		}{}
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+
+		var ids []int
+		for _, entry := range page.Items {
+			ids = append(ids, entry.CmdId)
+		}
+		assert.Equal(t, test.Commands, ids, test.Query)
+		assert.Equal(t, test.Cursor, page.NextCursor != "", test.Query)
+	}
+}
+
+func TestAPIPagination(t *testing.T) {
+	s, cleanup := openTestHistory(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer cleanup()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiCommandsRoute, apiHandler(s, commandsQuery, listCommands))
+
+	var queries []string
+	cursor := ""
+	for {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", apiCommandsRoute+"?limit=1&cursor="+cursor, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		page := struct {
+			Items      []CommandEntry
+			NextCursor string
+		}{}
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		assert.Len(t, page.Items, 1)
+
+		queries = append(queries, page.Items[0].Query)
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"SELECT 1", "SELECT * FROM orders", "SELECT 2"}, queries)
+}
+
+func TestAPIHistory(t *testing.T) {
+	s, cleanup := openTestHistory(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer cleanup()
+
+	h, err := loadHistory(s, &historyQuery{})
+	assert.Nil(t, err)
+
+	// Connections
+	assert.Len(t, h.connections, 2)
+	assert.Equal(t, "a", h.connections[0].ConnId)
+	assert.Equal(t, 2, h.connections[0].Commands)
+	assert.Nil(t, h.connections[0].Finished)
+	assert.Equal(t, "b", h.connections[1].ConnId)
+	assert.Equal(t, 1, h.connections[1].Errors)
+	assert.NotNil(t, h.connections[1].Finished)
+
+	// Results are joined with commands
+	assert.NotNil(t, h.commands[0].Result)
+	assert.True(t, h.commands[1].Failed())
+	assert.Equal(t, 2*time.Second, h.commands[1].Duration())
+	assert.Nil(t, h.commands[2].Result)
+
+	stats := h.stats(&commandFilter{})
+	assert.Equal(t, 2, stats.Connections)
+	assert.Equal(t, 3, stats.Commands)
+	assert.Equal(t, 1, stats.Errors)
+	assert.Equal(t, 1, stats.Pending)
+	assert.Equal(t, 2.0, stats.MaxDuration)
+	assert.Equal(t, map[string]int{"root": 2, "app": 1}, stats.ByUser)
+
+	stats = h.stats(&commandFilter{connId: "a"})
+	assert.Equal(t, 1, stats.Connections)
+	assert.Equal(t, 2, stats.Commands)
+	assert.Equal(t, 0, stats.Errors)
+}
+
+func TestAPICommand(t *testing.T) {
+	s, cleanup := openTestHistory(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer cleanup()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiCommandRoute, apiHandler(s, commandQuery, getCommand))
+
+	tests := []struct {
+		Query  string
+		Status int
+	}{
+		{"conn=b&id=1", http.StatusOK},
+		{"conn=b&id=2", http.StatusNotFound},
+		{"conn=c&id=1", http.StatusNotFound},
+		{"conn=b&id=x", http.StatusBadRequest},
+		{"id=1", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", apiCommandRoute+"?"+test.Query, nil))
+		assert.Equal(t, test.Status, recorder.Code, test.Query)
+	}
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", apiCommandRoute+"?conn=b&id=1", nil))
+
+	entry := CommandEntry{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &entry))
+	assert.Equal(t, "SELECT * FROM orders", entry.Query)
+	assert.True(t, entry.Failed())
+}
+
+func TestAPIHistoryScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-api")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := store.Open(dir, store.Options{})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	// Commands of two connections 10 seconds apart, each is answered right away
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := 1; id <= 6; id++ {
+		at := start.Add(time.Duration(id) * 10 * time.Second)
+		connId := []string{"a", "b"}[id%2]
+		assert.Nil(t, s.Append(at, chat.EventCmd, &chat.Cmd{ConnId: connId, CmdId: id, Time: at, ExpectsResult: true}))
+		assert.Nil(t, s.Append(at, chat.EventCmdResult, &chat.CmdResult{ConnId: connId, CmdId: id, Time: at, Result: protocol.ResponseOk}))
+	}
+
+	// Scan stops once page and the entry next to it are complete
+	h, err := loadHistory(s, &historyQuery{limit: 2, commands: &commandFilter{}})
+	assert.Nil(t, err)
+	assert.Len(t, h.commands, 3)
+
+	h, err = loadHistory(s, &historyQuery{limit: 2, commands: &commandFilter{}, after: cursorOf(start.Add(20*time.Second), 2, "a")})
+	assert.Nil(t, err)
+	assert.Len(t, h.commands, 5)
+
+	// Events of other connections are skipped
+	h, err = loadHistory(s, &historyQuery{connId: "b"})
+	assert.Nil(t, err)
+	assert.Len(t, h.connections, 1)
+	assert.Len(t, h.commands, 3)
+
+	// Single command is loaded along with its result
+	h, err = loadHistory(s, &historyQuery{connId: "a", cmdId: 4})
+	assert.Nil(t, err)
+	assert.Len(t, h.commands, 1)
+	assert.NotNil(t, h.commands[0].Result)
+	assert.Equal(t, 2, h.connections[0].Commands)
+}
+
+func TestAPIHistoryDisabled(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	apiHandler(nil, commandsQuery, listCommands)(recorder, httptest.NewRequest("GET", apiCommandsRoute, nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"Error":`)
+}
@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"time"
 )
@@ -8,6 +9,7 @@ import (
 const (
 	pingPeriod          = time.Millisecond * 5000
 	writeDeadlinePeriod = time.Second * 2
+	maxMessageSize      = 64 << 10
 )
 
 // Client represents client(browser) connected via websocket
@@ -15,6 +17,7 @@ type Client struct {
 	ws       *websocket.Conn
 	hub      *Hub
 	dataChan chan []byte
+	filter   *eventFilter // Accessed by hub only, nil passes all events
 }
 
 // NewClient creates new Client instance
@@ -22,7 +25,25 @@ func NewClient(ws *websocket.Conn, hub *Hub) *Client {
 	return &Client{
 		ws:       ws,
 		hub:      hub,
-		dataChan: make(chan []byte),
+		dataChan: make(chan []byte, hub.clientQueue),
+	}
+}
+
+// Listen reads messages sent by client till connection is closed.
+// See https://godoc.org/github.com/gorilla/websocket#hdr-Control_Messages for details on handling 'close' message
+func (c *Client) Listen() {
+	c.ws.SetReadLimit(maxMessageSize)
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.ws.Close()
+			return
+		}
+
+		s := subscription{client: c}
+		s.err = json.Unmarshal(data, &s.message)
+		c.hub.subscribe <- s
 	}
 }
 
@@ -0,0 +1,25 @@
+package chat
+
+import "sync/atomic"
+
+// Counters holds numbers of events passed through hub, safe for concurrent use.
+// Events are dropped rather than waited for, so capturing never slows down proxied traffic.
+type Counters struct {
+	Received       uint64 // Events received by hub
+	DroppedCapture uint64 // Events dropped since hub fell behind proxy
+	DroppedClients uint64 // Events dropped from queues of slow websocket clients
+}
+
+// Load returns copy of counters
+func (c *Counters) Load() Counters {
+	return Counters{
+		Received:       atomic.LoadUint64(&c.Received),
+		DroppedCapture: atomic.LoadUint64(&c.DroppedCapture),
+		DroppedClients: atomic.LoadUint64(&c.DroppedClients),
+	}
+}
+
+// CaptureDropped counts event dropped before it reached hub
+func (c *Counters) CaptureDropped() {
+	atomic.AddUint64(&c.DroppedCapture, 1)
+}
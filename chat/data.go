@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// dataBuffer keeps the most recent bytes relayed over connections up to size limit, so they can be exported.
+// Unlike other hub state it's read outside of hub, so it's safe for concurrent use.
+type dataBuffer struct {
+	sync.Mutex
+	chunks  []*Data
+	size    int
+	maxSize int
+}
+
+// add appends chunk of bytes dropping the oldest ones if buffer grows over its limit
+func (b *dataBuffer) add(data *Data) {
+	if b.maxSize <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.chunks = append(b.chunks, data)
+	b.size += len(data.Bytes)
+
+	for b.size > b.maxSize && len(b.chunks) > 0 {
+		b.size -= len(b.chunks[0].Bytes)
+		b.chunks[0] = nil
+		b.chunks = b.chunks[1:]
+	}
+}
+
+// find returns chunks of connection in time range [from, to) in order they were added.
+// Empty connId matches all connections, zero from or to leaves range open.
+func (b *dataBuffer) find(connId string, from, to time.Time) []*Data {
+	b.Lock()
+	defer b.Unlock()
+
+	var chunks []*Data
+	for _, data := range b.chunks {
+		if connId != "" && data.ConnId != connId ||
+			!from.IsZero() && data.Time.Before(from) ||
+			!to.IsZero() && !data.Time.Before(to) {
+			continue
+		}
+
+		chunks = append(chunks, data)
+	}
+
+	return chunks
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDataBuffer(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &dataBuffer{maxSize: 10}
+
+	b.add(&Data{ConnId: "1", Time: start, Bytes: []byte("1234")})
+	b.add(&Data{ConnId: "2", Time: start.Add(time.Second), Bytes: []byte("1234")})
+	b.add(&Data{ConnId: "1", Time: start.Add(2 * time.Second), Bytes: []byte("12")})
+	assert.Len(t, b.find("", time.Time{}, time.Time{}), 3)
+
+	// The oldest chunks are dropped once buffer is full
+	b.add(&Data{ConnId: "1", Time: start.Add(3 * time.Second), Closed: true, Bytes: []byte("1")})
+	chunks := b.find("1", time.Time{}, time.Time{})
+	assert.Len(t, chunks, 2)
+	assert.True(t, chunks[1].Closed)
+	assert.Equal(t, 7, b.size)
+
+	chunks = b.find("", start.Add(time.Second), start.Add(3*time.Second))
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, "2", chunks[0].ConnId)
+
+	// Buffer of size 0 keeps nothing
+	b = &dataBuffer{}
+	b.add(&Data{ConnId: "1", Bytes: []byte("1")})
+	assert.Empty(t, b.find("", time.Time{}, time.Time{}))
+}
+
+func TestHubData(t *testing.T) {
+	events := make(chan Event)
+	hub := NewHub(events, nil, nil, 10, 10, 1024)
+	go hub.Run()
+
+	client := &Client{hub: hub, dataChan: make(chan []byte, hub.clientQueue)}
+	hub.RegisterClient(client)
+
+	// Relayed bytes are kept but not sent to clients
+	events <- Event{Data: &Data{ConnId: "1", Bytes: []byte{0x01}}}
+	events <- Event{Cmd: &Cmd{ConnId: "1", CmdId: 1}}
+	assert.Contains(t, string(<-client.dataChan), `"CmdId":1`)
+
+	assert.Len(t, hub.Data("1", time.Time{}, time.Time{}), 1)
+	assert.Equal(t, uint64(2), hub.Counters().Load().Received)
+}
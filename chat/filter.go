@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/orderbynull/lottip/protocol"
+)
+
+// MessageSubscribe is type of message client sends to change filter of events it receives
+const MessageSubscribe = "subscribe"
+
+// ClientMessage represents message sent by client over websocket, e.g.
+// {"Type":"subscribe","Filter":{"Database":"shop","ErrorsOnly":true},"Replay":true}
+type ClientMessage struct {
+	Type   string
+	Filter *Filter // nil subscribes to all events
+	Replay bool    // Send recent events matching filter again
+}
+
+// Filter holds conditions events are sent to client on, zero value matches all events.
+// Results are sent along with their commands, so commands are held back till result arrives
+// if filter has conditions on result, i.e. ErrorsOnly or SlowerThan.
+// Connection state events match ConnIds condition only.
+type Filter struct {
+	ConnIds    []string
+	Database   string
+	User       string
+	Commands   []string // Command names, e.g. COM_QUERY
+	Query      string   // Regular expression SQL must match
+	ErrorsOnly bool
+	SlowerThan float64 // Min duration of command in milliseconds
+}
+
+// eventFilter is compiled Filter.
+type eventFilter struct {
+	Filter
+	connIds  map[string]bool
+	commands map[string]bool
+	query    *regexp.Regexp
+}
+
+// newEventFilter compiles filter, returns nil filter matching all events if f is nil
+func newEventFilter(f *Filter) (*eventFilter, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	filter := &eventFilter{Filter: *f}
+
+	if len(f.ConnIds) > 0 {
+		filter.connIds = make(map[string]bool)
+		for _, connId := range f.ConnIds {
+			filter.connIds[connId] = true
+		}
+	}
+
+	if len(f.Commands) > 0 {
+		filter.commands = make(map[string]bool)
+		for _, command := range f.Commands {
+			filter.commands[command] = true
+		}
+	}
+
+	if f.Query != "" {
+		query, err := regexp.Compile(f.Query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query filter: %s", err.Error())
+		}
+		filter.query = query
+	}
+
+	return filter, nil
+}
+
+// needsResult returns true if command can't be matched until its result arrives
+func (f *eventFilter) needsResult() bool {
+	return f != nil && (f.ErrorsOnly || f.SlowerThan > 0)
+}
+
+// matchConn returns true if events of connection pass filter
+func (f *eventFilter) matchConn(connId string) bool {
+	return f == nil || f.connIds == nil || f.connIds[connId]
+}
+
+// matchCmd returns true if command meets conditions of filter which don't depend on result
+func (f *eventFilter) matchCmd(cmd *Cmd) bool {
+	if f == nil {
+		return true
+	}
+
+	switch {
+	case !f.matchConn(cmd.ConnId),
+		f.Database != "" && cmd.Database != f.Database,
+		f.User != "" && cmd.User != f.User,
+		f.commands != nil && !f.commands[cmd.Command],
+		f.query != nil && !f.query.MatchString(cmd.Query):
+		return false
+	}
+
+	return true
+}
+
+// matchResult returns true if result meets conditions of filter on result
+func (f *eventFilter) matchResult(result *CmdResult) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.ErrorsOnly && result.Result != protocol.ResponseErr {
+		return false
+	}
+
+	if f.SlowerThan > 0 {
+		seconds, _ := strconv.ParseFloat(result.Duration, 64)
+		if seconds*1000 < f.SlowerThan {
+			return false
+		}
+	}
+
+	return true
+}
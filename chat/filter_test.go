@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type filterAssert struct {
+	Filter      *Filter
+	Cmd         bool // Whether command matches
+	Result      bool // Whether result matches
+	NeedsResult bool
+}
+
+func TestEventFilter(t *testing.T) {
+	cmd := &Cmd{ConnId: "1", Command: "COM_QUERY", Database: "shop", User: "root", Query: "SELECT * FROM orders"}
+	result := &CmdResult{ConnId: "1", Result: protocol.ResponseErr, Duration: "0.250"}
+
+	tests := []filterAssert{
+		{nil, true, true, false},
+		{&Filter{}, true, true, false},
+		{&Filter{ConnIds: []string{"2", "1"}}, true, true, false},
+		{&Filter{ConnIds: []string{"2"}}, false, true, false},
+		{&Filter{Database: "shop", User: "root"}, true, true, false},
+		{&Filter{Database: "other"}, false, true, false},
+		{&Filter{User: "app"}, false, true, false},
+		{&Filter{Commands: []string{"COM_QUERY", "COM_STMT_EXECUTE"}}, true, true, false},
+		{&Filter{Commands: []string{"COM_PING"}}, false, true, false},
+		{&Filter{Query: "(?i)^select .* from orders$"}, true, true, false},
+		{&Filter{Query: "^UPDATE"}, false, true, false},
+		{&Filter{ErrorsOnly: true}, true, true, true},
+		{&Filter{SlowerThan: 250}, true, true, true},
+		{&Filter{SlowerThan: 251}, true, false, true},
+	}
+
+	for _, test := range tests {
+		filter, err := newEventFilter(test.Filter)
+
+		assert.Nil(t, err)
+		assert.Equal(t, test.Cmd, filter.matchCmd(cmd), "%+v", test.Filter)
+		assert.Equal(t, test.Result, filter.matchResult(result), "%+v", test.Filter)
+		assert.Equal(t, test.NeedsResult, filter.needsResult(), "%+v", test.Filter)
+	}
+
+	// Errors only filter skips successful results
+	filter, _ := newEventFilter(&Filter{ErrorsOnly: true})
+	assert.False(t, filter.matchResult(&CmdResult{Result: protocol.ResponseOk}))
+
+	_, err := newEventFilter(&Filter{Query: "("})
+	assert.NotNil(t, err)
+}
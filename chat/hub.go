@@ -2,34 +2,83 @@ package chat
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/orderbynull/lottip/store"
 )
 
+// pendingCmd represents command waiting for result along with its encoding.
+type pendingCmd struct {
+	cmd  *Cmd
+	data []byte
+}
+
+// subscription represents message received from client, err is set if message can't be decoded.
+type subscription struct {
+	client  *Client
+	message ClientMessage
+	err     error
+}
+
 // Hub ...
 type Hub struct {
-	clients       map[*Client]bool
-	register      chan *Client
-	deregister    chan *Client
-	cmdChan       chan Cmd
-	cmdResultChan chan CmdResult
-	connStateChan chan ConnState
+	clients     map[*Client]bool
+	register    chan *Client
+	deregister  chan *Client
+	subscribe   chan subscription
+	events      chan Event
+	pending     map[string]map[int]*pendingCmd // Commands waiting for result by ConnId and CmdId
+	store       *store.Store                   // Store of events history, nil if history is not kept
+	sinks       []Sink                         // Sinks every command, result and connection event is written to
+	replay      *replayBuffer
+	data        dataBuffer
+	clientQueue int // Max number of events waiting to be sent to single client
+	counters    Counters
 }
 
 // NewHub ...
 func NewHub(
-	cmdChan chan Cmd,
-	cmdResultChan chan CmdResult,
-	connStateChan chan ConnState,
+	events chan Event,
+	store *store.Store,
+	sinks []Sink,
+	replaySize int,
+	clientQueue int,
+	dataBufferSize int,
 ) *Hub {
+	if clientQueue < 1 {
+		clientQueue = 1
+	}
+
 	return &Hub{
-		clients:       make(map[*Client]bool),
-		register:      make(chan *Client),
-		deregister:    make(chan *Client),
-		cmdChan:       cmdChan,
-		cmdResultChan: cmdResultChan,
-		connStateChan: connStateChan,
+		clients:     make(map[*Client]bool),
+		register:    make(chan *Client),
+		deregister:  make(chan *Client),
+		subscribe:   make(chan subscription),
+		events:      events,
+		pending:     make(map[string]map[int]*pendingCmd),
+		store:       store,
+		sinks:       sinks,
+		replay:      newReplayBuffer(replaySize),
+		data:        dataBuffer{maxSize: dataBufferSize},
+		clientQueue: clientQueue,
 	}
 }
 
+// Counters returns counters of events passed through hub
+func (h *Hub) Counters() *Counters {
+	return &h.counters
+}
+
+// Data returns the most recent bytes relayed over connection in time range [from, to) kept in memory.
+// Empty connId matches all connections, zero from or to leaves range open.
+func (h *Hub) Data(connId string, from, to time.Time) []*Data {
+	return h.data.find(connId, from, to)
+}
+
 // RegisterClient...
 func (h *Hub) RegisterClient(client *Client) {
 	h.register <- client
@@ -37,32 +86,213 @@ func (h *Hub) RegisterClient(client *Client) {
 
 // Run ...
 func (h *Hub) Run() {
-	var data []byte
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
 
+			// Client catches up with recent events before live ones
+			h.replayTo(client)
+
 		case client := <-h.deregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.dataChan)
 			}
 
-		case cmd := <-h.cmdChan:
-			data, _ = json.Marshal(cmd)
+		case s := <-h.subscribe:
+			if _, ok := h.clients[s.client]; ok {
+				h.subscribeClient(s)
+			}
+
+		case event := <-h.events:
+			var data []byte
+			var eventType string
+			var eventTime time.Time
+			var cmd *pendingCmd
+
+			switch {
+			case event.Cmd != nil:
+				data, _ = json.Marshal(event.Cmd)
+				eventType, eventTime = EventCmd, event.Cmd.Time
+				if event.Cmd.ExpectsResult {
+					h.addPending(&pendingCmd{event.Cmd, data})
+				}
+
+			case event.CmdResult != nil:
+				data, _ = json.Marshal(event.CmdResult)
+				eventType, eventTime = EventCmdResult, event.CmdResult.Time
+				cmd = h.takePending(event.CmdResult.ConnId, event.CmdResult.CmdId)
+
+			case event.ConnState != nil:
+				data, _ = json.Marshal(event.ConnState)
+				eventType, eventTime = EventConnState, event.ConnState.Time
+				if event.ConnState.State == protocol.ConnStateFinished {
+					delete(h.pending, event.ConnState.ConnId)
+				}
+
+			case event.Data != nil:
+				// Relayed bytes are kept for export only, clients don't get them
+				atomic.AddUint64(&h.counters.Received, 1)
+				h.data.add(event.Data)
+				if h.store != nil {
+					data, _ = json.Marshal(event.Data)
+					h.record(event.Data.Time, EventData, data)
+				}
+				continue
+			}
+			atomic.AddUint64(&h.counters.Received, 1)
+
+			if len(data) == 0 {
+				continue
+			}
 
-		case cmdResult := <-h.cmdResultChan:
-			data, _ = json.Marshal(cmdResult)
+			h.record(eventTime, eventType, data)
+			h.sink(event, cmd)
+			h.replay.add(data, event)
 
-		case connState := <-h.connStateChan:
-			data, _ = json.Marshal(connState)
+			for client := range h.clients {
+				h.deliver(client, event, data, cmd)
+			}
 		}
+	}
+}
 
-		for client := range h.clients {
-			if len(data) > 0 {
-				client.dataChan <- data
+// subscribeClient replaces filter of client, recent events matching new filter are sent again if requested
+func (h *Hub) subscribeClient(s subscription) {
+	if s.err == nil && s.message.Type != MessageSubscribe {
+		s.err = fmt.Errorf("unknown message type: %s", s.message.Type)
+	}
+
+	filter, err := newEventFilter(s.message.Filter)
+	if s.err == nil {
+		s.err = err
+	}
+
+	if s.err != nil {
+		data, _ := json.Marshal(struct{ Error string }{s.err.Error()})
+		h.send(s.client, data)
+		return
+	}
+
+	s.client.filter = filter
+	if s.message.Replay {
+		h.replayTo(s.client)
+	}
+}
+
+// replayTo sends recent events matching client's filter.
+// Results are matched against commands found in replay buffer, commands waiting for result are sent once it arrives.
+func (h *Hub) replayTo(client *Client) {
+	cmds := make(map[string]map[int]*pendingCmd)
+
+	for _, event := range h.replay.snapshot() {
+		var cmd *pendingCmd
+
+		switch {
+		case event.event.Cmd != nil:
+			if cmds[event.event.Cmd.ConnId] == nil {
+				cmds[event.event.Cmd.ConnId] = make(map[int]*pendingCmd)
 			}
+			cmds[event.event.Cmd.ConnId][event.event.Cmd.CmdId] = &pendingCmd{event.event.Cmd, event.data}
+
+		case event.event.CmdResult != nil:
+			cmd = cmds[event.event.CmdResult.ConnId][event.event.CmdResult.CmdId]
+		}
+
+		h.deliver(client, event.event, event.data, cmd)
+	}
+}
+
+// deliver sends encoded event to client if it matches client's filter.
+// cmd is command result event belongs to, nil if it's unknown.
+func (h *Hub) deliver(client *Client, event Event, data []byte, cmd *pendingCmd) {
+	filter := client.filter
+
+	switch {
+	case event.Cmd != nil:
+		if filter.matchCmd(event.Cmd) && !filter.needsResult() {
+			h.send(client, data)
+		}
+
+	case event.CmdResult != nil:
+		if filter == nil {
+			h.send(client, data)
+			return
+		}
+
+		if cmd == nil || !filter.matchCmd(cmd.cmd) || !filter.matchResult(event.CmdResult) {
+			return
+		}
+
+		// Command was held back till now
+		if filter.needsResult() {
+			h.send(client, cmd.data)
+		}
+		h.send(client, data)
+
+	case event.ConnState != nil:
+		if filter.matchConn(event.ConnState.ConnId) {
+			h.send(client, data)
+		}
+	}
+}
+
+// addPending keeps command till its result arrives
+func (h *Hub) addPending(cmd *pendingCmd) {
+	if h.pending[cmd.cmd.ConnId] == nil {
+		h.pending[cmd.cmd.ConnId] = make(map[int]*pendingCmd)
+	}
+	h.pending[cmd.cmd.ConnId][cmd.cmd.CmdId] = cmd
+}
+
+// takePending returns and forgets command waiting for result, nil if there's no such command
+func (h *Hub) takePending(connId string, cmdId int) *pendingCmd {
+	cmd := h.pending[connId][cmdId]
+	delete(h.pending[connId], cmdId)
+
+	return cmd
+}
+
+// send queues event for client without waiting, so single slow client can't hold up others.
+// The oldest queued event is dropped if client's queue is full.
+func (h *Hub) send(client *Client, data []byte) {
+	for {
+		select {
+		case client.dataChan <- data:
+			return
+		default:
+		}
+
+		select {
+		case <-client.dataChan:
+			atomic.AddUint64(&h.counters.DroppedClients, 1)
+		default:
+		}
+	}
+}
+
+// record appends event to history store if history is kept
+func (h *Hub) record(t time.Time, eventType string, data []byte) {
+	if h.store == nil {
+		return
+	}
+
+	if err := h.store.Append(t, eventType, json.RawMessage(data)); err != nil {
+		log.Printf("store: %s", err.Error())
+	}
+}
+
+// sink writes event to every sink, cmd is command result event belongs to, nil if it's unknown
+func (h *Hub) sink(event Event, cmd *pendingCmd) {
+	var sinkCmd *Cmd
+	if cmd != nil {
+		sinkCmd = cmd.cmd
+	}
+
+	for _, sink := range h.sinks {
+		if err := sink.Write(event, sinkCmd); err != nil {
+			log.Printf("sink: %s", err.Error())
 		}
 	}
 }
@@ -0,0 +1,148 @@
+package chat
+
+import (
+	"encoding/json"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/orderbynull/lottip/slowlog"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHubSend(t *testing.T) {
+	hub := NewHub(make(chan Event), nil, nil, 0, 2, 0)
+	client := &Client{hub: hub, dataChan: make(chan []byte, hub.clientQueue)}
+
+	// Client which doesn't read its queue loses the oldest events
+	hub.send(client, []byte("1"))
+	hub.send(client, []byte("2"))
+	hub.send(client, []byte("3"))
+
+	assert.Equal(t, []byte("2"), <-client.dataChan)
+	assert.Equal(t, []byte("3"), <-client.dataChan)
+	assert.Equal(t, Counters{DroppedClients: 1}, hub.Counters().Load())
+}
+
+func TestHubRun(t *testing.T) {
+	events := make(chan Event, 1)
+	hub := NewHub(events, nil, nil, 10, 10, 0)
+	go hub.Run()
+
+	events <- Event{Cmd: &Cmd{ConnId: "1", CmdId: 1}}
+
+	// Client registered later gets recent events first
+	client := &Client{hub: hub, dataChan: make(chan []byte, hub.clientQueue)}
+	hub.RegisterClient(client)
+	assert.Contains(t, string(<-client.dataChan), `"CmdId":1`)
+
+	events <- Event{CmdResult: &CmdResult{ConnId: "1", CmdId: 1}}
+	assert.Contains(t, string(<-client.dataChan), `"Result":0,`)
+
+	hub.deregister <- client
+	_, ok := <-client.dataChan
+	assert.False(t, ok)
+	assert.Equal(t, uint64(2), hub.Counters().Load().Received)
+}
+
+// receive returns events queued for client
+func receive(client *Client) []string {
+	var events []string
+	for {
+		select {
+		case data := <-client.dataChan:
+			events = append(events, string(data))
+		default:
+			return events
+		}
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	hub := NewHub(make(chan Event), nil, nil, 10, 10, 0)
+	client := &Client{hub: hub, dataChan: make(chan []byte, hub.clientQueue)}
+	hub.clients[client] = true
+
+	publish := func(event Event) {
+		data, _ := json.Marshal(event)
+		var cmd *pendingCmd
+		switch {
+		case event.Cmd != nil:
+			hub.addPending(&pendingCmd{event.Cmd, data})
+		case event.CmdResult != nil:
+			cmd = hub.takePending(event.CmdResult.ConnId, event.CmdResult.CmdId)
+		}
+		hub.replay.add(data, event)
+		hub.deliver(client, event, data, cmd)
+	}
+
+	// Commands are held back till their results show they're failed
+	hub.subscribeClient(subscription{client: client, message: ClientMessage{Type: MessageSubscribe, Filter: &Filter{ErrorsOnly: true}}})
+	publish(Event{ConnState: &ConnState{ConnId: "1", State: protocol.ConnStateStarted}})
+	publish(Event{Cmd: &Cmd{ConnId: "1", CmdId: 1, Query: "SELECT 1", ExpectsResult: true}})
+	publish(Event{Cmd: &Cmd{ConnId: "1", CmdId: 2, Query: "SELECT nope", ExpectsResult: true}})
+	assert.Len(t, receive(client), 1)
+
+	publish(Event{CmdResult: &CmdResult{ConnId: "1", CmdId: 1, Result: protocol.ResponseOk}})
+	assert.Empty(t, receive(client))
+
+	publish(Event{CmdResult: &CmdResult{ConnId: "1", CmdId: 2, Result: protocol.ResponseErr}})
+	events := receive(client)
+	assert.Len(t, events, 2)
+	assert.Contains(t, events[0], `"SELECT nope"`)
+	assert.Contains(t, events[1], `"Result":255`)
+
+	// Recent events matching new filter are sent again on request
+	hub.subscribeClient(subscription{client: client, message: ClientMessage{Type: MessageSubscribe, Filter: &Filter{Query: "1$"}, Replay: true}})
+	events = receive(client)
+	assert.Len(t, events, 3)
+	assert.Contains(t, events[1], `"SELECT 1"`)
+	assert.Contains(t, events[2], `"Result":0`)
+
+	// Invalid subscription keeps previous filter
+	hub.subscribeClient(subscription{client: client, message: ClientMessage{Type: MessageSubscribe, Filter: &Filter{Query: "("}}})
+	hub.subscribeClient(subscription{client: client, message: ClientMessage{Type: "unsubscribe"}})
+	events = receive(client)
+	assert.Len(t, events, 2)
+	assert.Contains(t, events[0], `"Error":"invalid query filter`)
+	assert.Contains(t, events[1], `"Error":"unknown message type: unsubscribe"`)
+	assert.Equal(t, "1$", client.filter.Query)
+}
+
+func TestHubSlowLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-hub")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "slow.log")
+	slowLog, err := slowlog.Open(path, slowlog.Options{MinDuration: time.Second})
+	assert.Nil(t, err)
+
+	events := make(chan Event)
+	hub := NewHub(events, nil, []Sink{NewSlowLogSink(slowLog)}, 0, 10, 0)
+	go hub.Run()
+
+	at := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	connId := "127.0.0.1:50000" + ConnIdSeparator + "127.0.0.1:3306"
+	events <- Event{Cmd: &Cmd{ConnId: connId, CmdId: 1, Time: at, Command: "COM_QUERY", Query: "SELECT 1", User: "root", ExpectsResult: true}}
+	events <- Event{Cmd: &Cmd{
+		ConnId: connId, CmdId: 2, Time: at, Command: "COM_STMT_EXECUTE", Query: "SELECT ?", RunnableQuery: "SELECT 2",
+		User: "root", Database: "shop", ExpectsResult: true,
+	}}
+	events <- Event{CmdResult: &CmdResult{ConnId: connId, CmdId: 2, Time: at.Add(2 * time.Second), Rows: 1}}
+	events <- Event{CmdResult: &CmdResult{ConnId: connId, CmdId: 1, Time: at.Add(time.Millisecond)}}
+
+	// Result of unknown command is not logged
+	events <- Event{CmdResult: &CmdResult{ConnId: connId, CmdId: 3, Time: at.Add(time.Hour)}}
+	hub.RegisterClient(&Client{hub: hub, dataChan: make(chan []byte, hub.clientQueue)}) // Returns once events are processed
+	assert.Nil(t, slowLog.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(slowlog.Format(slowlog.Entry{
+		Time: at, Duration: 2 * time.Second, User: "root", Host: "127.0.0.1", Database: "shop",
+		Command: "COM_STMT_EXECUTE", Query: "SELECT 2", RowsSent: 1,
+	})), string(data))
+}
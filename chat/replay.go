@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"sort"
+
+	"github.com/orderbynull/lottip/protocol"
+)
+
+// replayEvent represents encoded event kept for clients connected later.
+type replayEvent struct {
+	seq   uint64
+	data  []byte
+	event Event
+}
+
+// replayBuffer keeps the most recent events in ring of fixed size along with started
+// events of all active connections, so client connected later sees what's going on.
+type replayBuffer struct {
+	events []replayEvent // Ring of recent events, next points to the oldest one once ring is full
+	next   int
+	full   bool
+	seq    uint64                 // Sequence number of the last event
+	active map[string]replayEvent // Started event of active connection by ConnId
+}
+
+// newReplayBuffer creates buffer of size recent events, 0 keeps active connections only
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{
+		events: make([]replayEvent, size),
+		active: make(map[string]replayEvent),
+	}
+}
+
+// add appends event along with its encoding to buffer
+func (b *replayBuffer) add(data []byte, e Event) {
+	b.seq++
+	event := replayEvent{seq: b.seq, data: data, event: e}
+
+	if connState := e.ConnState; connState != nil {
+		switch connState.State {
+		case protocol.ConnStateStarted:
+			b.active[connState.ConnId] = event
+		case protocol.ConnStateFinished:
+			delete(b.active, connState.ConnId)
+		}
+	}
+
+	if len(b.events) == 0 {
+		return
+	}
+
+	b.events[b.next] = event
+	b.next++
+	if b.next == len(b.events) {
+		b.next, b.full = 0, true
+	}
+}
+
+// snapshot returns events to be sent to new client in order they were added:
+// started events of active connections which are no longer in ring followed by ring contents.
+func (b *replayBuffer) snapshot() []replayEvent {
+	recent := b.events[:b.next]
+	if b.full {
+		recent = append(append([]replayEvent{}, b.events[b.next:]...), b.events[:b.next]...)
+	}
+
+	oldest := b.seq + 1
+	if len(recent) > 0 {
+		oldest = recent[0].seq
+	}
+
+	var started []replayEvent
+	for _, event := range b.active {
+		if event.seq < oldest {
+			started = append(started, event)
+		}
+	}
+	sort.Slice(started, func(i, j int) bool { return started[i].seq < started[j].seq })
+
+	return append(started, recent...)
+}
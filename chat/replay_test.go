@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// snapshotData returns encodings of events in buffer snapshot
+func snapshotData(b *replayBuffer) [][]byte {
+	var data [][]byte
+	for _, event := range b.snapshot() {
+		data = append(data, event.data)
+	}
+
+	return data
+}
+
+func TestReplayBuffer(t *testing.T) {
+	b := newReplayBuffer(3)
+	assert.Empty(t, snapshotData(b))
+
+	b.add([]byte("start 1"), Event{ConnState: &ConnState{ConnId: "1", State: protocol.ConnStateStarted}})
+	b.add([]byte("start 2"), Event{ConnState: &ConnState{ConnId: "2", State: protocol.ConnStateStarted}})
+	b.add([]byte("cmd 1"), Event{})
+	assert.Equal(t, [][]byte{[]byte("start 1"), []byte("start 2"), []byte("cmd 1")}, snapshotData(b))
+
+	// Started events of active connections outlive ring
+	b.add([]byte("finish 2"), Event{ConnState: &ConnState{ConnId: "2", State: protocol.ConnStateFinished}})
+	b.add([]byte("result 1"), Event{})
+	b.add([]byte("cmd 2"), Event{})
+	assert.Equal(t, [][]byte{[]byte("start 1"), []byte("finish 2"), []byte("result 1"), []byte("cmd 2")}, snapshotData(b))
+
+	b.add([]byte("finish 1"), Event{ConnState: &ConnState{ConnId: "1", State: protocol.ConnStateFinished}})
+	assert.Equal(t, [][]byte{[]byte("result 1"), []byte("cmd 2"), []byte("finish 1")}, snapshotData(b))
+
+	// Ring of size 0 keeps active connections only
+	b = newReplayBuffer(0)
+	b.add([]byte("start 1"), Event{ConnState: &ConnState{ConnId: "1", State: protocol.ConnStateStarted}})
+	b.add([]byte("cmd 1"), Event{})
+	assert.Equal(t, [][]byte{[]byte("start 1")}, snapshotData(b))
+}
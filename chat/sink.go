@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/orderbynull/lottip/slowlog"
+)
+
+// JSONLinesSchema is version of JSON lines written by JSONLinesSink.
+// It's changed only when fields are removed or change their meaning, new fields may be added any time.
+const JSONLinesSchema = 1
+
+// finishedConns is number of finished connections JSONLinesSink keeps details of, events may arrive once connection is finished
+const finishedConns = 1000
+
+// Sink receives every command, result and connection event passed through hub in order they happened.
+// Sinks are fed by hub one after another, so slow sink holds up web UI too.
+type Sink interface {
+	// Write receives event, cmd is command result event belongs to, nil for other events or if command is unknown
+	Write(event Event, cmd *Cmd) error
+}
+
+// SlowLogSink writes completed commands to slow log.
+type SlowLogSink struct {
+	log *slowlog.Log
+}
+
+// NewSlowLogSink returns sink writing to slow log
+func NewSlowLogSink(log *slowlog.Log) *SlowLogSink {
+	return &SlowLogSink{log: log}
+}
+
+// Write implements Sink, commands are written once their result arrives
+func (s *SlowLogSink) Write(event Event, cmd *Cmd) error {
+	if event.CmdResult == nil || cmd == nil {
+		return nil
+	}
+
+	return s.log.Write(slowLogEntry(cmd, event.CmdResult))
+}
+
+// slowLogEntry returns command along with its result as slow log entry.
+// Prepared statements are logged with parameter values inlined if they're known.
+func slowLogEntry(cmd *Cmd, result *CmdResult) slowlog.Entry {
+	query := cmd.Query
+	if cmd.RunnableQuery != "" {
+		query = cmd.RunnableQuery
+	}
+
+	return slowlog.Entry{
+		Time:         cmd.Time,
+		Duration:     result.Time.Sub(cmd.Time),
+		User:         cmd.User,
+		Host:         clientHost(cmd.ConnId),
+		Database:     cmd.Database,
+		Command:      cmd.Command,
+		Query:        query,
+		RowsSent:     result.Rows,
+		RowsAffected: result.AffectedRows,
+	}
+}
+
+// connAddrs returns client and server addresses from ConnId, server address is empty if it can't be found
+func connAddrs(connId string) (string, string) {
+	parts := strings.SplitN(connId, ConnIdSeparator, 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// clientHost returns IP address of client from ConnId, empty string if it can't be found
+func clientHost(connId string) string {
+	client, _ := connAddrs(connId)
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		return host
+	}
+
+	return ""
+}
+
+// JSONLine represents single line written by JSONLinesSink, exactly one of Cmd, CmdResult and ConnState is set.
+type JSONLine struct {
+	Schema    int
+	Time      time.Time  // Moment event happened
+	Type      string     // Type of event as recorded in store, e.g. cmd or result
+	Conn      ConnMeta   // Connection event belongs to
+	Cmd       *Cmd       `json:",omitempty"`
+	CmdResult *CmdResult `json:",omitempty"`
+	ConnState *ConnState `json:",omitempty"`
+}
+
+// ConnMeta holds details of connection known at the moment event happened.
+// Details negotiated during handshake are empty if connection started before sink was created.
+type ConnMeta struct {
+	ConnId        string
+	Client        string // Address of client
+	Server        string // Address of MySQL server
+	ConnectionID  uint32 // Connection id assigned by MySQL server
+	ServerVersion string
+	User          string
+	Database      string // Database selected at the moment
+	Service       string // Client program_name connection attribute
+	TLS           bool
+}
+
+// JSONLinesSink writes each event as JSON object on its own line, e.g. to file or stdout.
+type JSONLinesSink struct {
+	sync.Mutex
+	w        io.Writer
+	conns    map[string]*ConnMeta // Connections by ConnId
+	finished []*ConnMeta          // Finished connections, the oldest first
+}
+
+// NewJSONLinesSink returns sink writing to w
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w, conns: make(map[string]*ConnMeta)}
+}
+
+// Write implements Sink
+func (s *JSONLinesSink) Write(event Event, cmd *Cmd) error {
+	s.Lock()
+	defer s.Unlock()
+
+	line := JSONLine{Schema: JSONLinesSchema, Cmd: event.Cmd, CmdResult: event.CmdResult, ConnState: event.ConnState}
+
+	var conn *ConnMeta
+	switch {
+	case event.Cmd != nil:
+		line.Time, line.Type = event.Cmd.Time, EventCmd
+		conn = s.conn(event.Cmd.ConnId)
+		conn.User, conn.Database, conn.Service = event.Cmd.User, event.Cmd.Database, event.Cmd.Service
+
+	case event.CmdResult != nil:
+		line.Time, line.Type = event.CmdResult.Time, EventCmdResult
+		conn = s.conn(event.CmdResult.ConnId)
+
+	case event.ConnState != nil:
+		line.Time, line.Type = event.ConnState.Time, EventConnState
+
+		// Address of finished connection may be reused by new one
+		if event.ConnState.State == protocol.ConnStateStarted {
+			delete(s.conns, event.ConnState.ConnId)
+		}
+		conn = s.conn(event.ConnState.ConnId)
+
+		if info := event.ConnState.Info; info != nil {
+			conn.ConnectionID, conn.ServerVersion, conn.TLS = info.ConnectionID, info.ServerVersion, info.TLS
+			conn.User, conn.Database, conn.Service = info.User, info.Database, info.Service
+		}
+
+		if event.ConnState.State == protocol.ConnStateFinished {
+			s.finish(conn)
+		}
+
+	default:
+		return nil
+	}
+	line.Conn = *conn
+
+	data, err := json.Marshal(&line)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.w.Write(append(data, '\n'))
+
+	return err
+}
+
+// finish forgets details of the oldest finished connection once there're too many of them
+func (s *JSONLinesSink) finish(conn *ConnMeta) {
+	s.finished = append(s.finished, conn)
+	if len(s.finished) <= finishedConns {
+		return
+	}
+
+	oldest := s.finished[0]
+	s.finished = s.finished[1:]
+	if s.conns[oldest.ConnId] == oldest {
+		delete(s.conns, oldest.ConnId)
+	}
+}
+
+// conn returns details of connection, they're kept for a while after connection is finished
+func (s *JSONLinesSink) conn(connId string) *ConnMeta {
+	conn, ok := s.conns[connId]
+	if !ok {
+		conn = &ConnMeta{ConnId: connId}
+		conn.Client, conn.Server = connAddrs(connId)
+		s.conns[connId] = conn
+	}
+
+	return conn
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClientHost(t *testing.T) {
+	tests := map[string]string{
+		"127.0.0.1:50000 => 127.0.0.1:3306": "127.0.0.1",
+		"[::1]:50000 => [::1]:3306":         "::1",
+		"1":                                 "",
+	}
+
+	for connId, host := range tests {
+		assert.Equal(t, host, clientHost(connId), connId)
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewJSONLinesSink(buf)
+
+	at := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	connId := "127.0.0.1:50000" + ConnIdSeparator + "127.0.0.1:3306"
+	info := &ConnInfo{ConnectionID: 7, ServerVersion: "8.0.0", User: "root", Database: "shop", TLS: true}
+	cmd := &Cmd{ConnId: connId, CmdId: 1, Time: at, Command: "COM_INIT_DB", Query: "USE other", User: "root", Database: "other"}
+
+	events := []Event{
+		{ConnState: &ConnState{ConnId: connId, Time: at, State: protocol.ConnStateStarted, Info: info}},
+		{Cmd: cmd},
+		{CmdResult: &CmdResult{ConnId: connId, CmdId: 1, Time: at.Add(time.Second)}},
+		{ConnState: &ConnState{ConnId: connId, Time: at.Add(2 * time.Second), State: protocol.ConnStateFinished}},
+		{ConnState: &ConnState{ConnId: connId, Time: at.Add(2 * time.Second), State: protocol.ConnStateFinished}},
+		{ConnState: &ConnState{ConnId: connId, Time: at.Add(3 * time.Second), State: protocol.ConnStateStarted}},
+		{Data: &Data{ConnId: connId, Time: at}},
+		{ConnState: &ConnState{ConnId: "1", Time: at, State: protocol.ConnStateFinished}},
+	}
+	for _, event := range events {
+		assert.Nil(t, sink.Write(event, nil))
+	}
+
+	var lines []JSONLine
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := JSONLine{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	// Relayed bytes are not written
+	if !assert.Len(t, lines, 7) {
+		return
+	}
+
+	conn := ConnMeta{
+		ConnId: connId, Client: "127.0.0.1:50000", Server: "127.0.0.1:3306",
+		ConnectionID: 7, ServerVersion: "8.0.0", User: "root", Database: "shop", TLS: true,
+	}
+	assert.Equal(t, JSONLine{Schema: JSONLinesSchema, Time: at, Type: EventConnState, Conn: conn, ConnState: events[0].ConnState}, lines[0])
+
+	// Connection details follow database selected by command
+	conn.Database = "other"
+	assert.Equal(t, JSONLine{Schema: JSONLinesSchema, Time: at, Type: EventCmd, Conn: conn, Cmd: cmd}, lines[1])
+	assert.Equal(t, EventCmdResult, lines[2].Type)
+	assert.Equal(t, at.Add(time.Second), lines[2].Time)
+	assert.Equal(t, conn, lines[2].Conn)
+	assert.Equal(t, conn, lines[3].Conn)
+
+	// Details are kept for events coming after connection is finished, but not for new connection with the same addresses
+	assert.Equal(t, conn, lines[4].Conn)
+	assert.Equal(t, ConnMeta{ConnId: connId, Client: "127.0.0.1:50000", Server: "127.0.0.1:3306"}, lines[5].Conn)
+
+	// Connection started before sink was created has addresses only
+	assert.Equal(t, ConnMeta{ConnId: "1", Client: "1"}, lines[6].Conn)
+}
+
+func TestJSONLinesSinkFinished(t *testing.T) {
+	sink := NewJSONLinesSink(&bytes.Buffer{})
+
+	for i := 0; i <= finishedConns; i++ {
+		connId := fmt.Sprintf("127.0.0.1:%d => 127.0.0.1:3306", 10000+i)
+		assert.Nil(t, sink.Write(Event{ConnState: &ConnState{ConnId: connId, State: protocol.ConnStateStarted}}, nil))
+		assert.Nil(t, sink.Write(Event{ConnState: &ConnState{ConnId: connId, State: protocol.ConnStateFinished}}, nil))
+	}
+
+	// Details of the oldest finished connection are forgotten
+	assert.Len(t, sink.conns, finishedConns)
+	assert.NotContains(t, sink.conns, "127.0.0.1:10000 => 127.0.0.1:3306")
+}
@@ -1,26 +1,112 @@
 package chat
 
+import "time"
+
+// ConnIdSeparator separates client and server addresses in ConnId
+const ConnIdSeparator = " => "
+
+// Event types as recorded in store
+const (
+	EventCmd       = "cmd"
+	EventCmdResult = "result"
+	EventConnState = "conn"
+	EventData      = "data"
+)
+
+// Event holds single captured event, exactly one field is set.
+// Events of all kinds go through single channel, so they're processed in order they happened.
+type Event struct {
+	Cmd       *Cmd
+	CmdResult *CmdResult
+	ConnState *ConnState
+	Data      *Data
+}
+
 // Cmd represents MySQL command to be executed.
+// Command holds command name, e.g. COM_QUERY or COM_INIT_DB, and Query holds SQL doing the same,
+// e.g. USE `shop`, or command name if there's no such SQL.
 type Cmd struct {
 	ConnId     string
 	CmdId      int
+	Time       time.Time // Moment command was sent by client
+	Command    string
 	Database   string
+	User       string // Account connection is authenticated as
+	Service    string // Client program_name connection attribute
 	Query      string
-	Parameters []string
+	Arguments  map[string]string `json:",omitempty"`
+	Parameters []*string         // Values of prepared statement parameters, nil for NULL
 	Executable bool
+	// RunnableQuery is prepared statement SQL with parameter values inlined, empty for plain queries
+	RunnableQuery string
+	// ExpectsResult is false for commands server never replies to, so there will be no CmdResult
+	ExpectsResult bool
+	// Fingerprint is query with literals replaced by ?, set for queries and prepared statements
+	Fingerprint string `json:",omitempty"`
+	// Digest is hash of Fingerprint, it's the same for queries of the same shape
+	Digest string `json:",omitempty"`
+	// Frames is number of frames request was split into, set only for requests of 16MB or longer
+	Frames int `json:",omitempty"`
 }
 
 // CmdResult represents MySQL command execution result.
+// Result holds kind of response: OK, ERR, result set or LOCAL INFILE request.
 type CmdResult struct {
-	ConnId   string
-	CmdId    int
-	Result   byte
-	Error    string
-	Duration string
+	ConnId       string
+	CmdId        int
+	Time         time.Time // Moment the last packet of response was received
+	Result       byte
+	Error        string
+	Duration     string
+	Columns      []Column
+	Rows         uint64
+	AffectedRows uint64
+	LastInsertID uint64
+	Warnings     uint16
+	Sample       [][]string // First rows sent back to client
+	SampleCut    bool       // True if result set has more rows than Sample holds
+}
+
+// Column represents single column of result set returned by MySQL.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Data represents bytes relayed over connection as seen by proxy, i.e. decrypted and decompressed.
+// Closed marks the end of connection and comes with no bytes.
+type Data struct {
+	ConnId     string
+	Time       time.Time // Moment the first of bytes was relayed
+	FromServer bool
+	Offset     uint64 // Number of bytes sent by the same side before, bytes of dropped chunks leave gap
+	Bytes      []byte
+	Closed     bool `json:",omitempty"`
 }
 
 // ConnState represents tcp connection state.
+// Info is sent only along with connection started state.
 type ConnState struct {
 	ConnId string
+	Time   time.Time
 	State  byte
+	Info   *ConnInfo `json:",omitempty"`
+}
+
+// ConnInfo represents MySQL session details negotiated during handshake.
+type ConnInfo struct {
+	ServerVersion string
+	ConnectionID  uint32
+	User          string
+	Database      string            // Database selected in handshake
+	Service       string            // Client program_name connection attribute
+	ConnectAttrs  map[string]string `json:",omitempty"` // Connection attributes sent by client, e.g. _client_name or _pid
+	AuthPlugin    string            // Auth plugin which completed authentication
+	AuthPath      string            // fast or full for caching_sha2_password, empty for other plugins
+	AuthPublicKey bool              // True if password was encrypted with server RSA public key
+	AuthError     string            // Reason of authentication failure, empty if client was accepted
+	AuthTrips     int               // Number of round trips authentication took
+	Capabilities  []string
+	TLS           bool   // True if client connected over TLS
+	Compression   string // Compression algorithm, zlib or zstd, empty if compression is off
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/pcapng"
+	"github.com/orderbynull/lottip/store"
+)
+
+// loadData reads bytes relayed over connection in time range [from, to) recorded in store.
+// Empty connId matches all connections, zero from or to leaves range open.
+func loadData(s *store.Store, connId string, from, to time.Time) ([]*chat.Data, error) {
+	var chunks []*chat.Data
+
+	err := s.Scan(from, to, func(record *store.Record) bool {
+		if record.Type != chat.EventData {
+			return true
+		}
+
+		data := &chat.Data{}
+		if json.Unmarshal(record.Event, data) == nil && (connId == "" || data.ConnId == connId) {
+			chunks = append(chunks, data)
+		}
+
+		return true
+	})
+
+	return chunks, err
+}
+
+// writePcap writes relayed bytes as pcapng capture, each connection becomes TCP stream between addresses of its ConnId.
+// Chunks must be in order they were recorded in. Bytes of chunks dropped while recording are left out of stream
+// with sequence numbers advanced over them, so Wireshark shows them as not captured instead of misreading the rest.
+func writePcap(w io.Writer, chunks []*chat.Data) error {
+	writer, err := pcapng.NewWriter(w, "lottip")
+	if err != nil {
+		return err
+	}
+
+	streams := make(map[string]*pcapng.Stream)
+	for _, data := range chunks {
+		// Address of closed connection may be reused by another connection
+		stream, ok := streams[data.ConnId]
+		if !ok || stream.Closed() {
+			client, server := connAddrs(data.ConnId, len(streams))
+			stream = writer.NewStream(client, server)
+			streams[data.ConnId] = stream
+		}
+
+		if data.Closed {
+			err = stream.Close(data.Time)
+		} else {
+			err = stream.WriteAt(data.Time, data.FromServer, data.Offset, data.Bytes)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// connAddrs returns client and server addresses of connection.
+// Made up addresses unique by n are returned if they can't be parsed from ConnId.
+func connAddrs(connId string, n int) (*net.TCPAddr, *net.TCPAddr) {
+	if parts := strings.SplitN(connId, chat.ConnIdSeparator, 2); len(parts) == 2 {
+		client, clientErr := parseTCPAddr(parts[0])
+		server, serverErr := parseTCPAddr(parts[1])
+		if clientErr == nil && serverErr == nil {
+			return client, server
+		}
+	}
+
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 10000 + n%50000}, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3306}
+}
+
+// parseTCPAddr parses <ip>:<port> address without resolving host names
+func parseTCPAddr(addr string) (*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, &net.AddrError{Err: "invalid IP address", Addr: host}
+	}
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(portNum)}, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/store"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type connAddrsAssert struct {
+	ConnId string
+	Client string
+	Server string
+}
+
+func TestConnAddrs(t *testing.T) {
+	tests := []connAddrsAssert{
+		{"127.0.0.1:50000 => 127.0.0.1:3306", "127.0.0.1:50000", "127.0.0.1:3306"},
+		{"[::1]:50000 => [::1]:3306", "[::1]:50000", "[::1]:3306"},
+		{"client => server", "10.0.0.1:10002", "10.0.0.2:3306"},
+		{"127.0.0.1:50000 => db:3306", "10.0.0.1:10002", "10.0.0.2:3306"},
+	}
+
+	for _, test := range tests {
+		client, server := connAddrs(test.ConnId, 2)
+
+		assert.Equal(t, test.Client, client.String(), test.ConnId)
+		assert.Equal(t, test.Server, server.String(), test.ConnId)
+	}
+}
+
+// capturedPayloads returns sizes of TCP payloads of frames in pcapng capture of IPv4 streams
+func capturedPayloads(t *testing.T, capture []byte) []int {
+	var payloads []int
+	for len(capture) > 0 {
+		blockType := binary.LittleEndian.Uint32(capture)
+		length := binary.LittleEndian.Uint32(capture[4:])
+
+		// Enhanced packet block
+		if blockType == 6 {
+			frameLength := int(binary.LittleEndian.Uint32(capture[20:]))
+			payloads = append(payloads, frameLength-14-20-20)
+		}
+
+		capture = capture[length:]
+	}
+
+	return payloads
+}
+
+func TestWritePcap(t *testing.T) {
+	at := time.Now()
+	chunks := []*chat.Data{
+		{ConnId: "127.0.0.1:50000 => 127.0.0.1:3306", Time: at, FromServer: true, Bytes: make([]byte, 10)},
+		{ConnId: "127.0.0.1:50001 => 127.0.0.1:3306", Time: at, FromServer: true, Bytes: make([]byte, 11)},
+		{ConnId: "127.0.0.1:50000 => 127.0.0.1:3306", Time: at, Bytes: make([]byte, 5)},
+		{ConnId: "127.0.0.1:50000 => 127.0.0.1:3306", Time: at, Closed: true},
+		{ConnId: "127.0.0.1:50000 => 127.0.0.1:3306", Time: at, FromServer: true, Bytes: make([]byte, 12)},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, writePcap(buf, chunks))
+
+	// Each stream starts with three-way handshake, closed address is reused by new stream
+	assert.Equal(t, []int{0, 0, 0, 10, 0, 0, 0, 11, 5, 0, 0, 0, 0, 0, 0, 12}, capturedPayloads(t, buf.Bytes()))
+
+	// Sequence number skips bytes of dropped chunk
+	chunks = []*chat.Data{
+		{ConnId: "127.0.0.1:50000 => 127.0.0.1:3306", Time: at, FromServer: true, Bytes: make([]byte, 10)},
+		{ConnId: "127.0.0.1:50000 => 127.0.0.1:3306", Time: at, FromServer: true, Offset: 30, Bytes: make([]byte, 5)},
+	}
+
+	buf.Reset()
+	assert.Nil(t, writePcap(buf, chunks))
+
+	capture := buf.Bytes()
+	var seqs []uint32
+	for len(capture) > 0 {
+		if binary.LittleEndian.Uint32(capture) == 6 {
+			seqs = append(seqs, binary.BigEndian.Uint32(capture[28+14+20+4:]))
+		}
+		capture = capture[binary.LittleEndian.Uint32(capture[4:]):]
+	}
+	assert.Equal(t, []uint32{0, 0, 1, 1, 31}, seqs)
+}
+
+func TestExportPcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-export")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := store.Open(dir, store.Options{})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, connId := range []string{"127.0.0.1:50000 => 127.0.0.1:3306", "127.0.0.1:50001 => 127.0.0.1:3306"} {
+		data := &chat.Data{ConnId: connId, Time: at.Add(time.Duration(i) * time.Second), Bytes: []byte{0x01}}
+		assert.Nil(t, s.Append(data.Time, chat.EventData, data))
+	}
+
+	events := make(chan chat.Event)
+	hub := chat.NewHub(events, nil, nil, 0, 1, 1024)
+	go hub.Run()
+	events <- chat.Event{Data: &chat.Data{ConnId: "live", Time: at, Bytes: []byte{0x01, 0x02}}}
+	hub.RegisterClient(chat.NewClient(nil, hub)) // Returns once event is processed
+
+	tests := []struct {
+		Query    string
+		Store    *store.Store
+		Status   int
+		Payloads []int
+	}{
+		{"", s, http.StatusOK, []int{0, 0, 0, 1, 0, 0, 0, 1}},
+		{"conn=" + "127.0.0.1:50001+%3D%3E+127.0.0.1:3306", s, http.StatusOK, []int{0, 0, 0, 1}},
+		{"to=2020-01-01T00:00:01Z", s, http.StatusOK, []int{0, 0, 0, 1}},
+		{"conn=nope", s, http.StatusNotFound, nil},
+		{"source=live", s, http.StatusOK, []int{0, 0, 0, 2}},
+		{"", nil, http.StatusOK, []int{0, 0, 0, 2}},
+		{"source=store", nil, http.StatusServiceUnavailable, nil},
+		{"source=disk", s, http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		exportPcap(hub, test.Store)(recorder, httptest.NewRequest("GET", apiPcapRoute+"?"+test.Query, nil))
+
+		assert.Equal(t, test.Status, recorder.Code, test.Query)
+		if recorder.Code == http.StatusOK {
+			assert.Equal(t, "application/x-pcapng", recorder.Header().Get("Content-Type"))
+			assert.Equal(t, test.Payloads, capturedPayloads(t, recorder.Body.Bytes()), test.Query)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+// Package fingerprint normalizes SQL queries, so queries of the same shape can be grouped together.
+// Fingerprint of query has literals including NULL values replaced by ?, lists of IN and repeated rows of VALUES collapsed,
+// comments removed, keywords and identifiers lowercased and whitespace normalized, e.g.
+//
+//	SELECT * FROM `Orders` WHERE id IN (1, 2, 3) /* web */ AND status = 'new'
+//
+// becomes
+//
+//	select * from orders where id in (?+) and status = ?
+//
+// Digest is stable hash of fingerprint.
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Kinds of tokens
+const (
+	tokenWord       = iota // Keyword, identifier or variable, lowercased
+	tokenIdentifier        // Quoted identifier which can't be written without quotes
+	tokenLiteral           // String, number or placeholder
+	tokenPunct             // Operator or punctuation
+)
+
+// collapsedList replaces list of literals of IN
+const collapsedList = "?+"
+
+// keywords are reserved words which are never names of functions or tables,
+// there's a space between keyword and opening parenthesis and sign following keyword belongs to number
+var keywords = map[string]bool{
+	"all": true, "and": true, "any": true, "as": true, "asc": true, "between": true, "by": true,
+	"case": true, "desc": true, "distinct": true, "div": true, "else": true, "end": true, "escape": true,
+	"exists": true, "from": true, "group": true, "having": true, "in": true, "interval": true, "into": true,
+	"is": true, "join": true, "key": true, "like": true, "limit": true, "mod": true, "not": true,
+	"offset": true, "on": true, "or": true, "order": true, "regexp": true, "return": true, "rlike": true,
+	"select": true, "set": true, "some": true, "then": true, "union": true, "using": true, "value": true,
+	"values": true, "when": true, "where": true, "with": true, "xor": true,
+}
+
+// operators are operators of more than one character, longer ones first
+var operators = []string{"<=>", "->>", "<=", ">=", "<>", "!=", ":=", "||", "&&", "<<", ">>", "->"}
+
+type token struct {
+	kind int
+	text string
+}
+
+// Fingerprint returns normalized query, empty string if query has no tokens
+func Fingerprint(query string) string {
+	tokens := collapse(tokenize(query))
+
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && spaced(tokens[i-1], t) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
+
+// Digest returns hex encoded SHA-256 hash of fingerprint
+func Digest(fingerprint string) string {
+	hash := sha256.Sum256([]byte(fingerprint))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// spaced returns true if tokens are separated by space
+func spaced(prev, t token) bool {
+	switch {
+	case t.kind == tokenPunct && (t.text == "," || t.text == ")" || t.text == "." || t.text == ";"),
+		prev.kind == tokenPunct && (prev.text == "(" || prev.text == "."):
+		return false
+
+	// Function call or columns list of table
+	case t.kind == tokenPunct && t.text == "(":
+		return prev.kind == tokenPunct || prev.kind == tokenLiteral || prev.kind == tokenWord && keywords[prev.text]
+	}
+
+	return true
+}
+
+// tokenize splits query into tokens, comments and trailing semicolons are dropped
+func tokenize(query string) []token {
+	var tokens []token
+	s := &scanner{query: query}
+
+	for {
+		s.skipSpaceAndComments()
+		if s.pos >= len(query) {
+			break
+		}
+
+		c := query[s.pos]
+		switch {
+		case c == '\'' || c == '"':
+			s.skipQuoted(c)
+			tokens = append(tokens, token{tokenLiteral, "?"})
+
+		case c == '`':
+			tokens = append(tokens, s.quotedIdentifier())
+
+		case c == '?':
+			s.pos++
+
+			// Collapsed list of fingerprint stays the same
+			if strings.HasPrefix(s.query[s.pos:], "+)") {
+				s.pos++
+				tokens = append(tokens, token{tokenLiteral, collapsedList})
+				break
+			}
+			tokens = append(tokens, token{tokenLiteral, "?"})
+
+		case isDigit(c) || c == '.' && s.pos+1 < len(query) && isDigit(query[s.pos+1]) && !operand(tokens):
+			tokens = append(tokens, s.number())
+
+		case (c == '-' || c == '+') && !operand(tokens) && s.signedNumber():
+			tokens = append(tokens, s.number())
+
+		case isWordChar(c):
+			t := s.word()
+
+			// NULL is value unless it's tested with IS [NOT] NULL
+			if t == (token{tokenWord, "null"}) && !nullTest(tokens) {
+				t = token{tokenLiteral, "?"}
+			}
+			tokens = append(tokens, t)
+
+		default:
+			tokens = append(tokens, s.operator())
+		}
+	}
+
+	for len(tokens) > 0 && tokens[len(tokens)-1] == (token{tokenPunct, ";"}) {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	return tokens
+}
+
+// operand returns true if the last token ends operand, so sign following it is binary operator
+func operand(tokens []token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+
+	last := tokens[len(tokens)-1]
+	switch last.kind {
+	case tokenWord:
+		return !keywords[last.text]
+	case tokenPunct:
+		return last.text == ")"
+	}
+
+	return true
+}
+
+// nullTest returns true if the last tokens are IS or IS NOT
+func nullTest(tokens []token) bool {
+	n := len(tokens)
+	if n > 0 && tokens[n-1] == (token{tokenWord, "not"}) {
+		n--
+	}
+
+	return n > 0 && tokens[n-1] == (token{tokenWord, "is"})
+}
+
+// collapse replaces lists of literals of IN with single item and drops repeated rows of VALUES
+func collapse(tokens []token) []token {
+	var collapsed []token
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		collapsed = append(collapsed, t)
+
+		if t.kind != tokenWord || i+1 >= len(tokens) || tokens[i+1] != (token{tokenPunct, "("}) {
+			continue
+		}
+
+		switch t.text {
+		case "in":
+			if end := literalList(tokens, i+1); end > 0 {
+				collapsed = append(collapsed, token{tokenPunct, "("}, token{tokenLiteral, collapsedList}, token{tokenPunct, ")"})
+				i = end
+			}
+
+		case "values", "value":
+			end := group(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			row := tokens[i+1 : end+1]
+			collapsed = append(collapsed, row...)
+			i = end
+
+			// The same row follows after comma
+			for i+1+len(row) < len(tokens) && tokens[i+1] == (token{tokenPunct, ","}) && equal(tokens[i+2:i+2+len(row)], row) {
+				i += 1 + len(row)
+			}
+		}
+	}
+
+	return collapsed
+}
+
+// literalList returns index of parenthesis closing list of literals which starts at open, -1 if it's not such list
+func literalList(tokens []token, open int) int {
+	for i := open + 1; i+1 < len(tokens); i += 2 {
+		if tokens[i].kind != tokenLiteral {
+			return -1
+		}
+
+		switch tokens[i+1] {
+		case token{tokenPunct, ")"}:
+			return i + 1
+		case token{tokenPunct, ","}:
+		default:
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// group returns index of parenthesis closing the one at open, -1 if it's not closed
+func group(tokens []token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch tokens[i] {
+		case token{tokenPunct, "("}:
+			depth++
+		case token{tokenPunct, ")"}:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func equal(a, b []token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scanner reads tokens of query one by one.
+type scanner struct {
+	query string
+	pos   int
+}
+
+// skipSpaceAndComments skips whitespace and comments of all three kinds: #, -- and /* */
+func (s *scanner) skipSpaceAndComments() {
+	for s.pos < len(s.query) {
+		rest := s.query[s.pos:]
+
+		switch {
+		case isSpace(rest[0]):
+			s.pos++
+
+		case rest[0] == '#', strings.HasPrefix(rest, "--") && (len(rest) == 2 || isSpace(rest[2])):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			s.pos += end
+
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				s.pos = len(s.query)
+			} else {
+				s.pos += 2 + end + 2
+			}
+
+		default:
+			return
+		}
+	}
+}
+
+// skipQuoted skips string or identifier enclosed in quote, quote is escaped by backslash or doubling.
+// Backslash doesn't escape anything in quoted identifiers.
+func (s *scanner) skipQuoted(quote byte) {
+	for s.pos++; s.pos < len(s.query); s.pos++ {
+		switch s.query[s.pos] {
+		case '\\':
+			if quote != '`' {
+				s.pos++
+			}
+
+		case quote:
+			if s.pos+1 < len(s.query) && s.query[s.pos+1] == quote {
+				s.pos++
+				continue
+			}
+			s.pos++
+			return
+		}
+	}
+}
+
+// quotedIdentifier reads identifier enclosed in backticks, quotes are dropped if they're not needed
+func (s *scanner) quotedIdentifier() token {
+	start := s.pos
+	s.skipQuoted('`')
+	quoted := s.query[start:s.pos]
+
+	name := strings.TrimSuffix(quoted[1:], "`")
+	plain := name != ""
+	for i := 0; i < len(name); i++ {
+		plain = plain && isWordChar(name[i]) && name[i] != '@'
+	}
+
+	if plain && !isNumber(name) {
+		return token{tokenWord, strings.ToLower(name)}
+	}
+
+	return token{tokenIdentifier, quoted}
+}
+
+// number reads number, optionally signed, identifier is read if number turns out to be beginning of identifier like 1st
+func (s *scanner) number() token {
+	start := s.pos
+	if s.query[s.pos] == '-' || s.query[s.pos] == '+' {
+		s.pos++
+		s.skipSpaceAndComments()
+	}
+
+	rest := s.query[s.pos:]
+	if len(rest) > 2 && rest[0] == '0' && (rest[1] == 'x' || rest[1] == 'b') {
+		end := 2
+		for end < len(rest) && (rest[1] == 'x' && isHexDigit(rest[end]) || rest[end] == '0' || rest[end] == '1') {
+			end++
+		}
+
+		if end > 2 && (end == len(rest) || !isWordChar(rest[end])) {
+			s.pos += end
+			return token{tokenLiteral, "?"}
+		}
+	}
+
+	s.skipDigits()
+	if s.pos < len(s.query) && s.query[s.pos] == '.' {
+		s.pos++
+		s.skipDigits()
+	}
+
+	// Exponent
+	if s.pos < len(s.query) && (s.query[s.pos] == 'e' || s.query[s.pos] == 'E') {
+		end := s.pos + 1
+		if end < len(s.query) && (s.query[end] == '-' || s.query[end] == '+') {
+			end++
+		}
+		if end < len(s.query) && isDigit(s.query[end]) {
+			s.pos = end
+			s.skipDigits()
+		}
+	}
+
+	// Identifiers may start with digits
+	if s.pos < len(s.query) && isWordChar(s.query[s.pos]) && isDigit(s.query[start]) {
+		s.pos = start
+		return s.word()
+	}
+
+	return token{tokenLiteral, "?"}
+}
+
+// signedNumber returns true if sign at current position is followed by number
+func (s *scanner) signedNumber() bool {
+	next := &scanner{query: s.query, pos: s.pos + 1}
+	next.skipSpaceAndComments()
+
+	if next.pos >= len(s.query) {
+		return false
+	}
+
+	c := s.query[next.pos]
+	return isDigit(c) || c == '.' && next.pos+1 < len(s.query) && isDigit(s.query[next.pos+1])
+}
+
+func (s *scanner) skipDigits() {
+	for s.pos < len(s.query) && isDigit(s.query[s.pos]) {
+		s.pos++
+	}
+}
+
+// word reads keyword, identifier or variable.
+// Hexadecimal, bit and national strings like X'1F', and strings with charset introducer like _utf8mb4'a' are read as literals.
+func (s *scanner) word() token {
+	start := s.pos
+	for s.pos < len(s.query) && isWordChar(s.query[s.pos]) {
+		s.pos++
+	}
+	word := strings.ToLower(s.query[start:s.pos])
+
+	// Variable with quoted name like @`a b`
+	if strings.Trim(word, "@") == "" && s.pos < len(s.query) && s.query[s.pos] == '`' {
+		name := s.quotedIdentifier()
+		return token{name.kind, word + name.text}
+	}
+
+	if s.pos < len(s.query) && s.query[s.pos] == '\'' {
+		if word == "x" || word == "b" || word == "n" || strings.HasPrefix(word, "_") {
+			s.skipQuoted('\'')
+			return token{tokenLiteral, "?"}
+		}
+	}
+
+	return token{tokenWord, word}
+}
+
+// operator reads operator or punctuation
+func (s *scanner) operator() token {
+	rest := s.query[s.pos:]
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			s.pos += len(op)
+			return token{tokenPunct, op}
+		}
+	}
+
+	s.pos++
+	return token{tokenPunct, rest[:1]}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// isWordChar returns true if c may be part of unquoted identifier or variable, bytes of multibyte characters included
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_' || c == '$' || c == '@' || c >= 0x80
+}
+
+// isNumber returns true if s consists of digits only
+func isNumber(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,161 @@
+package fingerprint
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	type FingerprintAssert struct {
+		Name        string
+		Query       string
+		Fingerprint string
+	}
+
+	tests := []FingerprintAssert{
+		{"Empty", "", ""},
+		{"Comment only", " /* nothing */ -- here\n", ""},
+		{"Keywords and identifiers are lowercased", "SELECT Id FROM Orders", "select id from orders"},
+		{"Whitespace", "select\n\t  id ,name\r\nfrom   t", "select id, name from t"},
+		{"Trailing semicolons", "select 1 ;; ", "select ?"},
+		{"Statements", "select 1; select 2", "select ?; select ?"},
+
+		// Strings
+		{"Single quoted string", "select * from t where name = 'bob'", "select * from t where name = ?"},
+		{"Double quoted string", `select * from t where name = "bob"`, "select * from t where name = ?"},
+		{"Escaped quotes", `select 'it''s', 'it\'s', "say ""hi""", 'back\\', 1`, "select ?, ?, ?, ?, ?"},
+		{"Quote of another kind", `select 'say "hi"', "it's"`, "select ?, ?"},
+		{"Comment inside string", "select '/* not comment */', '-- nor this', '# nor this'", "select ?, ?, ?"},
+		{"Unterminated string", "select 'abc", "select ?"},
+		{"Hexadecimal string", "select X'1F', x'00', 0x1f, 0b101, b'01'", "select ?, ?, ?, ?, ?"},
+		{"National string", "select N'abc'", "select ?"},
+		{"Charset introducer", "select _utf8mb4'abc', _binary'x'", "select ?, ?"},
+
+		// Numbers
+		{"Integer", "select * from t where id = 42", "select * from t where id = ?"},
+		{"Decimal", "select 3.14, .5, 1., 1e10, 2.5E-3, 7e+2", "select ?, ?, ?, ?, ?, ?"},
+		{"Negative number", "select * from t where a = -1 and b > - 2.5 and c < +3", "select * from t where a = ? and b > ? and c < ?"},
+		{"Negative number after keyword", "select -1, -2 limit -3", "select ?, ? limit ?"},
+		{"Subtraction", "select a-1, a - 1, (a)-1, 2-1, count(*)-1 from t", "select a - ?, a - ?, (a) - ?, ? - ?, count(*) - ? from t"},
+		{"Negated column", "select -a from t", "select - a from t"},
+		{"Identifiers with digits", "select c1, 1st, 2nd_col from t2", "select c1, 1st, 2nd_col from t2"},
+		{"Hexadecimal like identifier", "select 0xyz from t", "select 0xyz from t"},
+		{"Number with exponent like identifier", "select 1e from t", "select 1e from t"},
+
+		// Identifiers
+		{"Qualified names", "select t . id, `db`.`t`.`c` from db.t", "select t.id, db.t.c from db.t"},
+		{"Quoted identifiers", "select `Id` from `Orders`", "select id from orders"},
+		{"Identifiers which need quotes", "select `order id`, `a``b`, `123` from `my table`", "select `order id`, `a``b`, `123` from `my table`"},
+		{"Backslash in quoted identifier", "select `a\\` from t", "select `a\\` from t"},
+		{"Multibyte identifiers", "select prénom from café", "select prénom from café"},
+		{"Variables", "select @a, @@session.sql_mode, @`b` := 1", "select @a, @@session.sql_mode, @b := ?"},
+
+		// Placeholders
+		{"Placeholders", "select * from t where a = ? and b = ?", "select * from t where a = ? and b = ?"},
+		{"Placeholder list", "select * from t where a in (?, ?)", "select * from t where a in (?+)"},
+
+		// Comments
+		{"Block comment", "select /* columns */ id from t", "select id from t"},
+		{"Optimizer hint", "select /*+ MAX_EXECUTION_TIME(1000) */ id from t", "select id from t"},
+		{"Version comment", "select /*!40001 SQL_NO_CACHE */ id from t", "select id from t"},
+		{"Line comments", "select id -- primary key\nfrom t # table\nwhere 1", "select id from t where ?"},
+		{"Double dash without space", "select 1--1", "select ? - ?"},
+		{"Double dash at end", "select 1 --", "select ?"},
+		{"Unterminated block comment", "select 1 /* open", "select ?"},
+
+		// Lists
+		{"IN list", "select * from t where id in (1, 2, 3)", "select * from t where id in (?+)"},
+		{"IN list of one item", "select * from t where id IN ('a')", "select * from t where id in (?+)"},
+		{"NOT IN list", "select * from t where id not in (-1,'b' , 0x1)", "select * from t where id not in (?+)"},
+		{"IN subquery", "select * from t where id in (select id from u where a = 1)", "select * from t where id in (select id from u where a = ?)"},
+		{"IN list with expression", "select * from t where id in (1, a)", "select * from t where id in (?, a)"},
+		{"IN list with function", "select * from t where id in (1, now())", "select * from t where id in (?, now())"},
+		{"Several IN lists", "select * from t where a in (1) and b in (2, 3)", "select * from t where a in (?+) and b in (?+)"},
+		{"Unclosed IN list", "select * from t where id in (1, 2", "select * from t where id in (?, ?"},
+		{"Multi-row insert", "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'),(3,'z')", "insert into t(a, b) values (?, ?)"},
+		{"Single row insert", "insert into t(a, b) values(1, 'x')", "insert into t(a, b) values (?, ?)"},
+		{"Rows of different shape", "insert into t values (1, now()), (2, 3)", "insert into t values (?, now()), (?, ?)"},
+		{"Insert with update", "insert into t values (1), (2) on duplicate key update a = values(a) + 1", "insert into t values (?) on duplicate key update a = values (a) + ?"},
+		{"Insert with VALUE", "insert into t value (1), (2)", "insert into t value (?)"},
+
+		// Operators and functions
+		{"Functions", "SELECT COUNT(*), MAX( price ), IF(a>1,'y','n') FROM t", "select count(*), max(price), if(a > ?, ?, ?) from t"},
+		{"Operators", "select a<=>1, b<>2, c!=3, d>=4, e<=5, f:=6, g||h, i&&j, k<<1, l->'$.x', m->>'$.y'", "select a <=> ?, b <> ?, c != ?, d >= ?, e <= ?, f := ?, g || h, i && j, k << ?, l -> ?, m ->> ?"},
+		{"Parentheses", "select * from t where ( a = 1 or b = 2 ) and exists(select 1)", "select * from t where (a = ? or b = ?) and exists (select ?)"},
+		{"Keywords before parenthesis", "select * from (select 1) as x join u on (x.a = u.a)", "select * from (select ?) as x join u on (x.a = u.a)"},
+		{"Interval", "select now() - interval 1 day", "select now() - interval ? day"},
+		{"Limit", "select * from t order by id desc limit 10, 20", "select * from t order by id desc limit ?, ?"},
+		{"Null test and booleans", "select * from t where a is null and b is not NULL and c = true", "select * from t where a is null and b is not null and c = true"},
+		{"Null value", "update t set a = NULL where b <=> null", "update t set a = ? where b <=> ?"},
+		{"Rows with null", "insert into t values (1, 'a'), (null, NULL)", "insert into t values (?, ?)"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Fingerprint, Fingerprint(test.Query), test.Name)
+	}
+}
+
+func TestFingerprintGroups(t *testing.T) {
+	// Queries of the same shape have the same fingerprint
+	groups := [][]string{
+		{
+			"SELECT * FROM orders WHERE id = 1",
+			"select * from orders where id=2",
+			"select  *  from `orders`  where  id = 'abc' -- comment",
+			"/* app */ select * from Orders where ID = ?;",
+		},
+		{
+			"select * from t where id in (1)",
+			"select * from t where id in (1, 2, 3, 4, 5)",
+			"select * from t where id IN (?,?)",
+		},
+		{
+			"insert into t(a) values (1)",
+			"insert into t(a) values (1), (2), (3)",
+			"INSERT INTO t (a) VALUES ('x'),('y')",
+		},
+	}
+
+	var fingerprints []string
+	for _, group := range groups {
+		fingerprint := Fingerprint(group[0])
+		for _, query := range group[1:] {
+			assert.Equal(t, fingerprint, Fingerprint(query), query)
+		}
+
+		assert.NotContains(t, fingerprints, fingerprint)
+		fingerprints = append(fingerprints, fingerprint)
+	}
+}
+
+func TestFingerprintIdempotent(t *testing.T) {
+	queries := []string{
+		"SELECT a, COUNT(*) FROM t WHERE b IN (1, 2) AND c = -1 GROUP BY a",
+		"insert into t(a, b) values (1, 'x'), (2, 'y')",
+		"select `order id` from `my table` where x = 0x1f",
+		"select a - 1, -b from t limit 5",
+	}
+
+	for _, query := range queries {
+		fingerprint := Fingerprint(query)
+		assert.Equal(t, fingerprint, Fingerprint(fingerprint), query)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	// Digest is SHA-256 of fingerprint, so it stays the same across runs and versions
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", Digest(""))
+	assert.Equal(t, Digest(Fingerprint("select 1")), Digest(Fingerprint("SELECT 2")))
+	assert.NotEqual(t, Digest(Fingerprint("select 1")), Digest(Fingerprint("select 1, 2")))
+	assert.Len(t, Digest("select ?"), 64)
+}
+
+func TestFingerprintTruncated(t *testing.T) {
+	// Query cut at any byte is fingerprinted without panic
+	query := "/* c */ SELECT `a``b`, @`v`, -1.5e-3, X'1f', _utf8'x', 'it\\'s' FROM t WHERE id IN (1, ?) -- end\n" +
+		"AND b = 0x1f # x\nAND c <=> +.5; INSERT INTO t VALUES (1, 'a'), (2, 'b')"
+
+	for i := 0; i <= len(query); i++ {
+		assert.NotPanics(t, func() { Fingerprint(query[:i]) }, query[:i])
+	}
+}
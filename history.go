@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/orderbynull/lottip/store"
+)
+
+// CommandEntry represents captured command along with its result.
+type CommandEntry struct {
+	chat.Cmd
+	Result *chat.CmdResult `json:",omitempty"` // nil if response wasn't captured (yet)
+}
+
+// Failed returns true if server replied to command with error
+func (e *CommandEntry) Failed() bool {
+	return e.Result != nil && e.Result.Result == protocol.ResponseErr
+}
+
+// Duration returns time server took to reply, 0 if there's no reply
+func (e *CommandEntry) Duration() time.Duration {
+	if e.Result == nil {
+		return 0
+	}
+
+	seconds, _ := strconv.ParseFloat(e.Result.Duration, 64)
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ConnectionEntry represents captured connection.
+type ConnectionEntry struct {
+	ConnId   string
+	Started  time.Time      // Time of connection start or of the first captured command
+	Finished *time.Time     `json:",omitempty"` // nil for active connections
+	Info     *chat.ConnInfo `json:",omitempty"` // nil if connection started before captured period
+	Commands int
+	Errors   int
+}
+
+// history holds connections and commands captured in some period, both ordered by time.
+type history struct {
+	connections []*ConnectionEntry
+	commands    []*CommandEntry
+}
+
+// historyScanSlack is how long scan goes on after enough entries are loaded,
+// events of different connections may be appended to store slightly out of time order.
+const historyScanSlack = time.Second
+
+// historyQuery narrows events loaded from store, zero value loads every event.
+type historyQuery struct {
+	from, to    time.Time         // Time range [from, to), zero from or to leaves range open
+	connId      string            // Events of other connections are skipped if set
+	cmdId       int               // Only this command of connId is loaded if set, scan stops once it's complete
+	limit       int               // Scan stops once more than limit entries counted by filter are complete if set
+	after       string            // Cursor entries are counted after
+	commands    *commandFilter    // Commands are counted to limit if set
+	connections *connectionFilter // Connections are counted to limit if set
+}
+
+// matchConn returns true if JSON encoded event may belong to connection of query.
+// Events are checked before decoding, so events of other connections aren't decoded at all.
+func (q *historyQuery) matchConn(event []byte) bool {
+	if q.connId == "" {
+		return true
+	}
+
+	connId, _ := json.Marshal(q.connId)
+
+	return bytes.Contains(event, append([]byte(`"ConnId":`), connId...))
+}
+
+// scanLimit tells when scan has loaded enough entries to fill page.
+// Entries which may get on page are pending until they're complete, i.e. command got its result
+// or connection finished, as their numbers shown on page could change otherwise.
+type scanLimit struct {
+	limit   int
+	counted int                  // Complete entries on page
+	pending map[interface{}]bool // Incomplete entries which may get on page
+	last    time.Time            // Time the latest entry was counted at
+}
+
+// update records state of entry at t, match tells if entry gets on page or may get on it while it's incomplete
+func (l *scanLimit) update(entry interface{}, t time.Time, complete, match bool) {
+	if !complete {
+		if match {
+			l.pending[entry] = true
+		}
+		return
+	}
+
+	delete(l.pending, entry)
+	if match {
+		l.counted++
+		l.last = t
+	}
+}
+
+// done returns true if page is filled and no event at t or later can change it
+func (l *scanLimit) done(t time.Time) bool {
+	return l.limit > 0 && l.counted > l.limit && len(l.pending) == 0 && t.Sub(l.last) > historyScanSlack
+}
+
+// loadHistory reads events recorded in store matching q and joins commands with their results.
+func loadHistory(s *store.Store, q *historyQuery) (*history, error) {
+	h := &history{}
+	connections := make(map[string]*ConnectionEntry)
+	commands := make(map[string]*CommandEntry) // The latest command by connection and command id
+	limit := &scanLimit{limit: q.limit, pending: make(map[interface{}]bool)}
+	found := false // Whether command requested by id is complete
+
+	connection := func(connId string, t time.Time) *ConnectionEntry {
+		conn, ok := connections[connId]
+		if !ok {
+			conn = &ConnectionEntry{ConnId: connId, Started: t}
+			connections[connId] = conn
+			h.connections = append(h.connections, conn)
+			if q.connections != nil && cursorOf(conn.Started, 0, connId) > q.after {
+				limit.update(conn, t, false, true)
+			}
+		}
+
+		return conn
+	}
+
+	// Command is counted once it's complete, until then it's pending if it may match filter
+	countCommand := func(entry *CommandEntry, t time.Time) {
+		if q.commands != nil && cursorOf(entry.Time, entry.CmdId, entry.ConnId) > q.after {
+			complete := entry.Result != nil || !entry.ExpectsResult
+			match := q.commands.match(entry) || !complete && q.commands.matchPending(entry)
+			limit.update(entry, t, complete, match)
+		}
+	}
+
+	err := s.Scan(q.from, q.to, func(record *store.Record) bool {
+		if found || limit.done(record.Time) {
+			return false
+		}
+
+		if !q.matchConn(record.Event) {
+			return true
+		}
+
+		switch record.Type {
+		case chat.EventCmd:
+			entry := &CommandEntry{}
+			if json.Unmarshal(record.Event, &entry.Cmd) != nil || q.connId != "" && entry.ConnId != q.connId {
+				break
+			}
+
+			connection(entry.ConnId, entry.Time).Commands++
+			if q.cmdId != 0 && entry.CmdId != q.cmdId {
+				break
+			}
+
+			commands[commandKey(entry.ConnId, entry.CmdId)] = entry
+			h.commands = append(h.commands, entry)
+			countCommand(entry, record.Time)
+			found = q.cmdId != 0 && !entry.ExpectsResult
+
+		case chat.EventCmdResult:
+			result := &chat.CmdResult{}
+			if json.Unmarshal(record.Event, result) != nil {
+				break
+			}
+
+			if entry, ok := commands[commandKey(result.ConnId, result.CmdId)]; ok && entry.Result == nil {
+				entry.Result = result
+				if entry.Failed() {
+					connection(result.ConnId, result.Time).Errors++
+				}
+				countCommand(entry, record.Time)
+				found = q.cmdId != 0
+			}
+
+		case chat.EventConnState:
+			state := &chat.ConnState{}
+			if json.Unmarshal(record.Event, state) != nil || q.connId != "" && state.ConnId != q.connId {
+				break
+			}
+
+			conn := connection(state.ConnId, state.Time)
+			switch state.State {
+			case protocol.ConnStateStarted:
+				// Address of connection may be reused by another connection later
+				if conn.Info != nil || conn.Finished != nil {
+					delete(connections, state.ConnId)
+					delete(limit.pending, conn)
+					conn = connection(state.ConnId, state.Time)
+				}
+				conn.Started, conn.Info = state.Time, state.Info
+
+			case protocol.ConnStateFinished:
+				if conn.Finished == nil {
+					conn.Finished = &state.Time
+					if q.connections != nil && cursorOf(conn.Started, 0, conn.ConnId) > q.after {
+						limit.update(conn, record.Time, true, q.connections.match(conn))
+					}
+				}
+			}
+		}
+
+		return true
+	})
+
+	// Order is the same as of cursors pages are split with
+	sort.SliceStable(h.connections, func(i, j int) bool {
+		a, b := h.connections[i], h.connections[j]
+		return cursorOf(a.Started, 0, a.ConnId) < cursorOf(b.Started, 0, b.ConnId)
+	})
+	sort.SliceStable(h.commands, func(i, j int) bool {
+		a, b := h.commands[i], h.commands[j]
+		return cursorOf(a.Time, a.CmdId, a.ConnId) < cursorOf(b.Time, b.CmdId, b.ConnId)
+	})
+
+	return h, err
+}
+
+// commandKey returns key identifying command
+func commandKey(connId string, cmdId int) string {
+	return connId + "#" + strconv.Itoa(cmdId)
+}
+
+// commandFilter holds conditions commands are filtered with, zero value matches every command.
+type commandFilter struct {
+	connId      string
+	database    string
+	user        string
+	service     string
+	digest      string // Digest of query fingerprint
+	text        string // Case insensitive substring of query
+	errorsOnly  bool
+	minDuration time.Duration
+}
+
+// match returns true if command meets all conditions of filter
+func (f *commandFilter) match(e *CommandEntry) bool {
+	switch {
+	case f.connId != "" && e.ConnId != f.connId,
+		f.database != "" && e.Database != f.database,
+		f.user != "" && e.User != f.user,
+		f.service != "" && e.Service != f.service,
+		f.digest != "" && e.Digest != f.digest,
+		f.errorsOnly && !e.Failed(),
+		f.minDuration > 0 && e.Duration() < f.minDuration:
+		return false
+	}
+
+	if f.text != "" {
+		text := strings.ToLower(f.text)
+		return strings.Contains(strings.ToLower(e.Query), text) || strings.Contains(strings.ToLower(e.RunnableQuery), text)
+	}
+
+	return true
+}
+
+// matchPending returns true if command waiting for result may match filter once result arrives
+func (f *commandFilter) matchPending(e *CommandEntry) bool {
+	pending := *f
+	pending.errorsOnly, pending.minDuration = false, 0
+
+	return pending.match(e)
+}
+
+// connectionFilter holds conditions connections are filtered with, zero value matches every connection.
+type connectionFilter struct {
+	user     string
+	service  string
+	database string
+}
+
+// match returns true if connection meets all conditions of filter, connection started before captured period has no info to match
+func (f *connectionFilter) match(c *ConnectionEntry) bool {
+	info := c.Info
+	if info == nil {
+		info = &chat.ConnInfo{}
+	}
+
+	return (f.user == "" || info.User == f.user) &&
+		(f.service == "" || info.Service == f.service) &&
+		(f.database == "" || info.Database == f.database)
+}
+
+// historyStats represents aggregate numbers of captured commands.
+type historyStats struct {
+	Connections   int
+	Commands      int
+	Errors        int
+	Pending       int     // Commands without captured result
+	TotalDuration float64 // Total time server took to reply in seconds
+	MaxDuration   float64
+	ByCommand     map[string]int
+	ByDatabase    map[string]int
+	ByUser        map[string]int
+	Events        chat.Counters // Counters of events passed through hub since start
+}
+
+// stats aggregates commands matching filter
+func (h *history) stats(filter *commandFilter) *historyStats {
+	stats := &historyStats{
+		ByCommand:  make(map[string]int),
+		ByDatabase: make(map[string]int),
+		ByUser:     make(map[string]int),
+	}
+
+	connections := make(map[string]bool)
+	for _, entry := range h.commands {
+		if !filter.match(entry) {
+			continue
+		}
+
+		connections[entry.ConnId] = true
+		stats.Commands++
+		stats.ByCommand[entry.Command]++
+		stats.ByDatabase[entry.Database]++
+		stats.ByUser[entry.User]++
+
+		if entry.Failed() {
+			stats.Errors++
+		}
+
+		if entry.Result == nil {
+			if entry.ExpectsResult {
+				stats.Pending++
+			}
+			continue
+		}
+
+		duration := entry.Duration().Seconds()
+		stats.TotalDuration += duration
+		if duration > stats.MaxDuration {
+			stats.MaxDuration = duration
+		}
+	}
+	stats.Connections = len(connections)
+
+	return stats
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/olekukonko/tablewriter"
 	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/store"
 )
 
 const (
@@ -16,7 +18,10 @@ const (
 	webRoute       = "/"
 )
 
-func runHttpServer(hub *chat.Hub) {
+func runHttpServer(hub *chat.Hub, history *store.Store) {
+	// Counters of dropped events are available at /debug/vars
+	expvar.Publish("events", expvar.Func(func() interface{} { return hub.Counters().Load() }))
+
 	// Websockets endpoint
 	http.HandleFunc(websocketRoute, func(w http.ResponseWriter, r *http.Request) {
 		upgr := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
@@ -27,18 +32,12 @@ func runHttpServer(hub *chat.Hub) {
 			return
 		}
 
-		// Proper handling 'close' message from the peer
-		// See https://godoc.org/github.com/gorilla/websocket#hdr-Control_Messages for details
-		go func() {
-			if _, _, err := conn.NextReader(); err != nil {
-				conn.Close()
-			}
-		}()
-
 		client := chat.NewClient(conn, hub)
 
 		hub.RegisterClient(client)
 
+		// Client may subscribe to events matching filter, it also handles 'close' message from the peer
+		go client.Listen()
 		go client.Process()
 	})
 
@@ -53,7 +52,7 @@ func runHttpServer(hub *chat.Hub) {
 		type Data struct {
 			Database   string
 			Query      string
-			Parameters []string
+			Parameters []*string // nil for NULL
 		}
 
 		var parsedData Data
@@ -78,6 +77,9 @@ func runHttpServer(hub *chat.Hub) {
 		}
 	})
 
+	// History endpoints
+	registerAPIRoutes(hub, history)
+
 	http.Handle(webRoute, http.FileServer(FS(*useLocalUI)))
 
 	log.Fatal(http.ListenAndServe(*guiAddr, nil))
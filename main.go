@@ -3,40 +3,198 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/slowlog"
+	"github.com/orderbynull/lottip/store"
 )
 
 var (
-	proxyAddr  = flag.String("proxy", "127.0.0.1:4041", "Proxy <host>:<port>")
-	mysqlAddr  = flag.String("mysql", "127.0.0.1:3306", "MySQL <host>:<port>")
-	guiAddr    = flag.String("gui", "127.0.0.1:9999", "Web UI <host>:<port>")
-	useLocalUI = flag.Bool("use-local", false, "Use local UI instead of embed")
-	mysqlDsn   = flag.String("mysql-dsn", "", "MySQL DSN for query execution capabilities")
+	proxyAddr   = flag.String("proxy", "127.0.0.1:4041", "Proxy <host>:<port>")
+	mysqlAddr   = flag.String("mysql", "127.0.0.1:3306", "MySQL <host>:<port>")
+	guiAddr     = flag.String("gui", "127.0.0.1:9999", "Web UI <host>:<port>")
+	useLocalUI  = flag.Bool("use-local", false, "Use local UI instead of embed")
+	mysqlDsn    = flag.String("mysql-dsn", "", "MySQL DSN for query execution capabilities")
+	sampleRows  = flag.Int("sample-rows", 10, "Number of result set rows captured per query, 0 to disable")
+	sampleBytes = flag.Int("sample-bytes", 64*1024, "Max size in bytes of result set rows captured per query")
+	tlsCert     = flag.String("tls-cert", "", "Certificate file for TLS connections from clients")
+	tlsKey      = flag.String("tls-key", "", "Private key file of --tls-cert")
+	tlsSelf     = flag.Bool("tls-self-signed", false, "Accept TLS connections from clients with generated self-signed certificate")
+	mysqlTLSCA  = flag.String("mysql-tls-ca", "", "CA file to verify MySQL server certificate, not verified if empty")
+	storePath   = flag.String("store", "", "Directory to keep history of captured traffic in, history is not kept if empty")
+	storeMaxAge = flag.Duration("store-max-age", 7*24*time.Hour, "Max age of history records, 0 to keep forever")
+	storeMaxMB  = flag.Int64("store-max-size", 1024, "Max size of history in MB, 0 for unlimited")
+	replaySize  = flag.Int("replay-size", 1000, "Number of recent events sent to newly opened web UI")
+	eventQueue  = flag.Int("event-queue", 10000, "Number of captured events waiting for processing, events are dropped once it's full")
+	clientQueue = flag.Int("client-queue", 4096, "Number of events waiting to be sent to single web UI, the oldest are dropped once it's full")
+	pcap        = flag.Bool("pcap", false, "Record relayed bytes, so connections can be exported as pcapng")
+	pcapBuffer  = flag.Int("pcap-buffer", 64, "Size in MB of the most recent relayed bytes kept in memory with --pcap")
+	mysqlPort   = flag.Int("mysql-port", 3306, "MySQL server port of connections decoded by analyze")
+	slowLogPath = flag.String("slow-log", "", "File to write completed commands to in MySQL slow query log format, not written if empty")
+	slowLogMin  = flag.Duration("slow-log-min-duration", 0, "Commands faster than this are not written to slow log")
+	slowLogMB   = flag.Int64("slow-log-max-size", 100, "Size in MB slow log is rotated at, 0 to never rotate")
+	slowLogKeep = flag.Int("slow-log-files", 5, "Number of rotated slow log files kept, 0 to keep all")
+	eventLog    = flag.String("event-log", "", "File to append captured events to as JSON lines, - for stdout, not written if empty")
+)
+
+// infoOutput is where messages for user are printed to
+var infoOutput io.Writer = os.Stdout
+
+const (
+	analyzeCommand    = "analyze"
+	analyzeReplaySize = 100000 // Number of events sent to web UI by default when capture is analyzed
 )
 
 func appReadyInfo(appReadyChan chan bool) {
 	<-appReadyChan
 	time.Sleep(1 * time.Second)
-	fmt.Printf("Forwarding queries from `%s` to `%s` \n", *proxyAddr, *mysqlAddr)
-	fmt.Printf("Web gui available at `http://%s` \n", *guiAddr)
+	fmt.Fprintf(infoOutput, "Forwarding queries from `%s` to `%s` \n", *proxyAddr, *mysqlAddr)
+	fmt.Fprintf(infoOutput, "Web gui available at `http://%s` \n", *guiAddr)
 }
 
 func main() {
-	flag.Parse()
+	// Capture file is analyzed instead of proxying live traffic: lottip analyze [options] file.pcap
+	analyze := len(os.Args) > 1 && os.Args[1] == analyzeCommand
+	if analyze {
+		flag.CommandLine.Parse(os.Args[2:])
+		if flag.NArg() != 1 {
+			log.Fatalf("Usage: %s %s [options] <file.pcap>", os.Args[0], analyzeCommand)
+		}
+	} else {
+		flag.Parse()
+	}
+
+	tlsConfig, err := newTLSConfig(*tlsCert, *tlsKey, *tlsSelf, *mysqlTLSCA, *mysqlAddr)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	storeDir, storeOptions := *storePath, store.Options{MaxAge: *storeMaxAge, MaxSize: *storeMaxMB << 20}
+	if analyze {
+		// Captured traffic may be older than age limit, history is kept in temporary directory if --store isn't set
+		storeOptions.MaxAge = 0
+		if storeDir == "" {
+			if storeDir, err = ioutil.TempDir("", "lottip-analyze"); err != nil {
+				log.Fatal(err.Error())
+			}
+			onExit(func() { os.RemoveAll(storeDir) })
+		}
+
+		// Web UI shows all events of capture unless told otherwise
+		if !flagSet("replay-size") {
+			*replaySize = analyzeReplaySize
+		}
+	}
+
+	var history *store.Store
+	if storeDir != "" {
+		history, err = store.Open(storeDir, storeOptions)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		onExit(func() { history.Close() })
+	}
 
-	cmdChan := make(chan chat.Cmd)
-	cmdResultChan := make(chan chat.CmdResult)
-	connStateChan := make(chan chat.ConnState)
+	var sinks []chat.Sink
+	if *slowLogPath != "" {
+		slowLog, err := slowlog.Open(*slowLogPath, slowlog.Options{MinDuration: *slowLogMin, MaxSize: *slowLogMB << 20, MaxFiles: *slowLogKeep})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		onExit(func() { slowLog.Close() })
+		sinks = append(sinks, chat.NewSlowLogSink(slowLog))
+	}
+
+	switch *eventLog {
+	case "":
+	case "-":
+		// Stdout is left to events only
+		infoOutput = os.Stderr
+		sinks = append(sinks, chat.NewJSONLinesSink(os.Stdout))
+	default:
+		file, err := os.OpenFile(*eventLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		onExit(func() { file.Close() })
+		sinks = append(sinks, chat.NewJSONLinesSink(file))
+	}
+
+	// Buffered channel lets proxy go on while hub is busy
+	eventChan := make(chan chat.Event, *eventQueue)
 	appReadyChan := make(chan bool)
 
-	hub := chat.NewHub(cmdChan, cmdResultChan, connStateChan)
+	hub := chat.NewHub(eventChan, history, sinks, *replaySize, *clientQueue, *pcapBuffer<<20)
 
 	go hub.Run()
-	go runHttpServer(hub)
+
+	events := &eventPublisher{events: eventChan, counters: hub.Counters()}
+
+	if analyze {
+		// Nothing is relayed, so TLS is never set up and events are never dropped
+		events.wait = true
+		p := MySQLProxyServer{events, appReadyChan, *mysqlAddr, *proxyAddr, *sampleRows, *sampleBytes, nil, *pcap}
+
+		analyzer, err := analyzeCapture(flag.Arg(0), *mysqlPort, &p)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		fmt.Fprintf(infoOutput, "Analyzed %d connections to port %d from `%s`, %d connections started before capture are skipped \n", analyzer.analyzed, *mysqlPort, flag.Arg(0), analyzer.skipped)
+		fmt.Fprintf(infoOutput, "Web gui available at `http://%s` \n", *guiAddr)
+		runHttpServer(hub, history)
+		return
+	}
+
+	go runHttpServer(hub, history)
 	go appReadyInfo(appReadyChan)
 
-	p := MySQLProxyServer{cmdChan, cmdResultChan, connStateChan, appReadyChan, *mysqlAddr, *proxyAddr}
+	p := MySQLProxyServer{events, appReadyChan, *mysqlAddr, *proxyAddr, *sampleRows, *sampleBytes, tlsConfig, *pcap}
 	p.run()
 }
+
+// flagSet returns true if flag was set in command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+
+	return set
+}
+
+// exitFuncs are run in reverse order once process is interrupted, deferred calls of main never run since it never returns
+var (
+	exitFuncs []func()
+	exitLock  sync.Mutex
+)
+
+// onExit registers fn to be run once process is interrupted or terminated
+func onExit(fn func()) {
+	exitLock.Lock()
+	defer exitLock.Unlock()
+
+	if exitFuncs == nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			<-signals
+			exitLock.Lock()
+			for i := len(exitFuncs) - 1; i >= 0; i-- {
+				exitFuncs[i]()
+			}
+			os.Exit(0)
+		}()
+	}
+
+	exitFuncs = append(exitFuncs, fn)
+}
@@ -0,0 +1,263 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// maxSegmentSize is max payload of single synthetic TCP segment, segment must fit 16-bit IP length
+const maxSegmentSize = 65000
+
+// TCP flags
+const (
+	tcpFin byte = 0x01
+	tcpSyn byte = 0x02
+	tcpPsh byte = 0x08
+	tcpAck byte = 0x10
+)
+
+const (
+	etherTypeIPv4 uint16 = 0x0800
+	etherTypeIPv6 uint16 = 0x86dd
+	ipProtocolTCP byte   = 6
+	ipTTL         byte   = 64
+	tcpWindow     uint16 = 0xffff
+)
+
+// Locally administered MAC addresses of client and server
+var (
+	clientMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	serverMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// Stream rebuilds TCP connection between client and server from bytes sent by each side.
+// Segments get synthetic Ethernet, IP and TCP headers with consistent sequence and acknowledgment numbers,
+// so capture reads as if it was taken on the wire.
+type Stream struct {
+	w          *Writer
+	client     *net.TCPAddr
+	server     *net.TCPAddr
+	clientSeq  uint32 // Sequence number of the next byte sent by client
+	serverSeq  uint32 // Sequence number of the next byte sent by server
+	clientSent uint64 // Number of bytes sent by client so far, including skipped ones
+	serverSent uint64 // Number of bytes sent by server so far, including skipped ones
+	ipId       uint16
+	opened     bool
+	closed     bool
+	etherType  uint16
+	clientIP   net.IP
+	serverIP   net.IP
+	headerSize int
+}
+
+// NewStream starts stream of connection between client and server.
+// IPv4 is used if both addresses are IPv4 ones, IPv6 otherwise.
+func (w *Writer) NewStream(client, server *net.TCPAddr) *Stream {
+	s := &Stream{w: w, client: client, server: server}
+
+	clientIP, serverIP := client.IP.To4(), server.IP.To4()
+	if clientIP != nil && serverIP != nil {
+		s.etherType, s.headerSize = etherTypeIPv4, 14+20+20
+	} else {
+		clientIP, serverIP = client.IP.To16(), server.IP.To16()
+		s.etherType, s.headerSize = etherTypeIPv6, 14+40+20
+	}
+	s.clientIP, s.serverIP = clientIP, serverIP
+
+	return s
+}
+
+// Open writes three-way handshake, Write opens stream itself if it's not opened yet
+func (s *Stream) Open(t time.Time) error {
+	if s.opened {
+		return nil
+	}
+	s.opened = true
+
+	if err := s.writeSegment(t, false, tcpSyn, nil); err != nil {
+		return err
+	}
+	s.clientSeq++
+
+	if err := s.writeSegment(t, true, tcpSyn|tcpAck, nil); err != nil {
+		return err
+	}
+	s.serverSeq++
+
+	return s.writeSegment(t, false, tcpAck, nil)
+}
+
+// Write writes bytes sent by one side at time t as segments acknowledging everything received so far
+func (s *Stream) Write(t time.Time, fromServer bool, data []byte) error {
+	if err := s.Open(t); err != nil {
+		return err
+	}
+
+	if fromServer {
+		s.serverSent += uint64(len(data))
+	} else {
+		s.clientSent += uint64(len(data))
+	}
+
+	for len(data) > 0 {
+		size := len(data)
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+
+		if err := s.writeSegment(t, fromServer, tcpPsh|tcpAck, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// WriteAt writes bytes like Write does, offset is number of bytes sent by the same side before data.
+// Bytes missing before offset are skipped, so segments holding them read as not captured.
+func (s *Stream) WriteAt(t time.Time, fromServer bool, offset uint64, data []byte) error {
+	seq, sent := &s.clientSeq, &s.clientSent
+	if fromServer {
+		seq, sent = &s.serverSeq, &s.serverSent
+	}
+
+	if err := s.Open(t); err != nil {
+		return err
+	}
+
+	if offset > *sent {
+		*seq += uint32(offset - *sent)
+		*sent = offset
+	}
+
+	return s.Write(t, fromServer, data)
+}
+
+// Close writes connection termination initiated by client, stream can't be written after that
+func (s *Stream) Close(t time.Time) error {
+	if s.closed {
+		return nil
+	}
+
+	if err := s.Open(t); err != nil {
+		return err
+	}
+	s.closed = true
+
+	if err := s.writeSegment(t, false, tcpFin|tcpAck, nil); err != nil {
+		return err
+	}
+	s.clientSeq++
+
+	if err := s.writeSegment(t, true, tcpFin|tcpAck, nil); err != nil {
+		return err
+	}
+	s.serverSeq++
+
+	return s.writeSegment(t, false, tcpAck, nil)
+}
+
+// Closed returns true if stream was closed
+func (s *Stream) Closed() bool {
+	return s.closed
+}
+
+// writeSegment writes single segment with given flags and advances sequence number of its sender by payload size
+func (s *Stream) writeSegment(t time.Time, fromServer bool, flags byte, payload []byte) error {
+	srcMAC, dstMAC := clientMAC, serverMAC
+	srcIP, dstIP := s.clientIP, s.serverIP
+	srcPort, dstPort := s.client.Port, s.server.Port
+	seq, ack := &s.clientSeq, s.serverSeq
+	if fromServer {
+		srcMAC, dstMAC = serverMAC, clientMAC
+		srcIP, dstIP = s.serverIP, s.clientIP
+		srcPort, dstPort = s.server.Port, s.client.Port
+		seq, ack = &s.serverSeq, s.clientSeq
+	}
+
+	if flags&tcpAck == 0 {
+		ack = 0
+	}
+
+	frame := make([]byte, s.headerSize, s.headerSize+len(payload))
+
+	// Ethernet header
+	copy(frame[0:], dstMAC)
+	copy(frame[6:], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:], s.etherType)
+
+	// IP header
+	ip := frame[14:]
+	tcpLength := 20 + len(payload)
+	var pseudoHeader []byte
+	if s.etherType == etherTypeIPv4 {
+		s.ipId++
+		ip[0] = 0x45 // Version 4, header of 5 words
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+tcpLength))
+		binary.BigEndian.PutUint16(ip[4:], s.ipId)
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // Don't fragment
+		ip[8] = ipTTL
+		ip[9] = ipProtocolTCP
+		copy(ip[12:], srcIP)
+		copy(ip[16:], dstIP)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip[:20]))
+
+		pseudoHeader = make([]byte, 12)
+		copy(pseudoHeader[0:], srcIP)
+		copy(pseudoHeader[4:], dstIP)
+		pseudoHeader[9] = ipProtocolTCP
+		binary.BigEndian.PutUint16(pseudoHeader[10:], uint16(tcpLength))
+		ip = ip[20:]
+	} else {
+		ip[0] = 0x60 // Version 6
+		binary.BigEndian.PutUint16(ip[4:], uint16(tcpLength))
+		ip[6] = ipProtocolTCP
+		ip[7] = ipTTL
+		copy(ip[8:], srcIP)
+		copy(ip[24:], dstIP)
+
+		pseudoHeader = make([]byte, 40)
+		copy(pseudoHeader[0:], srcIP)
+		copy(pseudoHeader[16:], dstIP)
+		binary.BigEndian.PutUint32(pseudoHeader[32:], uint32(tcpLength))
+		pseudoHeader[39] = ipProtocolTCP
+		ip = ip[40:]
+	}
+
+	// TCP header
+	tcp := ip
+	binary.BigEndian.PutUint16(tcp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // Header of 5 words
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], tcpWindow)
+
+	frame = append(frame, payload...)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(append(pseudoHeader, frame[len(frame)-tcpLength:]...)))
+
+	*seq += uint32(len(payload))
+
+	return s.w.WritePacket(t, frame)
+}
+
+// checksum returns internet checksum of data as defined in RFC 1071
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,124 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type segmentAssert struct {
+	FromServer bool
+	Flags      byte
+	Seq        uint32
+	Ack        uint32
+	Payload    int
+}
+
+// readSegments returns TCP segments of IPv4 capture checking their checksums
+func readSegments(t *testing.T, data []byte) []segmentAssert {
+	var segments []segmentAssert
+	for _, b := range readBlocks(t, data)[2:] {
+		length := binary.LittleEndian.Uint32(b.Body[12:])
+		frame := b.Body[20 : 20+length]
+		assert.Equal(t, etherTypeIPv4, binary.BigEndian.Uint16(frame[12:]))
+
+		ip := frame[14:34]
+		assert.Equal(t, uint16(0), checksum(ip))
+		assert.Equal(t, int(binary.BigEndian.Uint16(ip[2:])), len(frame)-14)
+
+		tcp := frame[34:]
+		pseudoHeader := make([]byte, 12)
+		copy(pseudoHeader, ip[12:20])
+		pseudoHeader[9] = ipProtocolTCP
+		binary.BigEndian.PutUint16(pseudoHeader[10:], uint16(len(tcp)))
+		assert.Equal(t, uint16(0), checksum(append(pseudoHeader, tcp...)))
+
+		segments = append(segments, segmentAssert{
+			FromServer: binary.BigEndian.Uint16(tcp[0:]) == 3306,
+			Flags:      tcp[13],
+			Seq:        binary.BigEndian.Uint32(tcp[4:]),
+			Ack:        binary.BigEndian.Uint32(tcp[8:]),
+			Payload:    len(tcp) - 20,
+		})
+	}
+
+	return segments
+}
+
+func TestStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, "lottip")
+
+	client := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}
+	server := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+	s := w.NewStream(client, server)
+
+	at := time.Now()
+	assert.Nil(t, s.Write(at, true, make([]byte, 10)))
+	assert.Nil(t, s.Write(at, false, make([]byte, 5)))
+	assert.Nil(t, s.Write(at, true, make([]byte, maxSegmentSize+1)))
+	assert.Nil(t, s.Close(at))
+	assert.True(t, s.Closed())
+
+	assert.Equal(t, []segmentAssert{
+		{false, tcpSyn, 0, 0, 0},
+		{true, tcpSyn | tcpAck, 0, 1, 0},
+		{false, tcpAck, 1, 1, 0},
+		{true, tcpPsh | tcpAck, 1, 1, 10},
+		{false, tcpPsh | tcpAck, 1, 11, 5},
+		{true, tcpPsh | tcpAck, 11, 6, maxSegmentSize},
+		{true, tcpPsh | tcpAck, 11 + maxSegmentSize, 6, 1},
+		{false, tcpFin | tcpAck, 6, 12 + maxSegmentSize, 0},
+		{true, tcpFin | tcpAck, 12 + maxSegmentSize, 7, 0},
+		{false, tcpAck, 7, 13 + maxSegmentSize, 0},
+	}, readSegments(t, buf.Bytes()))
+}
+
+func TestStreamGap(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, "lottip")
+
+	client := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}
+	server := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+	s := w.NewStream(client, server)
+
+	// Server bytes 10-19 and client bytes 5-7 are missing, overlapping offset is written as is
+	at := time.Now()
+	assert.Nil(t, s.WriteAt(at, true, 0, make([]byte, 10)))
+	assert.Nil(t, s.WriteAt(at, false, 0, make([]byte, 5)))
+	assert.Nil(t, s.WriteAt(at, true, 20, make([]byte, 4)))
+	assert.Nil(t, s.WriteAt(at, false, 8, make([]byte, 2)))
+	assert.Nil(t, s.WriteAt(at, false, 9, make([]byte, 1)))
+
+	assert.Equal(t, []segmentAssert{
+		{false, tcpSyn, 0, 0, 0},
+		{true, tcpSyn | tcpAck, 0, 1, 0},
+		{false, tcpAck, 1, 1, 0},
+		{true, tcpPsh | tcpAck, 1, 1, 10},
+		{false, tcpPsh | tcpAck, 1, 11, 5},
+		{true, tcpPsh | tcpAck, 21, 6, 4},
+		{false, tcpPsh | tcpAck, 9, 25, 2},
+		{false, tcpPsh | tcpAck, 11, 25, 1},
+	}, readSegments(t, buf.Bytes()))
+}
+
+func TestStreamIPv6(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, "lottip")
+
+	// Mixed addresses fall back to IPv6
+	client := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 50000}
+	server := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+	assert.Nil(t, w.NewStream(client, server).Write(time.Now(), false, []byte{0x01}))
+
+	blocks := readBlocks(t, buf.Bytes())
+	assert.Len(t, blocks, 2+3+1)
+
+	frame := blocks[5].Body[20:]
+	assert.Equal(t, etherTypeIPv6, binary.BigEndian.Uint16(frame[12:]))
+	assert.Equal(t, uint16(21), binary.BigEndian.Uint16(frame[14+4:]))
+	assert.Equal(t, []byte(net.ParseIP("::ffff:127.0.0.1")), frame[14+24:14+40])
+}
@@ -0,0 +1,133 @@
+// Package pcapng writes captures in pcapng format readable by Wireshark and tcpdump.
+// See https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html for format details.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Block types
+const (
+	blockSectionHeader   uint32 = 0x0a0d0d0a
+	blockInterface       uint32 = 0x00000001
+	blockEnhancedPacket  uint32 = 0x00000006
+	byteOrderMagic       uint32 = 0x1a2b3c4d
+	sectionLengthUnknown uint64 = 0xffffffffffffffff
+)
+
+// Option codes
+const (
+	optionEnd         uint16 = 0
+	optionShbUserAppl uint16 = 4
+	optionIfName      uint16 = 2
+)
+
+// LinkTypeEthernet is link type of packets written, each packet starts with Ethernet header
+const LinkTypeEthernet uint16 = 1
+
+// Writer writes packets captured on single Ethernet interface.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes section header and interface description to w.
+// application is recorded as name of application which made the capture.
+func NewWriter(w io.Writer, application string) (*Writer, error) {
+	writer := &Writer{w: w}
+
+	// int<4> ByteOrderMagic
+	// int<2> MajorVersion
+	// int<2> MinorVersion
+	// int<8> SectionLength
+	// Options
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:], 1)
+	binary.LittleEndian.PutUint16(body[6:], 0)
+	binary.LittleEndian.PutUint64(body[8:], sectionLengthUnknown)
+	body = appendOptions(body, option{optionShbUserAppl, []byte(application)})
+
+	if err := writer.writeBlock(blockSectionHeader, body); err != nil {
+		return nil, err
+	}
+
+	// int<2> LinkType
+	// int<2> Reserved
+	// int<4> SnapLen, 0 means no limit
+	// Options
+	body = make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], LinkTypeEthernet)
+	body = appendOptions(body, option{optionIfName, []byte(application)})
+
+	if err := writer.writeBlock(blockInterface, body); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// WritePacket writes packet captured at time t, timestamps have microsecond resolution
+func (w *Writer) WritePacket(t time.Time, packet []byte) error {
+	timestamp := uint64(t.UnixNano() / int64(time.Microsecond))
+
+	// int<4> InterfaceID
+	// int<4> TimestampHigh
+	// int<4> TimestampLow
+	// int<4> CapturedLength
+	// int<4> OriginalLength
+	// string<var> PacketData, padded to 4 bytes
+	body := make([]byte, 20, 20+len(packet)+3)
+	binary.LittleEndian.PutUint32(body[4:], uint32(timestamp>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(timestamp))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = pad(append(body, packet...))
+
+	return w.writeBlock(blockEnhancedPacket, body)
+}
+
+// writeBlock writes block of given type, body must be padded to 4 bytes.
+// Block total length is written both before and after body.
+func (w *Writer) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+
+	block := make([]byte, 8, length)
+	binary.LittleEndian.PutUint32(block[0:], blockType)
+	binary.LittleEndian.PutUint32(block[4:], length)
+	block = append(block, body...)
+	block = append(block, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(block[length-4:], length)
+
+	_, err := w.w.Write(block)
+
+	return err
+}
+
+// option represents block option.
+type option struct {
+	code  uint16
+	value []byte
+}
+
+// appendOptions appends options terminated with end of options to body
+func appendOptions(body []byte, options ...option) []byte {
+	for _, o := range append(options, option{optionEnd, nil}) {
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint16(header[0:], o.code)
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(o.value)))
+		body = pad(append(append(body, header...), o.value...))
+	}
+
+	return body
+}
+
+// pad appends zero bytes to data till its length is multiple of 4
+func pad(data []byte) []byte {
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	return data
+}
@@ -0,0 +1,68 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type block struct {
+	Type uint32
+	Body []byte
+}
+
+// readBlocks splits capture into blocks checking lengths written before and after each block
+func readBlocks(t *testing.T, data []byte) []block {
+	var blocks []block
+	for len(data) > 0 {
+		assert.True(t, len(data) >= 12)
+
+		length := binary.LittleEndian.Uint32(data[4:])
+		assert.Equal(t, uint32(0), length%4)
+		assert.Equal(t, length, binary.LittleEndian.Uint32(data[length-4:]))
+
+		blocks = append(blocks, block{binary.LittleEndian.Uint32(data), data[8 : length-4]})
+		data = data[length:]
+	}
+
+	return blocks
+}
+
+func TestWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, "lottip")
+	assert.Nil(t, err)
+
+	at := time.Unix(1500000000, 123456789)
+	assert.Nil(t, w.WritePacket(at, []byte{0x01, 0x02, 0x03, 0x04, 0x05}))
+
+	blocks := readBlocks(t, buf.Bytes())
+	assert.Len(t, blocks, 3)
+
+	// Section header
+	assert.Equal(t, blockSectionHeader, blocks[0].Type)
+	assert.Equal(t, byteOrderMagic, binary.LittleEndian.Uint32(blocks[0].Body))
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(blocks[0].Body[4:]))
+	assert.Contains(t, string(blocks[0].Body[16:]), "lottip")
+
+	// Interface description
+	assert.Equal(t, blockInterface, blocks[1].Type)
+	assert.Equal(t, LinkTypeEthernet, binary.LittleEndian.Uint16(blocks[1].Body))
+
+	// Enhanced packet
+	body := blocks[2].Body
+	assert.Equal(t, blockEnhancedPacket, blocks[2].Type)
+	timestamp := uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:]))
+	assert.Equal(t, uint64(1500000000123456), timestamp)
+	assert.Equal(t, uint32(5), binary.LittleEndian.Uint32(body[12:]))
+	assert.Equal(t, uint32(5), binary.LittleEndian.Uint32(body[16:]))
+	assert.Equal(t, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x00, 0x00, 0x00}, body[20:])
+}
+
+func TestChecksum(t *testing.T) {
+	// Example from RFC 1071
+	assert.Equal(t, ^uint16(0xddf2), checksum([]byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}))
+	assert.Equal(t, ^uint16(0x0100), checksum([]byte{0x01}))
+}
@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"bytes"
+)
+
+// Authentication plugins
+const (
+	AuthNativePassword      = "mysql_native_password"
+	AuthCachingSha2Password = "caching_sha2_password"
+	AuthSha256Password      = "sha256_password"
+	AuthClearPassword       = "mysql_clear_password"
+	authOldPassword         = "mysql_old_password"
+)
+
+// caching_sha2_password authentication paths
+const (
+	AuthFast = "fast" // Scramble matched password cached by server
+	AuthFull = "full" // Password was sent over TLS or encrypted with server RSA public key
+)
+
+// Packets sent by server during authentication exchange
+const (
+	authSwitchRequest byte = 0xfe
+	authMoreData      byte = 0x01
+
+	// caching_sha2_password results of scramble check sent in AuthMoreData
+	cachingSha2FastAuthSuccess byte = 0x03
+	cachingSha2PerformFullAuth byte = 0x04
+)
+
+// Auth represents outcome of authentication exchange of connection phase.
+type Auth struct {
+	User       string
+	Plugin     string // Auth plugin which completed authentication, the last one requested by server
+	Result     byte   // ResponseOk or ResponseErr
+	Error      string // Failure reason sent by server if Result is ResponseErr
+	Path       string // AuthFast or AuthFull for caching_sha2_password, empty for other plugins
+	PublicKey  bool   // True if server sent its RSA public key to encrypt password
+	RoundTrips int    // Number of client packets answered by server, handshake response included
+}
+
+// AuthDecoder incrementally decodes authentication exchange which follows client handshake response.
+// Server and client packets alternate, so decoder tells which side is expected to send next packet.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html
+type AuthDecoder struct {
+	Auth
+	clientTurn bool
+}
+
+// NewAuthDecoder creates decoder of authentication exchange started by client handshake response.
+// Client builds auth response with plugin announced by server unless it names own plugin.
+func NewAuthDecoder(server *HandshakeV10, client *HandshakeResponse41) *AuthDecoder {
+	plugin := client.AuthPlugin
+	if plugin == "" {
+		plugin = server.AuthPlugin
+	}
+
+	// Servers without CLIENT_PLUGIN_AUTH support native password only
+	if plugin == "" {
+		plugin = AuthNativePassword
+	}
+
+	return &AuthDecoder{Auth: Auth{User: client.User, Plugin: plugin, RoundTrips: 1}}
+}
+
+// ClientTurn returns true if server waits for client packet.
+func (d *AuthDecoder) ClientTurn() bool {
+	return d.clientTurn
+}
+
+// DecodeServerPacket consumes next packet sent by server.
+// Returns true once authentication is over, i.e. OK or ERR packet is consumed.
+func (d *AuthDecoder) DecodeServerPacket(packet []byte) (bool, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return false, err
+	}
+
+	switch packet[4] {
+	case ResponseOk:
+		d.Result = ResponseOk
+		return true, nil
+
+	case ResponseErr:
+		d.Result = ResponseErr
+		d.Error, _ = DecodeErrResponse(packet)
+		return true, nil
+
+	case authSwitchRequest:
+		request, err := DecodeAuthSwitchRequest(packet)
+		if err != nil {
+			return false, err
+		}
+
+		// Client starts over with requested plugin
+		d.Plugin = request.PluginName
+		d.Path = ""
+		d.PublicKey = false
+		d.clientTurn = true
+
+	case authMoreData:
+		d.clientTurn = true
+
+		switch d.Plugin {
+		case AuthCachingSha2Password:
+			if len(packet) == 6 && packet[5] == cachingSha2FastAuthSuccess {
+				// OK packet follows right away
+				d.Path = AuthFast
+				d.clientTurn = false
+				break
+			}
+
+			if len(packet) == 6 && packet[5] == cachingSha2PerformFullAuth {
+				d.Path = AuthFull
+				break
+			}
+
+			// Public key requested by client
+			d.PublicKey = true
+
+		case AuthSha256Password:
+			// sha256_password sends nothing but public key requested by client
+			d.PublicKey = true
+		}
+
+	default:
+		return false, errInvalidPacketType
+	}
+
+	return false, nil
+}
+
+// DecodeClientPacket consumes next packet sent by client, it must be called only if ClientTurn returns true.
+// Client packets carry scrambles, passwords or requests of server public key, so nothing is kept.
+func (d *AuthDecoder) DecodeClientPacket(packet []byte) error {
+	if err := checkPacketLength(4, packet); err != nil {
+		return err
+	}
+
+	d.RoundTrips++
+	d.clientTurn = false
+
+	return nil
+}
+
+// AuthSwitchRequest represents server request to authenticate client with another plugin.
+type AuthSwitchRequest struct {
+	PluginName string
+	PluginData []byte // Scramble for requested plugin
+}
+
+// DecodeAuthSwitchRequest decodes AuthSwitchRequest packet sent by server.
+// Pre-4.1 servers send bare 0xFE requesting mysql_old_password.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_auth_switch_request.html
+//
+// int<1> 0xFE
+// string<NUL> PluginName
+// string<EOF> PluginData
+func DecodeAuthSwitchRequest(packet []byte) (*AuthSwitchRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != authSwitchRequest {
+		return nil, errInvalidPacketType
+	}
+
+	if len(packet) == 5 {
+		return &AuthSwitchRequest{PluginName: authOldPassword}, nil
+	}
+
+	r := bytes.NewReader(packet[5:])
+	request := &AuthSwitchRequest{PluginName: ReadNullTerminatedString(r)}
+	request.PluginData = packet[len(packet)-r.Len():]
+
+	return request, nil
+}
@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAuthDecoder(t *testing.T) {
+
+	type AuthDecoderAssert struct {
+		Name         string
+		ServerPlugin string
+		ClientPlugin string
+		Packets      [][]byte // Packets following handshake response, client packets are fed once decoder expects them
+		Auth         Auth
+	}
+
+	rsaKey := append([]byte{0x01}, "-----BEGIN PUBLIC KEY-----\n"...)
+
+	testData := []*AuthDecoderAssert{
+		{
+			"Native password accepted right away",
+			AuthNativePassword, AuthNativePassword,
+			[][]byte{makeOK(2, 0x00, 0, 0, serverStatusAutocommit)},
+			Auth{User: "root", Plugin: AuthNativePassword, Result: ResponseOk, RoundTrips: 1},
+		},
+		{
+			"Plugin of server is used if client names none",
+			AuthCachingSha2Password, "",
+			[][]byte{makeOK(2, 0x00, 0, 0, serverStatusAutocommit)},
+			Auth{User: "root", Plugin: AuthCachingSha2Password, Result: ResponseOk, RoundTrips: 1},
+		},
+		{
+			"Wrong password",
+			AuthNativePassword, AuthNativePassword,
+			[][]byte{makePacket(2, 0xff, 0x15, 0x04, '#', '2', '8', '0', '0', '0', 'D', 'e', 'n', 'i', 'e', 'd')},
+			Auth{User: "root", Plugin: AuthNativePassword, Result: ResponseErr, Error: "#28000Denied", RoundTrips: 1},
+		},
+		{
+			"Caching sha2 fast auth",
+			AuthCachingSha2Password, AuthCachingSha2Password,
+			[][]byte{
+				makePacket(2, authMoreData, cachingSha2FastAuthSuccess),
+				makeOK(3, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: AuthCachingSha2Password, Result: ResponseOk, Path: AuthFast, RoundTrips: 1},
+		},
+		{
+			"Caching sha2 full auth over TLS",
+			AuthCachingSha2Password, AuthCachingSha2Password,
+			[][]byte{
+				makePacket(2, authMoreData, cachingSha2PerformFullAuth),
+				makePacket(3, 's', 'e', 'c', 'r', 'e', 't', 0x00),
+				makeOK(4, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: AuthCachingSha2Password, Result: ResponseOk, Path: AuthFull, RoundTrips: 2},
+		},
+		{
+			"Caching sha2 full auth with public key",
+			AuthCachingSha2Password, AuthCachingSha2Password,
+			[][]byte{
+				makePacket(2, authMoreData, cachingSha2PerformFullAuth),
+				makePacket(3, 0x02),
+				makePacket(4, rsaKey...),
+				makePacket(5, make([]byte, 256)...),
+				makeOK(6, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: AuthCachingSha2Password, Result: ResponseOk, Path: AuthFull, PublicKey: true, RoundTrips: 3},
+		},
+		{
+			"Switch from caching sha2 to native password",
+			AuthCachingSha2Password, AuthCachingSha2Password,
+			[][]byte{
+				makePacket(2, append([]byte{authSwitchRequest}, "mysql_native_password\x00abcdefghij0123456789\x00"...)...),
+				makePacket(3, make([]byte, 20)...),
+				makeOK(4, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: AuthNativePassword, Result: ResponseOk, RoundTrips: 2},
+		},
+		{
+			"Switch from native password to caching sha2 full auth rejected",
+			AuthNativePassword, AuthNativePassword,
+			[][]byte{
+				makePacket(2, append([]byte{authSwitchRequest}, "caching_sha2_password\x00abcdefghij0123456789\x00"...)...),
+				makePacket(3, make([]byte, 32)...),
+				makePacket(4, authMoreData, cachingSha2PerformFullAuth),
+				makePacket(5, 's', 'e', 'c', 'r', 'e', 't', 0x00),
+				makePacket(6, 0xff, 0x15, 0x04, '#', '2', '8', '0', '0', '0', 'D', 'e', 'n', 'i', 'e', 'd'),
+			},
+			Auth{User: "root", Plugin: AuthCachingSha2Password, Result: ResponseErr, Error: "#28000Denied", Path: AuthFull, RoundTrips: 3},
+		},
+		{
+			"Sha256 password with public key",
+			AuthNativePassword, AuthSha256Password,
+			[][]byte{
+				makePacket(2, rsaKey...),
+				makePacket(3, make([]byte, 256)...),
+				makeOK(4, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: AuthSha256Password, Result: ResponseOk, PublicKey: true, RoundTrips: 2},
+		},
+		{
+			"Switch to clear password",
+			AuthNativePassword, AuthNativePassword,
+			[][]byte{
+				makePacket(2, append([]byte{authSwitchRequest}, "mysql_clear_password\x00"...)...),
+				makePacket(3, 's', 'e', 'c', 'r', 'e', 't', 0x00),
+				makeOK(4, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: AuthClearPassword, Result: ResponseOk, RoundTrips: 2},
+		},
+		{
+			"Switch to old password",
+			AuthNativePassword, AuthNativePassword,
+			[][]byte{
+				makePacket(2, authSwitchRequest),
+				makePacket(3, make([]byte, 9)...),
+				makeOK(4, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			Auth{User: "root", Plugin: authOldPassword, Result: ResponseOk, RoundTrips: 2},
+		},
+	}
+
+	for _, asserted := range testData {
+		decoder := NewAuthDecoder(
+			&HandshakeV10{AuthPlugin: asserted.ServerPlugin},
+			&HandshakeResponse41{User: "root", AuthPlugin: asserted.ClientPlugin},
+		)
+
+		done := false
+		for i, packet := range asserted.Packets {
+			assert.False(t, done, asserted.Name)
+
+			if decoder.ClientTurn() {
+				assert.Nil(t, decoder.DecodeClientPacket(packet), asserted.Name)
+				continue
+			}
+
+			var err error
+			done, err = decoder.DecodeServerPacket(packet)
+			assert.Nil(t, err, asserted.Name)
+			assert.Equal(t, i == len(asserted.Packets)-1, done, asserted.Name)
+		}
+
+		assert.True(t, done, asserted.Name)
+		assert.Equal(t, asserted.Auth, decoder.Auth, asserted.Name)
+	}
+
+	// Neither OK, ERR nor auth exchange packet
+	decoder := NewAuthDecoder(&HandshakeV10{}, &HandshakeResponse41{})
+	_, err := decoder.DecodeServerPacket(makePacket(2, 0x05))
+	assert.Equal(t, errInvalidPacketType, err)
+	assert.Equal(t, AuthNativePassword, decoder.Plugin)
+}
+
+func TestDecodeAuthSwitchRequest(t *testing.T) {
+	request, err := DecodeAuthSwitchRequest(makePacket(2, append([]byte{0xfe}, "mysql_native_password\x00abc\x00"...)...))
+	assert.Nil(t, err)
+	assert.Equal(t, AuthNativePassword, request.PluginName)
+	assert.Equal(t, []byte("abc\x00"), request.PluginData)
+
+	request, err = DecodeAuthSwitchRequest(makePacket(2, 0xfe))
+	assert.Nil(t, err)
+	assert.Equal(t, authOldPassword, request.PluginName)
+
+	_, err = DecodeAuthSwitchRequest(makePacket(2, 0x00))
+	assert.Equal(t, errInvalidPacketType, err)
+}
@@ -0,0 +1,616 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// CommandRequest represents any command sent by client along with its decoded arguments.
+type CommandRequest struct {
+	Command   byte              // Command byte, e.g. ComQuery
+	Name      string            // Command name as it's defined in MySQL source code, e.g. COM_INIT_DB
+	Statement string            // SQL doing the same as command, e.g. USE shop, or command name if there's no such SQL
+	Arguments map[string]string // Command arguments by name, nil if command has no arguments
+}
+
+// CommandName returns name of command as it's defined in MySQL source code
+func CommandName(command byte) string {
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("COM_UNKNOWN(0x%02x)", command)
+}
+
+// DecodeCommandRequest decodes first packet of any command sent by client.
+// capabilities must hold capabilities agreed during handshake, COM_CHANGE_USER layout depends on them.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_command_phase.html
+func DecodeCommandRequest(packet []byte, capabilities uint32) (*CommandRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	command := packet[4]
+	request := &CommandRequest{Command: command, Name: CommandName(command)}
+	request.Statement = request.Name
+
+	switch command {
+	case ComQuery, ComStmtPrepare:
+		decoded, err := DecodeQueryRequest(packet, capabilities)
+		if err != nil {
+			return nil, err
+		}
+		request.Statement = decoded.Query
+
+	case ComInitDB, comCreateDB, comDropDB:
+		decoded, err := DecodeSchemaRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"Schema": decoded.Schema}
+
+		switch command {
+		case ComInitDB:
+			request.Statement = "USE " + quoteIdentifier(decoded.Schema)
+		case comCreateDB:
+			request.Statement = "CREATE DATABASE " + quoteIdentifier(decoded.Schema)
+		case comDropDB:
+			request.Statement = "DROP DATABASE " + quoteIdentifier(decoded.Schema)
+		}
+
+	case ComFieldList:
+		decoded, err := DecodeComFieldListRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"Table": decoded.Table, "Wildcard": decoded.Wildcard}
+		request.Statement = "SHOW FIELDS FROM " + quoteIdentifier(decoded.Table)
+		if decoded.Wildcard != "" {
+			request.Statement += " LIKE " + quoteString(decoded.Wildcard, 0)
+		}
+
+	case comRefresh:
+		decoded, err := DecodeComRefreshRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"SubCommand": fmt.Sprintf("0x%02x", decoded.SubCommand)}
+
+	case comShutdown:
+		decoded, err := DecodeComShutdownRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"Level": strconv.Itoa(int(decoded.Level))}
+		request.Statement = "SHUTDOWN"
+
+	case comProcessKill:
+		decoded, err := DecodeComProcessKillRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"ConnectionID": strconv.FormatUint(uint64(decoded.ConnectionID), 10)}
+		request.Statement = "KILL " + request.Arguments["ConnectionID"]
+
+	case ComChangeUser:
+		decoded, err := DecodeComChangeUserRequest(packet, capabilities)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{
+			"User":       decoded.User,
+			"Schema":     decoded.Schema,
+			"Charset":    strconv.Itoa(int(decoded.Charset)),
+			"AuthPlugin": decoded.AuthPlugin,
+		}
+
+	case comBinlogDump:
+		decoded, err := DecodeComBinlogDumpRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{
+			"Position": strconv.FormatUint(decoded.Position, 10),
+			"Flags":    fmt.Sprintf("0x%04x", decoded.Flags),
+			"ServerID": strconv.FormatUint(uint64(decoded.ServerID), 10),
+			"Filename": decoded.Filename,
+		}
+
+	case comBinlogDumpGTID:
+		decoded, err := DecodeComBinlogDumpGTIDRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{
+			"Position": strconv.FormatUint(decoded.Position, 10),
+			"Flags":    fmt.Sprintf("0x%04x", decoded.Flags),
+			"ServerID": strconv.FormatUint(uint64(decoded.ServerID), 10),
+			"Filename": decoded.Filename,
+		}
+
+	case comTableDump:
+		decoded, err := DecodeComTableDumpRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"Schema": decoded.Schema, "Table": decoded.Table}
+
+	case comRegisterSlave:
+		decoded, err := DecodeComRegisterSlaveRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{
+			"ServerID": strconv.FormatUint(uint64(decoded.ServerID), 10),
+			"Host":     decoded.Host,
+			"User":     decoded.User,
+			"Port":     strconv.Itoa(int(decoded.Port)),
+		}
+
+	case comSetOption:
+		decoded, err := DecodeComSetOptionRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+
+		option, ok := setOptionNames[decoded.Option]
+		if !ok {
+			option = strconv.Itoa(int(decoded.Option))
+		}
+		request.Arguments = map[string]string{"Option": option}
+
+	case ComStmtExecute:
+		// Parameter count is known from statement only, so just StatementID is decoded
+		decoded, err := DecodeComStmtExecuteRequest(packet, 0, 0, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"StatementID": strconv.FormatUint(uint64(decoded.StatementID), 10)}
+
+	case ComStmtSendLongData:
+		decoded, err := DecodeComStmtSendLongDataRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{
+			"StatementID": strconv.FormatUint(uint64(decoded.StatementID), 10),
+			"ParamID":     strconv.Itoa(int(decoded.ParamID)),
+			"Size":        strconv.Itoa(len(decoded.Data)),
+		}
+
+	case ComStmtClose, ComStmtReset, ComStmtFetch:
+		decoded, err := DecodeComStmtRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+		request.Arguments = map[string]string{"StatementID": strconv.FormatUint(uint64(decoded.StatementID), 10)}
+		if command == ComStmtFetch {
+			request.Arguments["RowsNum"] = strconv.FormatUint(uint64(decoded.RowsNum), 10)
+		}
+	}
+
+	return request, nil
+}
+
+// SchemaRequest represents COM_INIT_DB, COM_CREATE_DB or COM_DROP_DB request structure.
+type SchemaRequest struct {
+	Schema string // Name of database
+}
+
+// DecodeSchemaRequest decodes COM_INIT_DB, COM_CREATE_DB and COM_DROP_DB requests from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_init_db/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> Command COM_INIT_DB (0x02), COM_CREATE_DB (0x05) or COM_DROP_DB (0x06)
+// string<EOF> Schema
+func DecodeSchemaRequest(packet []byte) (*SchemaRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	command := packet[4]
+	if command != ComInitDB && command != comCreateDB && command != comDropDB {
+		return nil, errInvalidPacketType
+	}
+
+	return &SchemaRequest{Schema: ReadEOFLengthString(packet[5:])}, nil
+}
+
+// ComFieldListRequest represents COM_FIELD_LIST request structure.
+type ComFieldListRequest struct {
+	Table    string // Name of table
+	Wildcard string // Columns name pattern, empty if all columns are requested
+}
+
+// DecodeComFieldListRequest decodes COM_FIELD_LIST request from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_field_list/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_FIELD_LIST (0x04)
+// string<NUL> Table
+// string<EOF> Wildcard
+func DecodeComFieldListRequest(packet []byte) (*ComFieldListRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != ComFieldList {
+		return nil, errInvalidPacketType
+	}
+
+	payload := packet[5:]
+	end := bytes.IndexByte(payload, 0x00)
+	if end < 0 {
+		return &ComFieldListRequest{Table: ReadEOFLengthString(payload)}, nil
+	}
+
+	return &ComFieldListRequest{Table: string(payload[:end]), Wildcard: ReadEOFLengthString(payload[end+1:])}, nil
+}
+
+// ComRefreshRequest represents COM_REFRESH request structure.
+type ComRefreshRequest struct {
+	SubCommand byte // Flags of what is to be flushed, e.g. 0x01 for privileges
+}
+
+// DecodeComRefreshRequest decodes COM_REFRESH request from client.
+// Basic packet structure shown below.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_refresh.html
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_REFRESH (0x07)
+// int<1> SubCommand
+func DecodeComRefreshRequest(packet []byte) (*ComRefreshRequest, error) {
+	if err := checkPacketLength(6, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comRefresh {
+		return nil, errInvalidPacketType
+	}
+
+	return &ComRefreshRequest{SubCommand: packet[5]}, nil
+}
+
+// ComShutdownRequest represents COM_SHUTDOWN request structure.
+type ComShutdownRequest struct {
+	Level byte // Shutdown level, 0 is default
+}
+
+// DecodeComShutdownRequest decodes COM_SHUTDOWN request from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_shutdown/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_SHUTDOWN (0x08)
+// int<1> Level, optional
+func DecodeComShutdownRequest(packet []byte) (*ComShutdownRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comShutdown {
+		return nil, errInvalidPacketType
+	}
+
+	request := &ComShutdownRequest{}
+	if len(packet) > 5 {
+		request.Level = packet[5]
+	}
+
+	return request, nil
+}
+
+// ComProcessKillRequest represents COM_PROCESS_KILL request structure.
+type ComProcessKillRequest struct {
+	ConnectionID uint32 // ID of connection to be killed
+}
+
+// DecodeComProcessKillRequest decodes COM_PROCESS_KILL request from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_process_kill/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_PROCESS_KILL (0x0c)
+// int<4> ConnectionID
+func DecodeComProcessKillRequest(packet []byte) (*ComProcessKillRequest, error) {
+	if err := checkPacketLength(9, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comProcessKill {
+		return nil, errInvalidPacketType
+	}
+
+	return &ComProcessKillRequest{ConnectionID: binary.LittleEndian.Uint32(packet[5:9])}, nil
+}
+
+// ComChangeUserRequest represents COM_CHANGE_USER request structure.
+type ComChangeUserRequest struct {
+	User         string
+	Schema       string
+	Charset      uint16            // Collation ID, 0 if not sent
+	AuthPlugin   string            // Name of auth plugin, empty if not sent
+	ConnectAttrs map[string]string // Client attributes replacing ones sent in handshake, nil if not sent
+}
+
+// DecodeComChangeUserRequest decodes COM_CHANGE_USER request from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_change_user/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_CHANGE_USER (0x11)
+// string<NUL> User
+// int<1> AuthResponseLength, if CLIENT_SECURE_CONNECTION
+// string<AuthResponseLength> AuthResponse, NUL-terminated string if not CLIENT_SECURE_CONNECTION
+// string<NUL> Schema
+// int<2> Charset, optional
+// string<NUL> AuthPlugin, optional, if CLIENT_PLUGIN_AUTH
+// int<lenenc> AttributesLength, optional, if CLIENT_CONNECT_ATTRS
+// byte<AttributesLength> Attributes, optional, if CLIENT_CONNECT_ATTRS
+func DecodeComChangeUserRequest(packet []byte, capabilities uint32) (*ComChangeUserRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != ComChangeUser {
+		return nil, errInvalidPacketType
+	}
+
+	r := bytes.NewReader(packet[5:])
+	request := &ComChangeUserRequest{User: ReadNullTerminatedString(r)}
+
+	if capabilities&clientSecureConnection != 0 {
+		authResponseLength, err := r.ReadByte()
+		if err != nil {
+			return nil, errInvalidPacketLength
+		}
+		if _, err := r.Seek(int64(authResponseLength), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	} else {
+		ReadNullTerminatedString(r)
+	}
+
+	request.Schema = ReadNullTerminatedString(r)
+
+	if r.Len() < 2 {
+		return request, nil
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &request.Charset); err != nil {
+		return nil, err
+	}
+
+	if capabilities&clientPluginAuth != 0 {
+		request.AuthPlugin = ReadNullTerminatedString(r)
+	}
+
+	if capabilities&clientConnectAttrs != 0 && r.Len() > 0 {
+		attrs, err := readConnectAttrs(r)
+		if err != nil {
+			return nil, err
+		}
+		request.ConnectAttrs = attrs
+	}
+
+	return request, nil
+}
+
+// ComBinlogDumpRequest represents COM_BINLOG_DUMP or COM_BINLOG_DUMP_GTID request structure.
+type ComBinlogDumpRequest struct {
+	Position uint64 // Position in binlog file to start from
+	Flags    uint16
+	ServerID uint32 // ID of replica requesting binlog
+	Filename string // Name of binlog file
+}
+
+// DecodeComBinlogDumpRequest decodes COM_BINLOG_DUMP request from replica.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_binlog_dump/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_BINLOG_DUMP (0x12)
+// int<4> Position
+// int<2> Flags
+// int<4> ServerID
+// string<EOF> Filename
+func DecodeComBinlogDumpRequest(packet []byte) (*ComBinlogDumpRequest, error) {
+	if err := checkPacketLength(15, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comBinlogDump {
+		return nil, errInvalidPacketType
+	}
+
+	return &ComBinlogDumpRequest{
+		Position: uint64(binary.LittleEndian.Uint32(packet[5:9])),
+		Flags:    binary.LittleEndian.Uint16(packet[9:11]),
+		ServerID: binary.LittleEndian.Uint32(packet[11:15]),
+		Filename: ReadEOFLengthString(packet[15:]),
+	}, nil
+}
+
+// DecodeComBinlogDumpGTIDRequest decodes COM_BINLOG_DUMP_GTID request from replica.
+// GTID set sent at the end of packet is not decoded.
+// Basic packet structure shown below.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_binlog_dump_gtid.html
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_BINLOG_DUMP_GTID (0x1e)
+// int<2> Flags
+// int<4> ServerID
+// int<4> FilenameLength
+// string<FilenameLength> Filename
+// int<8> Position
+// ...
+func DecodeComBinlogDumpGTIDRequest(packet []byte) (*ComBinlogDumpRequest, error) {
+	if err := checkPacketLength(15, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comBinlogDumpGTID {
+		return nil, errInvalidPacketType
+	}
+
+	filenameLength := int(binary.LittleEndian.Uint32(packet[11:15]))
+	if err := checkPacketLength(15+filenameLength+8, packet); err != nil {
+		return nil, err
+	}
+
+	return &ComBinlogDumpRequest{
+		Flags:    binary.LittleEndian.Uint16(packet[5:7]),
+		ServerID: binary.LittleEndian.Uint32(packet[7:11]),
+		Filename: string(packet[15 : 15+filenameLength]),
+		Position: binary.LittleEndian.Uint64(packet[15+filenameLength:]),
+	}, nil
+}
+
+// ComTableDumpRequest represents COM_TABLE_DUMP request structure.
+type ComTableDumpRequest struct {
+	Schema string
+	Table  string
+}
+
+// DecodeComTableDumpRequest decodes COM_TABLE_DUMP request from client.
+// Basic packet structure shown below.
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_TABLE_DUMP (0x13)
+// int<1> SchemaLength
+// string<SchemaLength> Schema
+// int<1> TableLength
+// string<TableLength> Table
+func DecodeComTableDumpRequest(packet []byte) (*ComTableDumpRequest, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comTableDump {
+		return nil, errInvalidPacketType
+	}
+
+	r := bytes.NewReader(packet[5:])
+
+	schema, err := readShortString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := readShortString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ComTableDumpRequest{Schema: schema, Table: table}, nil
+}
+
+// ComRegisterSlaveRequest represents COM_REGISTER_SLAVE request structure.
+type ComRegisterSlaveRequest struct {
+	ServerID uint32
+	Host     string
+	User     string
+	Port     uint16
+}
+
+// DecodeComRegisterSlaveRequest decodes COM_REGISTER_SLAVE request from replica.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_register_slave/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_REGISTER_SLAVE (0x15)
+// int<4> ServerID
+// int<1> HostLength
+// string<HostLength> Host
+// int<1> UserLength
+// string<UserLength> User
+// int<1> PasswordLength
+// string<PasswordLength> Password
+// int<2> Port
+// ...
+func DecodeComRegisterSlaveRequest(packet []byte) (*ComRegisterSlaveRequest, error) {
+	if err := checkPacketLength(9, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comRegisterSlave {
+		return nil, errInvalidPacketType
+	}
+
+	request := &ComRegisterSlaveRequest{ServerID: binary.LittleEndian.Uint32(packet[5:9])}
+	r := bytes.NewReader(packet[9:])
+
+	var err error
+	if request.Host, err = readShortString(r); err != nil {
+		return nil, err
+	}
+	if request.User, err = readShortString(r); err != nil {
+		return nil, err
+	}
+
+	// Password is never reported
+	if _, err = readShortString(r); err != nil {
+		return nil, err
+	}
+
+	if err = binary.Read(r, binary.LittleEndian, &request.Port); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	return request, nil
+}
+
+// ComSetOptionRequest represents COM_SET_OPTION request structure.
+type ComSetOptionRequest struct {
+	Option uint16 // 0 enables multi statements, 1 disables them
+}
+
+// DecodeComSetOptionRequest decodes COM_SET_OPTION request from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_set_option/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_SET_OPTION (0x1b)
+// int<2> Option
+func DecodeComSetOptionRequest(packet []byte) (*ComSetOptionRequest, error) {
+	if err := checkPacketLength(7, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comSetOption {
+		return nil, errInvalidPacketType
+	}
+
+	return &ComSetOptionRequest{Option: binary.LittleEndian.Uint16(packet[5:7])}, nil
+}
+
+// readShortString reads string prefixed with its length as single byte
+func readShortString(r *bytes.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", errInvalidPacketLength
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", errInvalidPacketLength
+	}
+
+	return string(buf), nil
+}
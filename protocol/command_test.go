@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeCommandRequest(t *testing.T) {
+
+	type DecodeCommandRequestAssert struct {
+		Packet    []byte
+		Error     error
+		Name      string
+		Statement string
+		Arguments map[string]string
+	}
+
+	changeUser := []byte{0x11, 'b', 'o', 'b', 0x00, 0x02, 0xaa, 0xbb, 's', 'h', 'o', 'p', 0x00, 0x21, 0x00}
+	changeUser = append(changeUser, "mysql_native_password\x00"...)
+
+	testData := []*DecodeCommandRequestAssert{
+		{
+			makePacket(0, 0x03, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1'),
+			nil,
+			"COM_QUERY",
+			"SELECT 1",
+			nil,
+		},
+		{
+			makePacket(0, 0x02, 's', 'h', 'o', 'p'),
+			nil,
+			"COM_INIT_DB",
+			"USE `shop`",
+			map[string]string{"Schema": "shop"},
+		},
+		{
+			makePacket(0, 0x06, 'o', 'l', 'd'),
+			nil,
+			"COM_DROP_DB",
+			"DROP DATABASE `old`",
+			map[string]string{"Schema": "old"},
+		},
+		{
+			makePacket(0, 0x05, 'a', '`', ';', ' ', 'x'),
+			nil,
+			"COM_CREATE_DB",
+			"CREATE DATABASE `a``; x`",
+			map[string]string{"Schema": "a`; x"},
+		},
+		{
+			makePacket(0, 0x0c, 0x2a, 0x00, 0x00, 0x00),
+			nil,
+			"COM_PROCESS_KILL",
+			"KILL 42",
+			map[string]string{"ConnectionID": "42"},
+		},
+		{
+			makePacket(0, 0x04, 'u', 's', 'e', 'r', 's', 0x00, 'i', 'd', '%'),
+			nil,
+			"COM_FIELD_LIST",
+			"SHOW FIELDS FROM `users` LIKE 'id%'",
+			map[string]string{"Table": "users", "Wildcard": "id%"},
+		},
+		{
+			makePacket(0, 0x04, 'u', 's', 'e', 'r', 's', 0x00),
+			nil,
+			"COM_FIELD_LIST",
+			"SHOW FIELDS FROM `users`",
+			map[string]string{"Table": "users", "Wildcard": ""},
+		},
+		{
+			makePacket(0, 0x0e),
+			nil,
+			"COM_PING",
+			"COM_PING",
+			nil,
+		},
+		{
+			makePacket(0, 0x1f),
+			nil,
+			"COM_RESET_CONNECTION",
+			"COM_RESET_CONNECTION",
+			nil,
+		},
+		{
+			makePacket(0, 0x1b, 0x01, 0x00),
+			nil,
+			"COM_SET_OPTION",
+			"COM_SET_OPTION",
+			map[string]string{"Option": "MYSQL_OPTION_MULTI_STATEMENTS_OFF"},
+		},
+		{
+			makePacket(0, 0x07, 0x05),
+			nil,
+			"COM_REFRESH",
+			"COM_REFRESH",
+			map[string]string{"SubCommand": "0x05"},
+		},
+		{
+			makePacket(0, changeUser...),
+			nil,
+			"COM_CHANGE_USER",
+			"COM_CHANGE_USER",
+			map[string]string{"User": "bob", "Schema": "shop", "Charset": "33", "AuthPlugin": "mysql_native_password"},
+		},
+		{
+			makePacket(0, 0x12, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 'b', 'i', 'n', '.', '1'),
+			nil,
+			"COM_BINLOG_DUMP",
+			"COM_BINLOG_DUMP",
+			map[string]string{"Position": "4", "Flags": "0x0000", "ServerID": "2", "Filename": "bin.1"},
+		},
+		{
+			makePacket(0, 0x13, 0x01, 'd', 0x01, 't'),
+			nil,
+			"COM_TABLE_DUMP",
+			"COM_TABLE_DUMP",
+			map[string]string{"Schema": "d", "Table": "t"},
+		},
+		{
+			makePacket(0, 0x15, 0x03, 0x00, 0x00, 0x00, 0x01, 'h', 0x01, 'u', 0x02, 'p', 'w', 0xea, 0x0c),
+			nil,
+			"COM_REGISTER_SLAVE",
+			"COM_REGISTER_SLAVE",
+			map[string]string{"ServerID": "3", "Host": "h", "User": "u", "Port": "3306"},
+		},
+		{
+			makePacket(0, 0x1c, 0x01, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00),
+			nil,
+			"COM_STMT_FETCH",
+			"COM_STMT_FETCH",
+			map[string]string{"StatementID": "1", "RowsNum": "10"},
+		},
+		{
+			makePacket(0, 0x0c, 0x2a),
+			errInvalidPacketLength,
+			"",
+			"",
+			nil,
+		},
+		{
+			makePacket(0, 0x15, 0x03, 0x00, 0x00, 0x00, 0x05, 'h'),
+			errInvalidPacketLength,
+			"",
+			"",
+			nil,
+		},
+	}
+
+	capabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth
+
+	for _, asserted := range testData {
+		decoded, err := DecodeCommandRequest(asserted.Packet, capabilities)
+
+		assert.Equal(t, asserted.Error, err)
+
+		if err == nil {
+			assert.Equal(t, asserted.Packet[4], decoded.Command)
+			assert.Equal(t, asserted.Name, decoded.Name)
+			assert.Equal(t, asserted.Statement, decoded.Statement)
+			assert.Equal(t, asserted.Arguments, decoded.Arguments)
+		}
+	}
+}
+
+func TestDecodeComChangeUserRequest(t *testing.T) {
+	capabilities := clientSecureConnection | clientPluginAuth | clientConnectAttrs
+
+	payload := []byte{0x11}
+	payload = append(payload, "bob\x00"...)
+	payload = append(payload, 0x00)
+	payload = append(payload, "shop\x00"...)
+	payload = append(payload, 0x21, 0x00)
+	payload = append(payload, "mysql_native_password\x00"...)
+
+	decoded, err := DecodeComChangeUserRequest(makePacket(0, payload...), capabilities)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", decoded.User)
+	assert.Nil(t, decoded.ConnectAttrs)
+
+	payload = append(payload, 0x0b, 0x04)
+	payload = append(payload, "_pid\x0512345"...)
+
+	decoded, err = DecodeComChangeUserRequest(makePacket(0, payload...), capabilities)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"_pid": "12345"}, decoded.ConnectAttrs)
+}
+
+func TestCommandName(t *testing.T) {
+	assert.Equal(t, "COM_STMT_SEND_LONG_DATA", CommandName(ComStmtSendLongData))
+	assert.Equal(t, "COM_UNKNOWN(0xfa)", CommandName(0xfa))
+}
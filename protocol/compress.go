@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of compressed protocol
+const (
+	CompressionNone byte = iota
+	CompressionZlib
+	CompressionZstd
+)
+
+// Compression algorithms names
+var compressionNames = map[byte]string{
+	CompressionNone: "",
+	CompressionZlib: "zlib",
+	CompressionZstd: "zstd",
+}
+
+// Decoder is safe for concurrent use with DecodeAll, so it's shared by all connections.
+// Frame never holds more than maxFramePayload bytes, so decoder refuses to decompress more.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxFramePayload))
+
+// CompressionName returns name of compression algorithm, empty if compression is off
+func CompressionName(compression byte) string {
+	return compressionNames[compression]
+}
+
+// ReadCompressedFrame reads single frame of compressed protocol from conn.
+// Returned slice holds 7 bytes header followed by frame payload.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_compression_packet.html
+//
+// int<3> CompressedLength
+// int<1> CompressedSequence
+// int<3> UncompressedLength, 0 if payload is not compressed
+// byte<CompressedLength> Payload
+func ReadCompressedFrame(conn net.Conn) ([]byte, error) {
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		return nil, err
+	}
+
+	compressedLen := int(uint32(frame[0]) | uint32(frame[1])<<8 | uint32(frame[2])<<16)
+
+	payload := make([]byte, compressedLen)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+
+	return append(frame, payload...), nil
+}
+
+// DecompressFrame returns payload of frame read by ReadCompressedFrame.
+// Payload holds MySQL packets, the last one may continue in the next frame.
+// Frames are compressed independently of each other.
+// Frame decompressed to more bytes than its header tells is bad, its payload is never decompressed in full.
+func DecompressFrame(frame []byte, compression byte) ([]byte, error) {
+	if err := checkPacketLength(7, frame); err != nil {
+		return nil, err
+	}
+
+	uncompressedLen := int(uint32(frame[4]) | uint32(frame[5])<<8 | uint32(frame[6])<<16)
+	payload := frame[7:]
+
+	// Small payloads are sent as is
+	if uncompressedLen == 0 {
+		return payload, nil
+	}
+
+	var data []byte
+	var err error
+
+	switch compression {
+	case CompressionZlib:
+		var r io.ReadCloser
+		if r, err = zlib.NewReader(bytes.NewReader(payload)); err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		data, err = ioutil.ReadAll(io.LimitReader(r, int64(uncompressedLen)+1))
+
+	case CompressionZstd:
+		data, err = zstdDecoder.DecodeAll(payload, make([]byte, 0, uncompressedLen))
+
+	default:
+		return nil, errInvalidPacketType
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != uncompressedLen {
+		return nil, errInvalidPacketLength
+	}
+
+	return data, nil
+}
+
+// PacketBuffer collects decompressed payloads of consecutive frames
+// and splits them into MySQL packets.
+type PacketBuffer struct {
+	buf []byte
+}
+
+// Write appends decompressed payload to buffer
+func (b *PacketBuffer) Write(data []byte) {
+	b.buf = append(b.buf, data...)
+}
+
+// Next returns next complete packet or nil if buffer holds no complete packet yet.
+func (b *PacketBuffer) Next() []byte {
+	if len(b.buf) < 4 {
+		return nil
+	}
+
+	packetLen := 4 + int(uint32(b.buf[0])|uint32(b.buf[1])<<8|uint32(b.buf[2])<<16)
+	if len(b.buf) < packetLen {
+		return nil
+	}
+
+	packet := make([]byte, packetLen)
+	copy(packet, b.buf)
+	b.buf = b.buf[packetLen:]
+	if len(b.buf) == 0 {
+		b.buf = nil
+	}
+
+	return packet
+}
+
+// Reset discards buffered data, e.g. after frame which can't be decompressed
+func (b *PacketBuffer) Reset() {
+	b.buf = nil
+}
@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// makeFrame builds compressed protocol frame holding payload compressed with given algorithm
+func makeFrame(seq byte, payload []byte, compression byte) []byte {
+	compressed := payload
+	uncompressedLen := len(payload)
+
+	switch compression {
+	case CompressionZlib:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(payload)
+		w.Close()
+		compressed = buf.Bytes()
+	case CompressionZstd:
+		encoder, _ := zstd.NewWriter(nil)
+		compressed = encoder.EncodeAll(payload, nil)
+	default:
+		uncompressedLen = 0
+	}
+
+	l := len(compressed)
+	frame := []byte{byte(l), byte(l >> 8), byte(l >> 16), seq, byte(uncompressedLen), byte(uncompressedLen >> 8), byte(uncompressedLen >> 16)}
+
+	return append(frame, compressed...)
+}
+
+func TestDecompressFrame(t *testing.T) {
+
+	type DecompressFrameAssert struct {
+		Name        string
+		Compression byte
+		Frame       []byte
+		HasError    bool
+	}
+
+	payload := append(makePacket(0, 0x03, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1'), makePacket(1, 0x01)...)
+
+	corrupted := makeFrame(0, payload, CompressionZstd)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	// Frames decompressed to more bytes than header tells
+	longer := makeFrame(0, payload, CompressionZlib)
+	longer[4]--
+
+	bomb := make([]byte, 32<<20)
+	zlibBomb := makeFrame(0, bomb, CompressionZlib)
+	zlibBomb[4], zlibBomb[5], zlibBomb[6] = byte(len(payload)), 0x00, 0x00
+	zstdBomb := makeFrame(0, bomb, CompressionZstd)
+	zstdBomb[4], zstdBomb[5], zstdBomb[6] = byte(len(payload)), 0x00, 0x00
+
+	testData := []*DecompressFrameAssert{
+		{"Uncompressed payload", CompressionZlib, makeFrame(0, payload, CompressionNone), false},
+		{"zlib", CompressionZlib, makeFrame(0, payload, CompressionZlib), false},
+		{"zstd", CompressionZstd, makeFrame(0, payload, CompressionZstd), false},
+		{"Corrupted zstd", CompressionZstd, corrupted, true},
+		{"Payload longer than header tells", CompressionZlib, longer, true},
+		{"zlib bomb", CompressionZlib, zlibBomb, true},
+		{"zstd bomb", CompressionZstd, zstdBomb, true},
+		{"Short frame", CompressionZlib, []byte{0x00, 0x00, 0x00}, true},
+	}
+
+	for _, asserted := range testData {
+		decompressed, err := DecompressFrame(asserted.Frame, asserted.Compression)
+
+		assert.Equal(t, asserted.HasError, err != nil, asserted.Name)
+		if err == nil {
+			assert.Equal(t, payload, decompressed, asserted.Name)
+		}
+	}
+}
+
+func TestPacketBuffer(t *testing.T) {
+	first := makePacket(0, 0x03, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1')
+	second := makePacket(0, 0x0e)
+
+	var packets PacketBuffer
+
+	// Packet split between frames
+	packets.Write(first[:6])
+	assert.Nil(t, packets.Next())
+
+	packets.Write(append(first[6:], second...))
+	assert.Equal(t, first, packets.Next())
+	assert.Equal(t, second, packets.Next())
+	assert.Nil(t, packets.Next())
+
+	packets.Write(first[:2])
+	packets.Reset()
+	packets.Write(second)
+	assert.Equal(t, second, packets.Next())
+}
@@ -5,16 +5,14 @@ const (
 	ResponseOk          = 0x00
 	responsePrepareOk   = 0x00
 	ResponseErr         = 0xff
-	responseLocalinfile = 0xfb
+	ResponseLocalinfile = 0xfb
 
-	// MySQL field types constants
-	fieldTypeString   = 0xfd
-	fieldTypeLongLong = 0x08
-	fieldTypeDouble   = 0x05
+	// Protocol version of initial handshake sent by server
+	handshakeV10 = 0x0a
 
 	// There is no code for Resultset in MySQL internal protocol
 	// so it's defined here for convenience
-	responseResultset = 0xbb
+	ResponseResultset = 0xbb
 
 	// MySQL connection state constants
 	ConnStateStarted  = 0xf4
@@ -22,11 +20,23 @@ const (
 
 	// Digits after comma
 	doubleDecodePrecision = 6
+
+	// Representation of NULL values in decoded rows and parameters
+	nullValue = "NULL"
+
+	// Column definition flag set for unsigned numeric columns
+	columnFlagUnsigned uint16 = 0x0020
+
+	// COM_STMT_EXECUTE parameter flag set for unsigned integers
+	paramFlagUnsigned byte = 0x80
+
+	// COM_STMT_EXECUTE flag set if parameter count is sent, see DecodeComStmtExecuteRequest
+	parameterCountAvailable byte = 0x08
 )
 
 const (
 	ComQuit byte = iota + 1
-	comInitDB
+	ComInitDB
 	ComQuery
 	ComFieldList
 	comCreateDB
@@ -41,20 +51,153 @@ const (
 	comPing
 	comTime
 	comDelayedInsert
-	comChangeUser
+	ComChangeUser
 	comBinlogDump
 	comTableDump
 	comConnectOut
 	comRegisterSlave
 	ComStmtPrepare
 	ComStmtExecute
-	comStmtSendLongData
+	ComStmtSendLongData
 	ComStmtClose
-	comStmtReset
+	ComStmtReset
 	comSetOption
-	comStmtFetch
+	ComStmtFetch
+	comDaemon
+	comBinlogDumpGTID
+	ComResetConnection
 )
 
+// COM_SLEEP is internal server command which is never sent by clients
+const comSleep byte = 0x00
+
+// Commands names as they're defined in MySQL source code
+var commandNames = map[byte]string{
+	comSleep:            "COM_SLEEP",
+	ComQuit:             "COM_QUIT",
+	ComInitDB:           "COM_INIT_DB",
+	ComQuery:            "COM_QUERY",
+	ComFieldList:        "COM_FIELD_LIST",
+	comCreateDB:         "COM_CREATE_DB",
+	comDropDB:           "COM_DROP_DB",
+	comRefresh:          "COM_REFRESH",
+	comShutdown:         "COM_SHUTDOWN",
+	comStatistics:       "COM_STATISTICS",
+	comProcessInfo:      "COM_PROCESS_INFO",
+	comConnect:          "COM_CONNECT",
+	comProcessKill:      "COM_PROCESS_KILL",
+	comDebug:            "COM_DEBUG",
+	comPing:             "COM_PING",
+	comTime:             "COM_TIME",
+	comDelayedInsert:    "COM_DELAYED_INSERT",
+	ComChangeUser:       "COM_CHANGE_USER",
+	comBinlogDump:       "COM_BINLOG_DUMP",
+	comTableDump:        "COM_TABLE_DUMP",
+	comConnectOut:       "COM_CONNECT_OUT",
+	comRegisterSlave:    "COM_REGISTER_SLAVE",
+	ComStmtPrepare:      "COM_STMT_PREPARE",
+	ComStmtExecute:      "COM_STMT_EXECUTE",
+	ComStmtSendLongData: "COM_STMT_SEND_LONG_DATA",
+	ComStmtClose:        "COM_STMT_CLOSE",
+	ComStmtReset:        "COM_STMT_RESET",
+	comSetOption:        "COM_SET_OPTION",
+	ComStmtFetch:        "COM_STMT_FETCH",
+	comDaemon:           "COM_DAEMON",
+	comBinlogDumpGTID:   "COM_BINLOG_DUMP_GTID",
+	ComResetConnection:  "COM_RESET_CONNECTION",
+}
+
+// COM_SET_OPTION options names
+var setOptionNames = map[uint16]string{
+	0: "MYSQL_OPTION_MULTI_STATEMENTS_ON",
+	1: "MYSQL_OPTION_MULTI_STATEMENTS_OFF",
+}
+
+// MySQL field types constants
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/field__types_8h.html
+const (
+	fieldTypeDecimal    byte = 0x00
+	fieldTypeTiny       byte = 0x01
+	fieldTypeShort      byte = 0x02
+	fieldTypeLong       byte = 0x03
+	fieldTypeFloat      byte = 0x04
+	fieldTypeDouble     byte = 0x05
+	fieldTypeNull       byte = 0x06
+	fieldTypeTimestamp  byte = 0x07
+	fieldTypeLongLong   byte = 0x08
+	fieldTypeInt24      byte = 0x09
+	fieldTypeDate       byte = 0x0a
+	fieldTypeTime       byte = 0x0b
+	fieldTypeDateTime   byte = 0x0c
+	fieldTypeYear       byte = 0x0d
+	fieldTypeNewDate    byte = 0x0e
+	fieldTypeVarChar    byte = 0x0f
+	fieldTypeBit        byte = 0x10
+	fieldTypeTimestamp2 byte = 0x11
+	fieldTypeDateTime2  byte = 0x12
+	fieldTypeTime2      byte = 0x13
+	fieldTypeJSON       byte = 0xf5
+	fieldTypeNewDecimal byte = 0xf6
+	fieldTypeEnum       byte = 0xf7
+	fieldTypeSet        byte = 0xf8
+	fieldTypeTinyBlob   byte = 0xf9
+	fieldTypeMediumBlob byte = 0xfa
+	fieldTypeLongBlob   byte = 0xfb
+	fieldTypeBlob       byte = 0xfc
+	fieldTypeVarString  byte = 0xfd
+	fieldTypeString     byte = 0xfe
+	fieldTypeGeometry   byte = 0xff
+)
+
+// Field types names as they're shown by MySQL client tools
+var fieldTypeNames = map[byte]string{
+	fieldTypeDecimal:    "DECIMAL",
+	fieldTypeTiny:       "TINY",
+	fieldTypeShort:      "SHORT",
+	fieldTypeLong:       "LONG",
+	fieldTypeFloat:      "FLOAT",
+	fieldTypeDouble:     "DOUBLE",
+	fieldTypeNull:       "NULL",
+	fieldTypeTimestamp:  "TIMESTAMP",
+	fieldTypeLongLong:   "LONGLONG",
+	fieldTypeInt24:      "INT24",
+	fieldTypeDate:       "DATE",
+	fieldTypeTime:       "TIME",
+	fieldTypeDateTime:   "DATETIME",
+	fieldTypeYear:       "YEAR",
+	fieldTypeNewDate:    "NEWDATE",
+	fieldTypeVarChar:    "VARCHAR",
+	fieldTypeBit:        "BIT",
+	fieldTypeTimestamp2: "TIMESTAMP2",
+	fieldTypeDateTime2:  "DATETIME2",
+	fieldTypeTime2:      "TIME2",
+	fieldTypeJSON:       "JSON",
+	fieldTypeNewDecimal: "NEWDECIMAL",
+	fieldTypeEnum:       "ENUM",
+	fieldTypeSet:        "SET",
+	fieldTypeTinyBlob:   "TINY_BLOB",
+	fieldTypeMediumBlob: "MEDIUM_BLOB",
+	fieldTypeLongBlob:   "LONG_BLOB",
+	fieldTypeBlob:       "BLOB",
+	fieldTypeVarString:  "VAR_STRING",
+	fieldTypeString:     "STRING",
+	fieldTypeGeometry:   "GEOMETRY",
+}
+
+// Server status flags
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/mysql__com_8h.html
+const (
+	serverStatusInTrans       uint16 = 0x0001
+	serverStatusAutocommit    uint16 = 0x0002
+	serverMoreResultsExists   uint16 = 0x0008
+	serverStatusCursorExists  uint16 = 0x0040
+	serverStatusLastRowSent   uint16 = 0x0080
+	serverSessionStateChanged uint16 = 0x4000
+)
+
+// Session state change type of current database, see DecodeOkSessionState
+const sessionTrackSchema byte = 0x01
+
 // Capability flags
 const (
 	clientLongPassword uint32 = 1 << iota
@@ -82,4 +225,50 @@ const (
 	clientCanHandleExpiredPasswords
 	clientSessionTrack
 	clientDeprecateEOF
+	clientOptionalResultsetMetadata
+	clientZstdCompressionAlgorithm
+	clientQueryAttributes
 )
+
+// Capability flags names as they're defined in MySQL source code, indexed by bit position
+var capabilityNames = []string{
+	"CLIENT_LONG_PASSWORD",
+	"CLIENT_FOUND_ROWS",
+	"CLIENT_LONG_FLAG",
+	"CLIENT_CONNECT_WITH_DB",
+	"CLIENT_NO_SCHEMA",
+	"CLIENT_COMPRESS",
+	"CLIENT_ODBC",
+	"CLIENT_LOCAL_FILES",
+	"CLIENT_IGNORE_SPACE",
+	"CLIENT_PROTOCOL_41",
+	"CLIENT_INTERACTIVE",
+	"CLIENT_SSL",
+	"CLIENT_IGNORE_SIGPIPE",
+	"CLIENT_TRANSACTIONS",
+	"CLIENT_RESERVED",
+	"CLIENT_SECURE_CONNECTION",
+	"CLIENT_MULTI_STATEMENTS",
+	"CLIENT_MULTI_RESULTS",
+	"CLIENT_PS_MULTI_RESULTS",
+	"CLIENT_PLUGIN_AUTH",
+	"CLIENT_CONNECT_ATTRS",
+	"CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA",
+	"CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS",
+	"CLIENT_SESSION_TRACK",
+	"CLIENT_DEPRECATE_EOF",
+	"CLIENT_OPTIONAL_RESULTSET_METADATA",
+	"CLIENT_ZSTD_COMPRESSION_ALGORITHM",
+	"CLIENT_QUERY_ATTRIBUTES",
+}
+
+// Collations of multibyte charsets which may have backslash or quote as trailing byte of character,
+// so values can't be escaped with backslashes safely: big5, sjis, gbk, cp932 and gb18030.
+// See https://dev.mysql.com/doc/refman/8.0/en/charset-mysql.html
+var backslashUnsafeCollations = map[byte]bool{
+	1: true, 84: true,
+	13: true, 88: true,
+	28: true, 87: true,
+	95: true, 96: true,
+	248: true, 249: true, 250: true,
+}
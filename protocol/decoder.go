@@ -3,7 +3,9 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"strconv"
@@ -12,11 +14,27 @@ import (
 var errInvalidPacketLength = errors.New("protocol: Invalid packet length")
 var errInvalidPacketType = errors.New("protocol: Invalid packet type")
 var errFieldTypeNotImplementedYet = errors.New("protocol: Required field type not implemented yet")
+var errUnknownParameterTypes = errors.New("protocol: Types of prepared parameters are unknown")
+var errSSLNotSupported = errors.New("protocol: Client requested SSL which is not supported")
 
 func GetPacketType(packet []byte) byte {
 	return packet[4]
 }
 
+// GetPacketSequence returns sequence id of packet taken from it's header
+func GetPacketSequence(packet []byte) byte {
+	return packet[3]
+}
+
+// FieldTypeName returns name of MySQL field type, e.g. VAR_STRING for 0xfd
+func FieldTypeName(fieldType byte) string {
+	if name, ok := fieldTypeNames[fieldType]; ok {
+		return name
+	}
+
+	return "UNKNOWN(0x" + strconv.FormatUint(uint64(fieldType), 16) + ")"
+}
+
 type ErrResponse struct {
 	Message string
 }
@@ -47,6 +65,8 @@ type OkResponse struct {
 	PacketType   byte
 	AffectedRows uint64
 	LastInsertID uint64
+	StatusFlags  uint16
+	Warnings     uint16
 }
 
 // DecodeOkResponse decodes OK_Packet from server.
@@ -57,6 +77,11 @@ type OkResponse struct {
 // int<1> PacketType (0x00 or 0xFE)
 // int<lenenc> AffectedRows
 // int<lenenc> LastInsertID
+// if clientCapabilities & clientProtocol41
+// {
+//		int<2> StatusFlags
+//		int<2> Warnings
+// }
 // ... more ...
 func DecodeOkResponse(packet []byte) (*OkResponse, error) {
 
@@ -80,7 +105,213 @@ func DecodeOkResponse(packet []byte) (*OkResponse, error) {
 	affectedRows, _ := ReadLenEncodedInteger(r)
 	lastInsertID, _ := ReadLenEncodedInteger(r)
 
-	return &OkResponse{packet[4], affectedRows, lastInsertID}, nil
+	ok := &OkResponse{PacketType: packet[4], AffectedRows: affectedRows, LastInsertID: lastInsertID}
+
+	// StatusFlags and Warnings are sent by 4.1+ servers only
+	if r.Len() >= 4 {
+		binary.Read(r, binary.LittleEndian, &ok.StatusFlags)
+		binary.Read(r, binary.LittleEndian, &ok.Warnings)
+	}
+
+	return ok, nil
+}
+
+// SessionState represents session state changes sent by server in OK_Packet
+// if CLIENT_SESSION_TRACK is set and server status has SERVER_SESSION_STATE_CHANGED flag.
+type SessionState struct {
+	Schema        string // Current database
+	SchemaChanged bool   // True if current database changed
+}
+
+// DecodeOkSessionState decodes session state changes from OK_Packet.
+// It must be called only if CLIENT_SESSION_TRACK was agreed during handshake.
+// Returns empty SessionState if server reported no changes.
+// Part of basic packet structure shown below.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_ok_packet.html
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> PacketType (0x00 or 0xFE)
+// int<lenenc> AffectedRows
+// int<lenenc> LastInsertID
+// int<2> StatusFlags
+// int<2> Warnings
+// string<lenenc> Info
+// string<lenenc> SessionStateChanges, if StatusFlags & SERVER_SESSION_STATE_CHANGED
+func DecodeOkSessionState(packet []byte) (*SessionState, error) {
+	ok, err := DecodeOkResponse(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &SessionState{}
+	if ok.StatusFlags&serverSessionStateChanged == 0 {
+		return state, nil
+	}
+
+	r := bytes.NewReader(packet[4:])
+
+	// Skip PacketType, AffectedRows, LastInsertID, StatusFlags and Warnings
+	r.ReadByte()
+	ReadLenEncodedInteger(r)
+	ReadLenEncodedInteger(r)
+	if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+
+	// Skip Info
+	if _, _, err := ReadLenEncodedString(r); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	changes, _, err := ReadLenEncodedString(r)
+	if err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	// Each change is sent as type followed by length-encoded data
+	cr := bytes.NewReader([]byte(changes))
+	for cr.Len() > 0 {
+		changeType, _ := cr.ReadByte()
+
+		data, _, err := ReadLenEncodedString(cr)
+		if err != nil {
+			return nil, errInvalidPacketLength
+		}
+
+		if changeType == sessionTrackSchema {
+			schema, _, err := ReadLenEncodedString(bytes.NewReader([]byte(data)))
+			if err != nil {
+				return nil, errInvalidPacketLength
+			}
+
+			state.Schema = schema
+			state.SchemaChanged = true
+		}
+	}
+
+	return state, nil
+}
+
+// EOFResponse represents packet sent from the server to the client to mark end of column definitions or rows
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_eof_packet.html
+type EOFResponse struct {
+	Warnings    uint16
+	StatusFlags uint16
+}
+
+// DecodeEOFResponse decodes EOF_Packet from server.
+// Basic packet structure shown below.
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> PacketType (0xFE)
+// if clientCapabilities & clientProtocol41
+// {
+//		int<2> Warnings
+//		int<2> StatusFlags
+// }
+func DecodeEOFResponse(packet []byte) (*EOFResponse, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != responseEof {
+		return nil, errInvalidPacketType
+	}
+
+	eof := &EOFResponse{}
+
+	// Warnings and StatusFlags are sent by 4.1+ servers only
+	if len(packet) >= 9 {
+		eof.Warnings = binary.LittleEndian.Uint16(packet[5:7])
+		eof.StatusFlags = binary.LittleEndian.Uint16(packet[7:9])
+	}
+
+	return eof, nil
+}
+
+// ColumnDefinition represents single column description sent by server as part of result set.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query_response_text_resultset_column_definition.html
+type ColumnDefinition struct {
+	Schema       string
+	Table        string
+	Name         string
+	CharacterSet uint16
+	ColumnLength uint32
+	FieldType    byte
+	Flags        uint16
+	Decimals     byte
+}
+
+// DecodeColumnDefinition41 decodes ColumnDefinition41 packet from server.
+// Basic packet structure shown below.
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// string<lenenc> Catalog (always "def")
+// string<lenenc> Schema
+// string<lenenc> Table (virtual table name)
+// string<lenenc> OrgTable (physical table name)
+// string<lenenc> Name (virtual column name)
+// string<lenenc> OrgName (physical column name)
+// int<lenenc> LengthOfFixedLengthFields (always 0x0c)
+// int<2> CharacterSet
+// int<4> ColumnLength
+// int<1> FieldType
+// int<2> Flags
+// int<1> Decimals
+func DecodeColumnDefinition41(packet []byte) (*ColumnDefinition, error) {
+
+	// Min packet length = header(4 bytes) + 6 empty strings(6 bytes) + fixed length fields(13 bytes)
+	if err := checkPacketLength(23, packet); err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(packet)
+
+	// Skip packet header
+	if err := SkipPacketHeader(r); err != nil {
+		return nil, err
+	}
+
+	// Read Catalog, Schema, Table, OrgTable, Name and OrgName
+	var names [6]string
+	for i := range names {
+		str, _, err := ReadLenEncodedString(r)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		names[i] = str
+	}
+
+	// Skip LengthOfFixedLengthFields
+	ReadLenEncodedInteger(r)
+
+	column := &ColumnDefinition{Schema: names[1], Table: names[2], Name: names[4]}
+
+	if err := binary.Read(r, binary.LittleEndian, &column.CharacterSet); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &column.ColumnLength); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	var err error
+	if column.FieldType, err = r.ReadByte(); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &column.Flags); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	if column.Decimals, err = r.ReadByte(); err != nil {
+		return nil, errInvalidPacketLength
+	}
+
+	return column, nil
 }
 
 // HandshakeV10 represents sever's initial handshake packet
@@ -135,8 +366,11 @@ func DecodeHandshakeV10(packet []byte) (*HandshakeV10, error) {
 		return nil, err
 	}
 
-	// Read ProtocolVersion
+	// Read ProtocolVersion, ERR packet is sent instead of handshake if server refuses connection
 	protoVersion, _ := r.ReadByte()
+	if protoVersion != handshakeV10 {
+		return nil, errInvalidPacketType
+	}
 
 	// Read ServerVersion
 	serverVersion := ReadNullTerminatedString(r)
@@ -218,10 +452,30 @@ func DecodeHandshakeV10(packet []byte) (*HandshakeV10, error) {
 type HandshakeResponse41 struct {
 	ClientCapabilities uint32
 	ClientCharset      byte
+	User               string
+	Database           string            // Database to connect to, empty if client didn't request CLIENT_CONNECT_WITH_DB
+	AuthPlugin         string            // Auth plugin used to build AuthResponse, empty if client didn't request CLIENT_PLUGIN_AUTH
+	ConnectAttrs       map[string]string // Client attributes, e.g. program_name or _pid, nil if client didn't request CLIENT_CONNECT_ATTRS
 }
 
 // DecodeHandshakeResponse41 decodes handshake response packet send by client.
-// TODO: Add packet struct comment
+// Basic packet structure shown below.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_handshake_response.html
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<4> ClientCapabilities
+// int<4> MaxPacketSize
+// int<1> Charset
+// string<23> Filler (all 0x00), SSLRequest ends here
+// string<NUL> Username
+// string<lenenc> AuthResponse, if CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+// int<1> AuthResponseLength and string<AuthResponseLength> AuthResponse, if CLIENT_SECURE_CONNECTION
+// string<NUL> AuthResponse, otherwise
+// string<NUL> Database, if CLIENT_CONNECT_WITH_DB
+// string<NUL> AuthPlugin, if CLIENT_PLUGIN_AUTH
+// int<lenenc> AttributesLength, if CLIENT_CONNECT_ATTRS
+// byte<AttributesLength> Attributes, pairs of string<lenenc> key and string<lenenc> value
 // TODO: Add packet length check
 func DecodeHandshakeResponse41(packet []byte) (*HandshakeResponse41, error) {
 	r := bytes.NewReader(packet)
@@ -249,23 +503,102 @@ func DecodeHandshakeResponse41(packet []byte) (*HandshakeResponse41, error) {
 		return nil, err
 	}
 
-	return &HandshakeResponse41{clientCapabilities, charset}, nil
+	handshake := &HandshakeResponse41{ClientCapabilities: clientCapabilities, ClientCharset: charset}
+
+	// Skip filler, SSLRequest ends here
+	if _, err := r.Seek(23, io.SeekCurrent); err != nil || r.Len() == 0 {
+		return handshake, nil
+	}
+
+	handshake.User = ReadNullTerminatedString(r)
+
+	// Skip AuthResponse
+	switch {
+	case clientCapabilities&clientPluginAuthLenEncClientData != 0:
+		ReadLenEncodedString(r)
+	case clientCapabilities&clientSecureConnection != 0:
+		authResponseLength, err := r.ReadByte()
+		if err != nil {
+			return nil, errInvalidPacketLength
+		}
+		if _, err := r.Seek(int64(authResponseLength), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	default:
+		ReadNullTerminatedString(r)
+	}
+
+	if clientCapabilities&clientConnectWithDB != 0 {
+		handshake.Database = ReadNullTerminatedString(r)
+	}
+
+	if clientCapabilities&clientPluginAuth != 0 {
+		handshake.AuthPlugin = ReadNullTerminatedString(r)
+	}
+
+	if clientCapabilities&clientConnectAttrs != 0 && r.Len() > 0 {
+		if handshake.ConnectAttrs, err = readConnectAttrs(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return handshake, nil
+}
+
+// readConnectAttrs reads connection attributes sent in handshake response or COM_CHANGE_USER.
+// Attributes are prefixed by their total length and follow as key-value pairs of length-encoded strings.
+func readConnectAttrs(r *bytes.Reader) (map[string]string, error) {
+	length, _ := ReadLenEncodedInteger(r)
+	if length > uint64(r.Len()) {
+		return nil, errInvalidPacketLength
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string)
+	for attrsReader := bytes.NewReader(data); attrsReader.Len() > 0; {
+		key, _, err := ReadLenEncodedString(attrsReader)
+		if err != nil {
+			return nil, errInvalidPacketLength
+		}
+
+		value, _, err := ReadLenEncodedString(attrsReader)
+		if err != nil {
+			return nil, errInvalidPacketLength
+		}
+
+		attrs[key] = value
+	}
+
+	return attrs, nil
 }
 
 // QueryRequest represents COM_QUERY or COM_STMT_PREPARE command sent by client to server.
 type QueryRequest struct {
-	Query string // SQL query value
+	Query      string              // SQL query value
+	Attributes []PreparedParameter // Query attributes sent along with COM_QUERY, nil if there are none
 }
 
 // DecodeQueryRequest decodes COM_QUERY and COM_STMT_PREPARE requests from client.
+// capabilities must hold capabilities agreed during handshake, with CLIENT_QUERY_ATTRIBUTES
+// COM_QUERY starts with query attributes.
 // Basic packet structure shown below.
-// See https://mariadb.com/kb/en/mariadb/com_query/ and https://mariadb.com/kb/en/mariadb/com_stmt_prepare/
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query.html
 //
 // int<3> PacketLength
 // int<1> PacketNumber
 // int<1> Command COM_QUERY (0x03) or COM_STMT_PREPARE (0x16)
+// if (COM_QUERY && CLIENT_QUERY_ATTRIBUTES)
+// {
+//		int<lenenc> ParamCount
+//		int<lenenc> ParamSetCount = 1
+//		Parameters as in COM_STMT_EXECUTE if ParamCount > 0
+// }
 // string<EOF> SQLStatement
-func DecodeQueryRequest(packet []byte) (*QueryRequest, error) {
+func DecodeQueryRequest(packet []byte, capabilities uint32) (*QueryRequest, error) {
 
 	// Min packet length = header(4 bytes) + command(1 byte) + SQLStatement(at least 1 byte)
 	if len(packet) < 6 {
@@ -277,12 +610,38 @@ func DecodeQueryRequest(packet []byte) (*QueryRequest, error) {
 		return nil, errInvalidPacketType
 	}
 
-	return &QueryRequest{ReadEOFLengthString(packet[5:])}, nil
+	if packet[4] != ComQuery || capabilities&clientQueryAttributes == 0 {
+		return &QueryRequest{Query: ReadEOFLengthString(packet[5:])}, nil
+	}
+
+	r := bytes.NewReader(packet[5:])
+	count, size := ReadLenEncodedInteger(r)
+	if size == 0 {
+		return nil, errInvalidPacketLength
+	}
+
+	// Attributes are always sent as single set
+	if _, size = ReadLenEncodedInteger(r); size == 0 {
+		return nil, errInvalidPacketLength
+	}
+
+	attributes, err := readParameters(r, count, true, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &QueryRequest{Query: ReadEOFLengthString(packet[len(packet)-r.Len():])}
+	if len(attributes) > 0 {
+		request.Attributes = attributes
+	}
+
+	return request, nil
 }
 
 // ComStmtPrepareOkResponse represents COM_STMT_PREPARE_OK response structure.
 type ComStmtPrepareOkResponse struct {
 	StatementID   uint32 // ID of prepared statement
+	ColumnsNum    uint16 // Num of columns in result set
 	ParametersNum uint16 // Num of prepared parameters
 }
 
@@ -313,9 +672,90 @@ func DecodeComStmtPrepareOkResponse(packet []byte) (*ComStmtPrepareOkResponse, e
 	}
 
 	statementID := binary.LittleEndian.Uint32(packet[5:9])
+	columnsNum := binary.LittleEndian.Uint16(packet[9:11])
 	parametersNum := binary.LittleEndian.Uint16(packet[11:13])
 
-	return &ComStmtPrepareOkResponse{StatementID: statementID, ParametersNum: parametersNum}, nil
+	return &ComStmtPrepareOkResponse{StatementID: statementID, ColumnsNum: columnsNum, ParametersNum: parametersNum}, nil
+}
+
+// ComStmtRequest represents COM_STMT_CLOSE, COM_STMT_RESET or COM_STMT_FETCH request structure.
+type ComStmtRequest struct {
+	Command     byte   // Command the request was sent with
+	StatementID uint32 // ID of prepared statement
+	RowsNum     uint32 // Num of rows to fetch, COM_STMT_FETCH only
+}
+
+// DecodeComStmtRequest decodes COM_STMT_CLOSE, COM_STMT_RESET and COM_STMT_FETCH requests from client.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_stmt_close/, https://mariadb.com/kb/en/mariadb/com_stmt_reset/
+// and https://mariadb.com/kb/en/mariadb/com_stmt_fetch/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> Command COM_STMT_CLOSE (0x19), COM_STMT_RESET (0x1a) or COM_STMT_FETCH (0x1c)
+// int<4> StatementID
+// if Command == COM_STMT_FETCH
+// {
+//		int<4> RowsNum
+// }
+func DecodeComStmtRequest(packet []byte) (*ComStmtRequest, error) {
+
+	// Min packet length = header(4 bytes) + command(1 byte) + statementID(4 bytes)
+	if err := checkPacketLength(9, packet); err != nil {
+		return nil, err
+	}
+
+	command := packet[4]
+	if command != ComStmtClose && command != ComStmtReset && command != ComStmtFetch {
+		return nil, errInvalidPacketType
+	}
+
+	request := &ComStmtRequest{Command: command, StatementID: binary.LittleEndian.Uint32(packet[5:9])}
+
+	if command == ComStmtFetch {
+		if err := checkPacketLength(13, packet); err != nil {
+			return nil, err
+		}
+		request.RowsNum = binary.LittleEndian.Uint32(packet[9:13])
+	}
+
+	return request, nil
+}
+
+// ComStmtSendLongDataRequest represents COM_STMT_SEND_LONG_DATA request structure.
+type ComStmtSendLongDataRequest struct {
+	StatementID uint32 // ID of prepared statement
+	ParamID     uint16 // Index of parameter the data is appended to
+	Data        []byte // Chunk of parameter value
+}
+
+// DecodeComStmtSendLongDataRequest decodes COM_STMT_SEND_LONG_DATA request from client.
+// Client may send parameter value in several chunks before COM_STMT_EXECUTE, server doesn't reply to it.
+// Basic packet structure shown below.
+// See https://mariadb.com/kb/en/mariadb/com_stmt_send_long_data/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_STMT_SEND_LONG_DATA (0x18)
+// int<4> StatementID
+// int<2> ParamID
+// byte<EOF> Data
+func DecodeComStmtSendLongDataRequest(packet []byte) (*ComStmtSendLongDataRequest, error) {
+
+	// Min packet length = header(4 bytes) + command(1 byte) + statementID(4 bytes) + paramID(2 bytes)
+	if err := checkPacketLength(11, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != ComStmtSendLongData {
+		return nil, errInvalidPacketType
+	}
+
+	return &ComStmtSendLongDataRequest{
+		StatementID: binary.LittleEndian.Uint32(packet[5:9]),
+		ParamID:     binary.LittleEndian.Uint16(packet[9:11]),
+		Data:        packet[11:],
+	}, nil
 }
 
 // ComStmtExecuteRequest represents COM_STMT_EXECUTE request structure.
@@ -326,14 +766,30 @@ type ComStmtExecuteRequest struct {
 
 // PreparedParameter structure represents single prepared parameter structure for COM_STMT_EXECUTE request.
 type PreparedParameter struct {
-	FieldType byte   // Type of prepared parameter. See https://mariadb.com/kb/en/mariadb/resultset/#field-types
-	Flag      byte   // Unused
-	Value     string // String value of any prepared parameter passed with COM_STMT_EXECUTE request
+	FieldType byte    // Type of prepared parameter. See https://mariadb.com/kb/en/mariadb/resultset/#field-types
+	Flag      byte    // Parameter flag, paramFlagUnsigned is set for unsigned integers
+	Name      string  // Name of query attribute, empty for parameters of statement
+	Value     string  // String value of any prepared parameter passed with COM_STMT_EXECUTE request
+	Float     float64 // Exact value of FLOAT or DOUBLE parameter, Value is rounded
+	Null      bool    // True if parameter is NULL, Value is "NULL" then
+	LongData  bool    // True if value was sent with COM_STMT_SEND_LONG_DATA, Value is empty then
+}
+
+// Unsigned returns true if parameter holds unsigned integer
+func (p *PreparedParameter) Unsigned() bool {
+	return p.Flag&paramFlagUnsigned != 0
 }
 
 // DecodeComStmtExecuteRequest decodes COM_STMT_EXECUTE packet sent by MySQL client.
+// capabilities must hold capabilities agreed during handshake, with CLIENT_QUERY_ATTRIBUTES
+// parameter count is sent in packet and query attributes follow parameters of statement.
+// Parameter types are sent by client only if they changed since previous execution of the same statement,
+// so types decoded with previous execution must be passed in boundTypes, nil otherwise.
+// Values of parameters sent with COM_STMT_SEND_LONG_DATA are omitted from packet,
+// so indexes of such parameters must be passed in longData.
+// Zero paramsCount without CLIENT_QUERY_ATTRIBUTES decodes StatementID only.
 // Basic packet structure shown below.
-// See https://mariadb.com/kb/en/mariadb/com_stmt_execute/
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_execute.html
 //
 // int<3> PacketLength
 // int<1> PacketNumber
@@ -341,8 +797,12 @@ type PreparedParameter struct {
 // int<4> StatementID
 // int<1> Flags
 // int<4> IterationCount = 1
-// if (ParamCount > 0)
+// if (ParamCount > 0 || CLIENT_QUERY_ATTRIBUTES && (Flags & PARAMETER_COUNT_AVAILABLE))
 // {
+//		if (CLIENT_QUERY_ATTRIBUTES)
+//		{
+//			int<lenenc> ParamCount of statement parameters and query attributes
+//		}
 // 		byte<(ParamCount + 7) / 8> NullBitmap
 // 		byte<1>: SendTypeToServer = 0 or 1
 // 		if (SendTypeToServer)
@@ -351,14 +811,15 @@ type PreparedParameter struct {
 //			{
 // 				byte<1>: FieldType
 //				byte<1>: ParameterFlag
+//				if (CLIENT_QUERY_ATTRIBUTES) string<lenenc> ParameterName
 //			}
 //		}
-// 		Foreach parameter
+// 		Foreach non-NULL parameter not sent with COM_STMT_SEND_LONG_DATA
 //		{
 // 			byte<n> BinaryParameterValue
 //		}
 // }
-func DecodeComStmtExecuteRequest(packet []byte, paramsCount uint16) (*ComStmtExecuteRequest, error) {
+func DecodeComStmtExecuteRequest(packet []byte, capabilities uint32, paramsCount uint16, boundTypes []PreparedParameter, longData map[uint16]bool) (*ComStmtExecuteRequest, error) {
 
 	// Min packet length = header(4 bytes) + command(1 byte) + statementID(4 bytes)
 	// + flags(1 byte) + iteration count(4 bytes)
@@ -371,95 +832,121 @@ func DecodeComStmtExecuteRequest(packet []byte, paramsCount uint16) (*ComStmtExe
 		return nil, errInvalidPacketType
 	}
 
-	r := bytes.NewReader(packet)
+	statementID := binary.LittleEndian.Uint32(packet[5:])
+	flags := packet[9]
 
-	// Skip packet header
-	if err := SkipPacketHeader(r); err != nil {
-		return nil, err
+	// Skip to NullBitmap position
+	r := bytes.NewReader(packet[14:])
+
+	count := uint64(paramsCount)
+	queryAttributes := capabilities&clientQueryAttributes != 0
+	if queryAttributes && (paramsCount > 0 || flags&parameterCountAvailable != 0) {
+		// Query attributes follow parameters of statement
+		var size uint64
+		if count, size = ReadLenEncodedInteger(r); size == 0 || count < uint64(paramsCount) {
+			return nil, errInvalidPacketLength
+		}
 	}
 
-	// Skip to statementID position
-	if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+	parameters, err := readParameters(r, count, queryAttributes, boundTypes, longData)
+	if err != nil {
 		return nil, err
 	}
 
-	// Read StatementID
-	statementIDBuf := make([]byte, 4)
-	if _, err := r.Read(statementIDBuf); err != nil {
-		return nil, err
+	return &ComStmtExecuteRequest{StatementID: statementID, PreparedParameters: parameters[:paramsCount]}, nil
+}
+
+// readParameters reads NullBitmap, types and values of count parameters of COM_STMT_EXECUTE
+// or of query attributes of COM_QUERY, see DecodeComStmtExecuteRequest for structure.
+// Types are followed by names if named is set.
+func readParameters(r *bytes.Reader, count uint64, named bool, boundTypes []PreparedParameter, longData map[uint16]bool) ([]PreparedParameter, error) {
+	if count == 0 {
+		return []PreparedParameter{}, nil
 	}
-	statementID := binary.LittleEndian.Uint32(statementIDBuf)
 
-	// Skip to NullBitmap position
-	if _, err := r.Seek(5, io.SeekCurrent); err != nil {
-		return nil, err
+	// Count comes from packet, so it's checked against packet length before anything is allocated
+	if (count+7)/8 >= uint64(r.Len()) {
+		return nil, errInvalidPacketLength
 	}
 
-	// Make buffer for n=paramsCount prepared parameters
-	parameters := make([]PreparedParameter, paramsCount)
+	parameters := make([]PreparedParameter, count)
 
-	if paramsCount > 0 {
-		nullBitmapLength := int64((paramsCount + 7) / 8)
+	// Read NullBitmap
+	nullBitmap := make([]byte, (count+7)/8)
+	if _, err := io.ReadFull(r, nullBitmap); err != nil {
+		return nil, errInvalidPacketLength
+	}
 
-		// Skip to SendTypeToServer position
-		if _, err := r.Seek(nullBitmapLength, io.SeekCurrent); err != nil {
-			return nil, err
-		}
+	// Read SendTypeToServer
+	sendTypeToServer, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
 
-		// Read SendTypeToServer
-		sendTypeToServer, err := r.ReadByte()
-		if err != nil {
-			return nil, err
-		}
+	if sendTypeToServer == 1 {
+		for index := range parameters {
 
-		if sendTypeToServer == 1 {
-			for index := range parameters {
+			// Read parameter FieldType and ParameterFlag
+			parameterMeta := make([]byte, 2)
+			if _, err := io.ReadFull(r, parameterMeta); err != nil {
+				return nil, errInvalidPacketLength
+			}
 
-				// Read parameter FieldType and ParameterFlag
-				parameterMeta := make([]byte, 2)
-				if _, err := r.Read(parameterMeta); err != nil {
-					return nil, err
-				}
+			parameters[index].FieldType = parameterMeta[0]
+			parameters[index].Flag = parameterMeta[1]
 
-				parameters[index].FieldType = parameterMeta[0]
-				parameters[index].Flag = parameterMeta[1]
+			if named {
+				if parameters[index].Name, _, err = ReadLenEncodedString(r); err != nil {
+					return nil, errInvalidPacketLength
+				}
 			}
 		}
+	} else {
+		// Types are not sent again if they're the same as in previous execution
+		if uint64(len(boundTypes)) != count {
+			return nil, errUnknownParameterTypes
+		}
 
-		var fieldDecoderError error
-		var fieldValue string
-
-		for index, parameter := range parameters {
-			switch parameter.FieldType {
-
-			// MYSQL_TYPE_VAR_STRING (length encoded string)
-			case fieldTypeString:
-				fieldValue, fieldDecoderError = DecodeFieldTypeString(r)
+		for index := range parameters {
+			parameters[index].FieldType = boundTypes[index].FieldType
+			parameters[index].Flag = boundTypes[index].Flag
+		}
+	}
 
-			// MYSQL_TYPE_LONGLONG
-			case fieldTypeLongLong:
-				fieldValue, fieldDecoderError = DecodeFieldTypeLongLong(r)
+	for index := range parameters {
+		parameter := &parameters[index]
 
-			// MYSQL_TYPE_DOUBLE
-			case fieldTypeDouble:
-				fieldValue, fieldDecoderError = DecodeFieldTypeDouble(r)
+		// NULL parameters have no value in packet
+		if nullBitmap[index/8]&(1<<uint(index%8)) != 0 {
+			parameter.Null = true
+			parameter.Value = nullValue
+			continue
+		}
 
-			// Field with missing decoder
-			default:
-				return nil, errFieldTypeNotImplementedYet
-			}
+		// Long data was sent in advance, so there's no value in packet either
+		if longData[uint16(index)] {
+			parameter.LongData = true
+			continue
+		}
 
-			// Return with first decoding error
-			if fieldDecoderError != nil {
-				return nil, fieldDecoderError
-			}
+		var value string
+		var err error
+		if parameter.FieldType == fieldTypeFloat || parameter.FieldType == fieldTypeDouble {
+			parameter.Float, err = readFloat(r, parameter.FieldType)
+			value = formatFloat(parameter.Float, parameter.FieldType)
+		} else {
+			value, err = DecodeBinaryValue(r, parameter.FieldType, parameter.Unsigned())
+		}
 
-			parameters[index].Value = fieldValue
-			fieldValue = ""
+		// Return with first decoding error
+		if err != nil {
+			return nil, err
 		}
+
+		parameter.Value = value
 	}
 
-	return &ComStmtExecuteRequest{StatementID: statementID, PreparedParameters: parameters}, nil
+	return parameters, nil
 }
 
 // DecodeFieldTypeString decodes MYSQL_TYPE_VAR_STRING field (length-encoded string)
@@ -481,7 +968,7 @@ func DecodeFieldTypeLongLong(r *bytes.Reader) (string, error) {
 	var bigIntValue int64
 
 	if err := binary.Read(r, binary.LittleEndian, &bigIntValue); err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return strconv.FormatInt(bigIntValue, 10), nil
@@ -490,16 +977,279 @@ func DecodeFieldTypeLongLong(r *bytes.Reader) (string, error) {
 // DecodeFieldTypeDouble decodes MYSQL_TYPE_DOUBLE field
 // See https://mariadb.com/kb/en/mariadb/resultset/#field-types
 func DecodeFieldTypeDouble(r *bytes.Reader) (string, error) {
-	// Read 8 bytes required for float64
-	doubleLengthBuf := make([]byte, 8)
-	if _, err := r.Read(doubleLengthBuf); err != nil {
+	doubleValue, err := readFloat(r, fieldTypeDouble)
+	if err != nil {
+		return "", err
+	}
+
+	return formatFloat(doubleValue, fieldTypeDouble), nil
+}
+
+// DecodeFieldTypeInteger decodes MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_LONG, MYSQL_TYPE_INT24,
+// MYSQL_TYPE_YEAR and MYSQL_TYPE_LONGLONG fields stored in size bytes
+// See https://mariadb.com/kb/en/mariadb/resultset/#field-types
+func DecodeFieldTypeInteger(r *bytes.Reader, size int, unsigned bool) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		return "", err
+	}
+
+	value := binary.LittleEndian.Uint64(buf)
+	if unsigned {
+		return strconv.FormatUint(value, 10), nil
+	}
+
+	// Extend sign bit for types shorter than 8 bytes
+	shift := uint(64 - size*8)
+
+	return strconv.FormatInt(int64(value<<shift)>>shift, 10), nil
+}
+
+// DecodeFieldTypeFloat decodes MYSQL_TYPE_FLOAT field
+// See https://mariadb.com/kb/en/mariadb/resultset/#field-types
+func DecodeFieldTypeFloat(r *bytes.Reader) (string, error) {
+	floatValue, err := readFloat(r, fieldTypeFloat)
+	if err != nil {
 		return "", err
 	}
 
-	doubleBits := binary.LittleEndian.Uint64(doubleLengthBuf)
-	doubleValue := math.Float64frombits(doubleBits)
+	return formatFloat(floatValue, fieldTypeFloat), nil
+}
+
+// readFloat reads MYSQL_TYPE_FLOAT value stored in 4 bytes or MYSQL_TYPE_DOUBLE value stored in 8 bytes
+func readFloat(r *bytes.Reader, fieldType byte) (float64, error) {
+	if fieldType == fieldTypeFloat {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))), nil
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// formatFloat returns MYSQL_TYPE_FLOAT or MYSQL_TYPE_DOUBLE value rounded to doubleDecodePrecision digits after comma
+func formatFloat(value float64, fieldType byte) string {
+	if fieldType == fieldTypeFloat {
+		return strconv.FormatFloat(value, 'f', doubleDecodePrecision, 32)
+	}
 
-	return strconv.FormatFloat(doubleValue, 'f', doubleDecodePrecision, 64), nil
+	return strconv.FormatFloat(value, 'f', doubleDecodePrecision, 64)
+}
+
+// DecodeFieldTypeDateTime decodes MYSQL_TYPE_DATE, MYSQL_TYPE_DATETIME and MYSQL_TYPE_TIMESTAMP fields.
+// Value is prefixed with it's length: 0 for zero value, 4 for date only, 7 with time and 11 with microseconds.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html
+func DecodeFieldTypeDateTime(r *bytes.Reader, fieldType byte) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if length != 0 && length != 4 && length != 7 && length != 11 {
+		return "", errInvalidPacketLength
+	}
+
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(r, buf[:length]); err != nil {
+		return "", err
+	}
+
+	year := binary.LittleEndian.Uint16(buf[0:2])
+	date := fmt.Sprintf("%04d-%02d-%02d", year, buf[2], buf[3])
+
+	if fieldType == fieldTypeDate || fieldType == fieldTypeNewDate {
+		return date, nil
+	}
+
+	dateTime := fmt.Sprintf("%s %02d:%02d:%02d", date, buf[4], buf[5], buf[6])
+	if length > 7 {
+		dateTime += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(buf[7:11]))
+	}
+
+	return dateTime, nil
+}
+
+// DecodeFieldTypeTime decodes MYSQL_TYPE_TIME field.
+// Value is prefixed with it's length: 0 for zero value, 8 without microseconds and 12 with microseconds.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html
+func DecodeFieldTypeTime(r *bytes.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if length != 0 && length != 8 && length != 12 {
+		return "", errInvalidPacketLength
+	}
+
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(r, buf[:length]); err != nil {
+		return "", err
+	}
+
+	var sign string
+	if buf[0] == 1 {
+		sign = "-"
+	}
+
+	hours := binary.LittleEndian.Uint32(buf[1:5])*24 + uint32(buf[5])
+	value := fmt.Sprintf("%s%02d:%02d:%02d", sign, hours, buf[6], buf[7])
+	if length > 8 {
+		value += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(buf[8:12]))
+	}
+
+	return value, nil
+}
+
+// DecodeFieldTypeBit decodes MYSQL_TYPE_BIT field into hexadecimal literal, e.g. 0x05
+// See https://mariadb.com/kb/en/mariadb/resultset/#field-types
+func DecodeFieldTypeBit(r *bytes.Reader) (string, error) {
+	str, err := DecodeFieldTypeString(r)
+	if err != nil {
+		return "", err
+	}
+
+	return "0x" + hex.EncodeToString([]byte(str)), nil
+}
+
+// DecodeBinaryValue decodes single value of binary protocol according to it's field type.
+// Binary protocol is used for prepared statement parameters and for rows of prepared statement result set.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html
+func DecodeBinaryValue(r *bytes.Reader, fieldType byte, unsigned bool) (string, error) {
+	switch fieldType {
+	case fieldTypeNull:
+		return nullValue, nil
+
+	case fieldTypeTiny:
+		return DecodeFieldTypeInteger(r, 1, unsigned)
+
+	case fieldTypeShort, fieldTypeYear:
+		return DecodeFieldTypeInteger(r, 2, unsigned)
+
+	case fieldTypeLong, fieldTypeInt24:
+		return DecodeFieldTypeInteger(r, 4, unsigned)
+
+	case fieldTypeLongLong:
+		return DecodeFieldTypeInteger(r, 8, unsigned)
+
+	case fieldTypeFloat:
+		return DecodeFieldTypeFloat(r)
+
+	case fieldTypeDouble:
+		return DecodeFieldTypeDouble(r)
+
+	case fieldTypeDate, fieldTypeNewDate, fieldTypeDateTime, fieldTypeTimestamp:
+		return DecodeFieldTypeDateTime(r, fieldType)
+
+	case fieldTypeTime:
+		return DecodeFieldTypeTime(r)
+
+	case fieldTypeBit:
+		return DecodeFieldTypeBit(r)
+
+	case fieldTypeDecimal, fieldTypeNewDecimal, fieldTypeVarChar, fieldTypeVarString, fieldTypeString,
+		fieldTypeEnum, fieldTypeSet, fieldTypeTinyBlob, fieldTypeMediumBlob, fieldTypeLongBlob,
+		fieldTypeBlob, fieldTypeGeometry, fieldTypeJSON:
+		return DecodeFieldTypeString(r)
+	}
+
+	return "", errFieldTypeNotImplementedYet
+}
+
+// DecodeTextRow decodes ProtocolText::ResultsetRow packet sent by server in response to COM_QUERY.
+// NULL values are returned as "NULL" string.
+// Basic packet structure shown below.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query_response_text_resultset_row.html
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// Foreach column
+// {
+//		string<lenenc> Value or 0xFB if value is NULL
+// }
+func DecodeTextRow(packet []byte, columnsNum int) ([]string, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(packet)
+
+	// Skip packet header
+	if err := SkipPacketHeader(r); err != nil {
+		return nil, err
+	}
+
+	values := make([]string, columnsNum)
+	for index := range values {
+		if b, err := r.ReadByte(); err != nil {
+			return nil, errInvalidPacketLength
+		} else if b == 0xfb {
+			values[index] = nullValue
+			continue
+		}
+		r.UnreadByte()
+
+		value, err := DecodeFieldTypeString(r)
+		if err != nil {
+			return nil, err
+		}
+		values[index] = value
+	}
+
+	return values, nil
+}
+
+// DecodeBinaryRow decodes ProtocolBinary::ResultsetRow packet sent by server in response to COM_STMT_EXECUTE.
+// NULL values are returned as "NULL" string.
+// Basic packet structure shown below.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> PacketHeader (0x00)
+// byte<(ColumnsCount + 7 + 2) / 8> NullBitmap (first 2 bits are reserved)
+// Foreach non-NULL column
+// {
+//		byte<n> BinaryValue
+// }
+func DecodeBinaryRow(packet []byte, columns []*ColumnDefinition) ([]string, error) {
+	nullBitmapLength := (len(columns) + 7 + 2) / 8
+
+	if err := checkPacketLength(5+nullBitmapLength, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != ResponseOk {
+		return nil, errInvalidPacketType
+	}
+
+	nullBitmap := packet[5 : 5+nullBitmapLength]
+	r := bytes.NewReader(packet[5+nullBitmapLength:])
+
+	values := make([]string, len(columns))
+	for index, column := range columns {
+		bit := index + 2
+		if nullBitmap[bit/8]&(1<<uint(bit%8)) != 0 {
+			values[index] = nullValue
+			continue
+		}
+
+		value, err := DecodeBinaryValue(r, column.FieldType, column.Flags&columnFlagUnsigned != 0)
+		if err != nil {
+			return nil, err
+		}
+		values[index] = value
+	}
+
+	return values, nil
 }
 
 // ReadLenEncodedInteger returns parsed length-encoded integer and it's offset.
@@ -558,9 +1308,12 @@ func ReadLenEncodedInteger(r *bytes.Reader) (value uint64, offset uint64) {
 // See https://mariadb.com/kb/en/mariadb/protocol-data-types/#length-encoded-strings
 func ReadLenEncodedString(r *bytes.Reader) (string, uint64, error) {
 	strLen, _ := ReadLenEncodedInteger(r)
+	if strLen > uint64(r.Len()) {
+		return "", 0, errInvalidPacketLength
+	}
 
 	strBuf := make([]byte, strLen)
-	if _, err := r.Read(strBuf); err != nil {
+	if _, err := io.ReadFull(r, strBuf); err != nil {
 		return "", 0, err
 	}
 
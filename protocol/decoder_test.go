@@ -25,19 +25,19 @@ func TestDecodeOkResponse(t *testing.T) {
 			},
 			false,
 			nil,
-			OkResponse{0x00, uint64(1), uint64(0)},
+			OkResponse{PacketType: 0x00, AffectedRows: uint64(1), LastInsertID: uint64(0), StatusFlags: 0x0022},
 		},
 		{
 			[]byte{0x07, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00},
 			false,
 			nil,
-			OkResponse{0x00, uint64(0), uint64(0)},
+			OkResponse{PacketType: 0x00, AffectedRows: uint64(0), LastInsertID: uint64(0), StatusFlags: 0x0002},
 		},
 		{
 			[]byte{0x07, 0x00, 0x00, 0x01, 0x00, 0x01, 0x02, 0x02, 0x00, 0x00, 0x00},
 			false,
 			nil,
-			OkResponse{0x00, uint64(1), uint64(2)},
+			OkResponse{PacketType: 0x00, AffectedRows: uint64(1), LastInsertID: uint64(2), StatusFlags: 0x0002},
 		},
 	}
 
@@ -50,6 +50,107 @@ func TestDecodeOkResponse(t *testing.T) {
 			assert.Equal(t, asserted.OkResponse.PacketType, decoded.PacketType)
 			assert.Equal(t, asserted.OkResponse.AffectedRows, decoded.AffectedRows)
 			assert.Equal(t, asserted.OkResponse.LastInsertID, decoded.LastInsertID)
+			assert.Equal(t, asserted.OkResponse.StatusFlags, decoded.StatusFlags)
+			assert.Equal(t, asserted.OkResponse.Warnings, decoded.Warnings)
+		}
+	}
+}
+
+func TestDecodeEOFResponse(t *testing.T) {
+
+	type DecodeEOFResponseAssert struct {
+		Packet   []byte
+		HasError bool
+		Error    error
+		EOFResponse
+	}
+
+	testData := []*DecodeEOFResponseAssert{
+		{
+			[]byte{0x05, 0x00, 0x00, 0x05, 0xfe, 0x01, 0x00, 0x22, 0x00},
+			false,
+			nil,
+			EOFResponse{Warnings: 1, StatusFlags: 0x0022},
+		},
+		{
+			// Incorrect packet type
+			[]byte{0x05, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x02, 0x00},
+			true,
+			errInvalidPacketType,
+			EOFResponse{},
+		},
+		{
+			// Incorrect packet length
+			[]byte{0x05, 0x00, 0x00, 0x05},
+			true,
+			errInvalidPacketLength,
+			EOFResponse{},
+		},
+	}
+
+	for _, asserted := range testData {
+		decoded, err := DecodeEOFResponse(asserted.Packet)
+
+		if asserted.HasError {
+			assert.Equal(t, asserted.Error, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, asserted.EOFResponse, *decoded)
+		}
+	}
+}
+
+func TestDecodeColumnDefinition41(t *testing.T) {
+
+	type DecodeColumnDefinition41Assert struct {
+		Packet   []byte
+		HasError bool
+		Error    error
+		ColumnDefinition
+	}
+
+	testData := []*DecodeColumnDefinition41Assert{
+		{
+			// Column definition for `SELECT id FROM shop.users`
+			[]byte{
+				0x28, 0x00, 0x00, 0x02, 0x03, 0x64, 0x65, 0x66, 0x04, 0x73, 0x68, 0x6f, 0x70, 0x05, 0x75, 0x73,
+				0x65, 0x72, 0x73, 0x05, 0x75, 0x73, 0x65, 0x72, 0x73, 0x02, 0x69, 0x64, 0x02, 0x69, 0x64, 0x0c,
+				0x3f, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x03, 0x03, 0x42, 0x00, 0x00, 0x00,
+			},
+			false,
+			nil,
+			ColumnDefinition{
+				Schema: "shop", Table: "users", Name: "id", CharacterSet: 0x3f, ColumnLength: 11,
+				FieldType: fieldTypeLong, Flags: 0x4203, Decimals: 0,
+			},
+		},
+		{
+			// Column definition for `SELECT 1`
+			[]byte{
+				0x17, 0x00, 0x00, 0x02, 0x03, 0x64, 0x65, 0x66, 0x00, 0x00, 0x00, 0x01, 0x31, 0x00, 0x0c, 0x3f,
+				0x00, 0x01, 0x00, 0x00, 0x00, 0x08, 0x81, 0x00, 0x00, 0x00, 0x00,
+			},
+			false,
+			nil,
+			ColumnDefinition{Name: "1", CharacterSet: 0x3f, ColumnLength: 1, FieldType: fieldTypeLongLong, Flags: 0x81},
+		},
+		{
+			// Incorrect packet length
+			[]byte{0x04, 0x00, 0x00, 0x02, 0x03, 0x64, 0x65, 0x66},
+			true,
+			errInvalidPacketLength,
+			ColumnDefinition{},
+		},
+	}
+
+	for _, asserted := range testData {
+		decoded, err := DecodeColumnDefinition41(asserted.Packet)
+
+		if asserted.HasError {
+			assert.Equal(t, asserted.Error, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, asserted.ColumnDefinition, *decoded)
 		}
 	}
 }
@@ -196,7 +297,7 @@ func TestDecodeComStmtExecuteRequest(t *testing.T) {
 		},
 		{
 			4,
-			// Correct packet with string params and 0-length last param
+			// Correct packet with string params and NULL last param
 			[]byte{
 				0x6a, 0x00, 0x00, 0x00, 0x17, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01,
 				0x01, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd,
@@ -209,11 +310,11 @@ func TestDecodeComStmtExecuteRequest(t *testing.T) {
 			false,
 			nil,
 			uint32(2),
-			[]string{"0", "0", "0", "dhc5tbj241raddmtlve26rvkbv", "2017-06-25 18:19:20", "2017-06-25", "auth", "login", ""},
+			[]string{"0", "0", "0", "dhc5tbj241raddmtlve26rvkbv", "2017-06-25 18:19:20", "2017-06-25", "auth", "login", "NULL"},
 		},
 		{
 			5,
-			// Correct packet with string params and 0-length last param
+			// Correct packet with string params and NULL last param
 			[]byte{
 				0x6d, 0x00, 0x00, 0x00, 0x17, 0x71, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01,
 				0x01, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd, 0x00, 0xfd,
@@ -227,7 +328,7 @@ func TestDecodeComStmtExecuteRequest(t *testing.T) {
 			false,
 			nil,
 			uint32(113),
-			[]string{"1", "1", "0", "dhc5tbj241raddmtlve26rvkbv", "2017-06-30 09:56:18", "2017-06-30", "widgets", "index", ""},
+			[]string{"1", "1", "0", "dhc5tbj241raddmtlve26rvkbv", "2017-06-30 09:56:18", "2017-06-30", "widgets", "index", "NULL"},
 		},
 		{
 			6,
@@ -246,7 +347,7 @@ func TestDecodeComStmtExecuteRequest(t *testing.T) {
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeComStmtExecuteRequest(asserted.Packet, uint16(len(asserted.PreparedParameters)))
+		decoded, err := DecodeComStmtExecuteRequest(asserted.Packet, 0, uint16(len(asserted.PreparedParameters)), nil, nil)
 
 		actualHasError := err != nil
 		if asserted.HasError != actualHasError {
@@ -308,7 +409,7 @@ func TestDecodeQueryRequest(t *testing.T) {
 	}
 
 	for _, asserted := range testData {
-		decoded, err := DecodeQueryRequest(asserted.Packet)
+		decoded, err := DecodeQueryRequest(asserted.Packet, 0)
 
 		if err != nil {
 			assert.Equal(t, asserted.Error, err)
@@ -325,6 +426,7 @@ func TestDecodeComStmtPrepareOkResponse(t *testing.T) {
 		HasError      bool
 		Error         error
 		StatementID   uint32
+		ColumnsNum    uint16
 		ParametersNum uint16
 	}
 
@@ -336,9 +438,10 @@ func TestDecodeComStmtPrepareOkResponse(t *testing.T) {
 			errInvalidPacketLength,
 			0,
 			0,
+			0,
 		},
 		{
-			// Correct packet with StatementID = 1 and ParametersNum = 4
+			// Correct packet with StatementID = 1, ColumnsNum = 4 and ParametersNum = 4
 			[]byte{
 				0x0c, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x04, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
 			},
@@ -346,6 +449,7 @@ func TestDecodeComStmtPrepareOkResponse(t *testing.T) {
 			nil,
 			uint32(1),
 			uint16(4),
+			uint16(4),
 		},
 	}
 
@@ -356,6 +460,7 @@ func TestDecodeComStmtPrepareOkResponse(t *testing.T) {
 			assert.Equal(t, asserted.Error, err)
 		} else {
 			assert.Equal(t, asserted.StatementID, decoded.StatementID)
+			assert.Equal(t, asserted.ColumnsNum, decoded.ColumnsNum)
 			assert.Equal(t, asserted.ParametersNum, decoded.ParametersNum)
 		}
 	}
@@ -389,3 +494,395 @@ func TestReadNullTerminatedString(t *testing.T) {
 	x := bytes.NewReader([]byte{0x35, 0x2e, 0x37, 0x2e, 0x31, 0x38, 0x00})
 	assert.Equal(t, "5.7.18", ReadNullTerminatedString(x))
 }
+
+func TestDecodeBinaryValue(t *testing.T) {
+
+	type DecodeBinaryValueAssert struct {
+		Data      []byte
+		FieldType byte
+		Unsigned  bool
+		HasError  bool
+		Value     string
+	}
+
+	testData := []*DecodeBinaryValueAssert{
+		{[]byte{0xff}, fieldTypeTiny, false, false, "-1"},
+		{[]byte{0xff}, fieldTypeTiny, true, false, "255"},
+		{[]byte{0x18, 0xfc}, fieldTypeShort, false, false, "-1000"},
+		{[]byte{0xe2, 0x07}, fieldTypeYear, true, false, "2018"},
+		{[]byte{0x2e, 0xfb, 0xff, 0xff}, fieldTypeLong, false, false, "-1234"},
+		{[]byte{0xff, 0xff, 0xff, 0xff}, fieldTypeLong, true, false, "4294967295"},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, fieldTypeLongLong, true, false, "18446744073709551615"},
+		{[]byte{0x00, 0x00, 0xc0, 0x3f}, fieldTypeFloat, false, false, "1.500000"},
+		{[]byte{0xcd, 0xcc, 0xcc, 0xcc, 0xcc, 0xdc, 0x5e, 0x40}, fieldTypeDouble, false, false, "123.450000"},
+		{[]byte{0x05, '1', '2', '.', '5', '0'}, fieldTypeNewDecimal, false, false, "12.50"},
+		{[]byte{0x04, 0xe1, 0x07, 0x06, 0x19}, fieldTypeDate, false, false, "2017-06-25"},
+		{[]byte{0x00}, fieldTypeDateTime, false, false, "0000-00-00 00:00:00"},
+		{[]byte{0x07, 0xe1, 0x07, 0x06, 0x19, 0x12, 0x13, 0x14}, fieldTypeDateTime, false, false, "2017-06-25 18:19:20"},
+		{
+			[]byte{0x0b, 0xe1, 0x07, 0x06, 0x19, 0x12, 0x13, 0x14, 0x40, 0xe2, 0x01, 0x00},
+			fieldTypeTimestamp, false, false, "2017-06-25 18:19:20.123456",
+		},
+		{[]byte{0x08, 0x01, 0x01, 0x00, 0x00, 0x00, 0x02, 0x03, 0x04}, fieldTypeTime, false, false, "-26:03:04"},
+		{[]byte{0x00}, fieldTypeTime, false, false, "00:00:00"},
+		{[]byte{0x02, 0xe1, 0x07}, fieldTypeDate, false, true, ""},
+		{append([]byte{0x0c}, make([]byte, 12)...), fieldTypeDateTime, false, true, ""},
+		{append([]byte{0xff}, make([]byte, 255)...), fieldTypeTimestamp, false, true, ""},
+		{[]byte{0x05, 0x00, 0x01, 0x00, 0x00, 0x00}, fieldTypeTime, false, true, ""},
+		{append([]byte{0x0d}, make([]byte, 13)...), fieldTypeTime, false, true, ""},
+		{[]byte{0x02, 0x01, 0x05}, fieldTypeBit, false, false, "0x0105"},
+		{[]byte{0x07, '{', '"', 'a', '"', ':', '1', '}'}, fieldTypeJSON, false, false, `{"a":1}`},
+		{[]byte{0x03, 'a', 'b', 'c'}, fieldTypeBlob, false, false, "abc"},
+		{[]byte{}, fieldTypeNull, false, false, "NULL"},
+		{[]byte{0x01, 0x02}, fieldTypeLong, false, true, ""},
+		{[]byte{0x01}, 0x20, false, true, ""},
+	}
+
+	for _, asserted := range testData {
+		value, err := DecodeBinaryValue(bytes.NewReader(asserted.Data), asserted.FieldType, asserted.Unsigned)
+
+		assert.Equal(t, asserted.HasError, err != nil, FieldTypeName(asserted.FieldType))
+		assert.Equal(t, asserted.Value, value, FieldTypeName(asserted.FieldType))
+	}
+}
+
+func TestDecodeTextRow(t *testing.T) {
+	packet := []byte{0x0a, 0x00, 0x00, 0x05, 0x01, 0x31, 0xfb, 0x00, 0x05, 0x68, 0x65, 0x6c, 0x6c, 0x6f}
+
+	decoded, err := DecodeTextRow(packet, 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "NULL", "", "hello"}, decoded)
+
+	_, err = DecodeTextRow(packet, 5)
+	assert.Equal(t, errInvalidPacketLength, err)
+
+	// Length of value is longer than rest of packet
+	truncated := []byte{0x0d, 0x00, 0x00, 0x05, 0x01, 0x31, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x41}
+	_, err = DecodeTextRow(truncated, 2)
+	assert.Equal(t, errInvalidPacketLength, err)
+
+	truncated = []byte{0x04, 0x00, 0x00, 0x05, 0x01, 0x31, 0x05, 0x68}
+	_, err = DecodeTextRow(truncated, 2)
+	assert.Equal(t, errInvalidPacketLength, err)
+}
+
+func TestDecodeBinaryRow(t *testing.T) {
+	columns := []*ColumnDefinition{
+		{Name: "id", FieldType: fieldTypeLongLong},
+		{Name: "price", FieldType: fieldTypeDouble},
+		{Name: "name", FieldType: fieldTypeVarString},
+		{Name: "qty", FieldType: fieldTypeShort, Flags: columnFlagUnsigned},
+	}
+
+	// Second column is NULL: bit 1 + 2 reserved bits = 0x08
+	packet := []byte{
+		0x11, 0x00, 0x00, 0x04, 0x00, 0x08, 0x2a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x6e,
+		0x61, 0x6d, 0x65, 0xff, 0xff,
+	}
+
+	decoded, err := DecodeBinaryRow(packet, columns)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"42", "NULL", "name", "65535"}, decoded)
+
+	_, err = DecodeBinaryRow([]byte{0x02, 0x00, 0x00, 0x04, 0xfe, 0x00}, columns)
+	assert.Equal(t, errInvalidPacketType, err)
+}
+
+func TestDecodeComStmtRequest(t *testing.T) {
+
+	type DecodeComStmtRequestAssert struct {
+		Packet   []byte
+		HasError bool
+		Error    error
+		ComStmtRequest
+	}
+
+	testData := []*DecodeComStmtRequestAssert{
+		{
+			// COM_STMT_CLOSE for statement 7
+			[]byte{0x05, 0x00, 0x00, 0x00, 0x19, 0x07, 0x00, 0x00, 0x00},
+			false,
+			nil,
+			ComStmtRequest{Command: ComStmtClose, StatementID: 7},
+		},
+		{
+			// COM_STMT_RESET for statement 258
+			[]byte{0x05, 0x00, 0x00, 0x00, 0x1a, 0x02, 0x01, 0x00, 0x00},
+			false,
+			nil,
+			ComStmtRequest{Command: ComStmtReset, StatementID: 258},
+		},
+		{
+			// COM_STMT_FETCH of 100 rows for statement 1
+			[]byte{0x09, 0x00, 0x00, 0x00, 0x1c, 0x01, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00},
+			false,
+			nil,
+			ComStmtRequest{Command: ComStmtFetch, StatementID: 1, RowsNum: 100},
+		},
+		{
+			// COM_STMT_FETCH without rows num
+			[]byte{0x05, 0x00, 0x00, 0x00, 0x1c, 0x01, 0x00, 0x00, 0x00},
+			true,
+			errInvalidPacketLength,
+			ComStmtRequest{},
+		},
+		{
+			// Incorrect packet type
+			[]byte{0x05, 0x00, 0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x00},
+			true,
+			errInvalidPacketType,
+			ComStmtRequest{},
+		},
+	}
+
+	for _, asserted := range testData {
+		decoded, err := DecodeComStmtRequest(asserted.Packet)
+
+		if asserted.HasError {
+			assert.Equal(t, asserted.Error, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, asserted.ComStmtRequest, *decoded)
+		}
+	}
+}
+
+func TestDecodeComStmtExecuteRequestTypes(t *testing.T) {
+	// Parameters: TINY UNSIGNED 255, LONG -2, DATETIME, NULL, BLOB "ab"
+	packet := makePacket(0,
+		0x17, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x08, 0x01,
+		0x01, 0x80, 0x03, 0x00, 0x0c, 0x00, 0x06, 0x00, 0xfc, 0x00,
+		0xff,
+		0xfe, 0xff, 0xff, 0xff,
+		0x07, 0xe2, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05,
+		0x02, 0x61, 0x62,
+	)
+
+	decoded, err := DecodeComStmtExecuteRequest(packet, 0, 5, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), decoded.StatementID)
+
+	var values []string
+	for _, parameter := range decoded.PreparedParameters {
+		values = append(values, parameter.Value)
+	}
+
+	assert.Equal(t, []string{"255", "-2", "2018-01-02 03:04:05", "NULL", "ab"}, values)
+	assert.True(t, decoded.PreparedParameters[0].Unsigned())
+	assert.True(t, decoded.PreparedParameters[3].Null)
+	assert.False(t, decoded.PreparedParameters[4].Null)
+
+	// Same statement executed again without types: TINY UNSIGNED 1, LONG NULL, DATETIME zero, NULL, BLOB ""
+	packet = makePacket(0,
+		0x17, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x0a, 0x00,
+		0x01,
+		0x00,
+		0x00,
+	)
+
+	_, err = DecodeComStmtExecuteRequest(packet, 0, 5, nil, nil)
+	assert.Equal(t, errUnknownParameterTypes, err)
+
+	redecoded, err := DecodeComStmtExecuteRequest(packet, 0, 5, decoded.PreparedParameters, nil)
+	assert.Nil(t, err)
+
+	values = nil
+	for _, parameter := range redecoded.PreparedParameters {
+		values = append(values, parameter.Value)
+	}
+
+	assert.Equal(t, []string{"1", "NULL", "0000-00-00 00:00:00", "NULL", ""}, values)
+}
+
+func TestDecodeComStmtExecuteRequestFloat(t *testing.T) {
+	// Statement 1 executed with DOUBLE 1e-10
+	packet := makePacket(0,
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x00,
+		0x01,
+		0x05, 0x00,
+		0xbb, 0xbd, 0xd7, 0xd9, 0xdf, 0x7c, 0xdb, 0x3d,
+	)
+
+	decoded, err := DecodeComStmtExecuteRequest(packet, 0, 1, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "0.000000", decoded.PreparedParameters[0].Value)
+	assert.Equal(t, 1e-10, decoded.PreparedParameters[0].Float)
+}
+
+func TestDecodeQueryRequestAttributes(t *testing.T) {
+	// Attribute trace = "abc" sent before SQL
+	packet := makePacket(0,
+		0x03,
+		0x01, 0x01,
+		0x00,
+		0x01,
+		0xfd, 0x00, 0x05, 't', 'r', 'a', 'c', 'e',
+		0x03, 'a', 'b', 'c',
+		'S', 'E', 'L', 'E', 'C', 'T', ' ', '1',
+	)
+
+	decoded, err := DecodeQueryRequest(packet, clientQueryAttributes)
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT 1", decoded.Query)
+	if assert.Len(t, decoded.Attributes, 1) {
+		assert.Equal(t, "trace", decoded.Attributes[0].Name)
+		assert.Equal(t, "abc", decoded.Attributes[0].Value)
+	}
+
+	// No attributes
+	decoded, err = DecodeQueryRequest(makePacket(0, 0x03, 0x00, 0x01, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1'), clientQueryAttributes)
+	assert.Nil(t, err)
+	assert.Equal(t, &QueryRequest{Query: "SELECT 1"}, decoded)
+
+	// Prepared statement carries no attributes
+	decoded, err = DecodeQueryRequest(makePacket(0, 0x16, 0x00, 0x01, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1'), clientQueryAttributes)
+	assert.Nil(t, err)
+	assert.Equal(t, "\x00\x01SELECT 1", decoded.Query)
+
+	// Attribute count longer than packet
+	_, err = DecodeQueryRequest(makePacket(0, 0x03, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x01), clientQueryAttributes)
+	assert.Equal(t, errInvalidPacketLength, err)
+}
+
+func TestDecodeComStmtExecuteRequestAttributes(t *testing.T) {
+	// Statement 1 executed with LONG 7 and attribute trace = "abc"
+	packet := makePacket(0,
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+		0x02,
+		0x00,
+		0x01,
+		0x03, 0x00, 0x00,
+		0xfd, 0x00, 0x05, 't', 'r', 'a', 'c', 'e',
+		0x07, 0x00, 0x00, 0x00,
+		0x03, 'a', 'b', 'c',
+	)
+
+	decoded, err := DecodeComStmtExecuteRequest(packet, clientQueryAttributes, 1, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []PreparedParameter{{FieldType: fieldTypeLong, Value: "7"}}, decoded.PreparedParameters)
+
+	// Statement without parameters executed with attribute only
+	packet = makePacket(0,
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+		0x01,
+		0x00,
+		0x01,
+		0xfd, 0x00, 0x05, 't', 'r', 'a', 'c', 'e',
+		0x03, 'a', 'b', 'c',
+	)
+
+	decoded, err = DecodeComStmtExecuteRequest(packet, clientQueryAttributes, 0, nil, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, decoded.PreparedParameters)
+
+	// Count of parameters and attributes can't be less than count of statement parameters
+	_, err = DecodeComStmtExecuteRequest(packet, clientQueryAttributes, 2, nil, nil)
+	assert.Equal(t, errInvalidPacketLength, err)
+}
+
+func TestDecodeComStmtSendLongDataRequest(t *testing.T) {
+	decoded, err := DecodeComStmtSendLongDataRequest(makePacket(0, 0x18, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 'a', 'b', 'c'))
+	assert.Nil(t, err)
+	assert.Equal(t, &ComStmtSendLongDataRequest{StatementID: 2, ParamID: 1, Data: []byte("abc")}, decoded)
+
+	// Empty chunk is valid
+	decoded, err = DecodeComStmtSendLongDataRequest(makePacket(0, 0x18, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00))
+	assert.Nil(t, err)
+	assert.Empty(t, decoded.Data)
+
+	_, err = DecodeComStmtSendLongDataRequest(makePacket(0, 0x18, 0x02, 0x00, 0x00, 0x00))
+	assert.Equal(t, errInvalidPacketLength, err)
+
+	_, err = DecodeComStmtSendLongDataRequest(makePacket(0, 0x17, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00))
+	assert.Equal(t, errInvalidPacketType, err)
+}
+
+func TestDecodeComStmtExecuteRequestLongData(t *testing.T) {
+	// Parameters: LONG 7, BLOB sent with COM_STMT_SEND_LONG_DATA, VAR_STRING "x"
+	packet := makePacket(0,
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x00, 0x01,
+		0x03, 0x00, 0xfc, 0x00, 0xfd, 0x00,
+		0x07, 0x00, 0x00, 0x00,
+		0x01, 'x',
+	)
+
+	decoded, err := DecodeComStmtExecuteRequest(packet, 0, 3, nil, map[uint16]bool{1: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "7", decoded.PreparedParameters[0].Value)
+	assert.True(t, decoded.PreparedParameters[1].LongData)
+	assert.Equal(t, "", decoded.PreparedParameters[1].Value)
+	assert.Equal(t, "x", decoded.PreparedParameters[2].Value)
+	assert.False(t, decoded.PreparedParameters[2].LongData)
+}
+
+func TestDecodeOkSessionState(t *testing.T) {
+	// Current database changed to shop
+	packet := makePacket(1, 0x00, 0x00, 0x00, 0x02, 0x40, 0x00, 0x00, 0x00, 0x07, 0x01, 0x05, 0x04, 's', 'h', 'o', 'p')
+
+	state, err := DecodeOkSessionState(packet)
+	assert.Nil(t, err)
+	assert.Equal(t, &SessionState{Schema: "shop", SchemaChanged: true}, state)
+
+	// No session state changes
+	state, err = DecodeOkSessionState(makeOK(1, 0x00, 0, 0, serverStatusAutocommit))
+	assert.Nil(t, err)
+	assert.False(t, state.SchemaChanged)
+
+	// Session state changes are cut
+	_, err = DecodeOkSessionState(packet[:len(packet)-2])
+	assert.Equal(t, errInvalidPacketLength, err)
+}
+
+func TestDecodeHandshakeResponse41(t *testing.T) {
+	capabilities := clientConnectWithDB | clientProtocol41 | clientSecureConnection | clientPluginAuth
+
+	payload := []byte{byte(capabilities), byte(capabilities >> 8), byte(capabilities >> 16), byte(capabilities >> 24)}
+	payload = append(payload, 0x00, 0x00, 0x00, 0x01, 0x21)
+	payload = append(payload, make([]byte, 23)...)
+	payload = append(payload, "root\x00"...)
+	payload = append(payload, 0x02, 0xaa, 0xbb)
+	payload = append(payload, "shop\x00mysql_native_password\x00"...)
+
+	decoded, err := DecodeHandshakeResponse41(makePacket(1, payload...))
+	assert.Nil(t, err)
+	assert.Equal(t, capabilities, decoded.ClientCapabilities)
+	assert.Equal(t, byte(0x21), decoded.ClientCharset)
+	assert.Equal(t, "root", decoded.User)
+	assert.Equal(t, "shop", decoded.Database)
+	assert.Equal(t, AuthNativePassword, decoded.AuthPlugin)
+	assert.Nil(t, decoded.ConnectAttrs)
+
+	// Connection attributes follow AuthPlugin
+	attrs := []byte{12}
+	attrs = append(attrs, "program_name"...)
+	attrs = append(attrs, 7)
+	attrs = append(attrs, "billing"...)
+	attrs = append(attrs, 4)
+	attrs = append(attrs, "_pid"...)
+	attrs = append(attrs, 2)
+	attrs = append(attrs, "42"...)
+
+	withAttrs := append([]byte{}, payload...)
+	withAttrs[2] |= byte(clientConnectAttrs >> 16)
+	withAttrs = append(withAttrs, byte(len(attrs)))
+	withAttrs = append(withAttrs, attrs...)
+
+	decoded, err = DecodeHandshakeResponse41(makePacket(1, withAttrs...))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"program_name": "billing", "_pid": "42"}, decoded.ConnectAttrs)
+
+	// Attributes cut short
+	_, err = DecodeHandshakeResponse41(makePacket(1, withAttrs[:len(withAttrs)-1]...))
+	assert.Equal(t, errInvalidPacketLength, err)
+
+	// SSLRequest has no Username and Database
+	decoded, err = DecodeHandshakeResponse41(makePacket(1, payload[:32]...))
+	assert.Nil(t, err)
+	assert.Equal(t, "", decoded.Database)
+}
@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var errParametersMismatch = errors.New("protocol: Number of placeholders doesn't match number of parameters")
+var errNotFiniteFloat = errors.New("protocol: NaN or infinite parameter can't be written as SQL literal")
+
+// InterpolateQuery replaces "?" placeholders of prepared statement with parameter values,
+// so the statement may be run as plain query by any MySQL client.
+// Values are written as SQL literals according to their field types and escaped for collation
+// the client connected with, see HandshakeResponse41.ClientCharset.
+// Question marks inside string literals, quoted identifiers and comments are not placeholders.
+func InterpolateQuery(query string, parameters []PreparedParameter, charset byte) (string, error) {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	parameterIndex := 0
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i)
+			b.WriteString(query[i:end])
+			i = end - 1
+
+		case c == '#' || isDashComment(query, i):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+
+		case c == '?':
+			if parameterIndex >= len(parameters) {
+				return "", errParametersMismatch
+			}
+			literal, err := parameterLiteral(&parameters[parameterIndex], charset)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(literal)
+			parameterIndex++
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	if parameterIndex != len(parameters) {
+		return "", errParametersMismatch
+	}
+
+	return b.String(), nil
+}
+
+// isDashComment checks if comment starts at i. Double dash starts comment only if followed by whitespace.
+func isDashComment(query string, i int) bool {
+	return strings.HasPrefix(query[i:], "--") && (i+2 == len(query) || query[i+2] <= ' ')
+}
+
+// skipQuoted returns position right after string literal or quoted identifier starting at start.
+// Quote is escaped either by doubling it or, except for identifiers, with backslash.
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+// parameterLiteral returns value of prepared parameter as SQL literal
+func parameterLiteral(parameter *PreparedParameter, charset byte) (string, error) {
+	if parameter.Null {
+		return nullValue, nil
+	}
+
+	switch parameter.FieldType {
+	case fieldTypeTiny, fieldTypeShort, fieldTypeLong, fieldTypeInt24, fieldTypeLongLong,
+		fieldTypeYear, fieldTypeBit:
+		// Values were decoded from binary representation, so they're valid numeric literals
+		return parameter.Value, nil
+
+	case fieldTypeFloat, fieldTypeDouble:
+		// Value is rounded, so literal is made of exact value. SQL has no literals for NaN and infinity.
+		if math.IsNaN(parameter.Float) || math.IsInf(parameter.Float, 0) {
+			return "", errNotFiniteFloat
+		}
+
+		bits := 64
+		if parameter.FieldType == fieldTypeFloat {
+			bits = 32
+		}
+
+		return strconv.FormatFloat(parameter.Float, 'g', -1, bits), nil
+
+	case fieldTypeDecimal, fieldTypeNewDecimal:
+		// Decimals are sent as strings, so only valid numbers are left unquoted
+		if isDecimalLiteral(parameter.Value) {
+			return parameter.Value, nil
+		}
+	}
+
+	return quoteString(parameter.Value, charset), nil
+}
+
+// isDecimalLiteral checks if value is plain decimal number like -12.50
+func isDecimalLiteral(value string) bool {
+	value = strings.TrimPrefix(value, "-")
+	digits := 0
+
+	for i, c := range value {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.' && !strings.Contains(value[:i], "."):
+		default:
+			return false
+		}
+	}
+
+	return digits > 0
+}
+
+// quoteIdentifier returns name quoted with backquotes, backquotes inside name are doubled
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// quoteString returns value as quoted and escaped string literal.
+// Values which can't be escaped safely with backslashes are written as hexadecimal literals.
+func quoteString(value string, charset byte) string {
+	if backslashUnsafeCollations[charset] || !utf8.ValidString(value) {
+		return "X'" + hex.EncodeToString([]byte(value)) + "'"
+	}
+
+	var b strings.Builder
+	b.Grow(len(value) + 2)
+	b.WriteByte('\'')
+
+	// Same characters are escaped by mysql_real_escape_string()
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	b.WriteByte('\'')
+
+	return b.String()
+}
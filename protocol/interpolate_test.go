@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// utf8GeneralCi is collation id of utf8_general_ci
+const utf8GeneralCi byte = 33
+
+func TestInterpolateQuery(t *testing.T) {
+
+	type InterpolateQueryAssert struct {
+		Name       string
+		Query      string
+		Parameters []PreparedParameter
+		Charset    byte
+		Expected   string
+		Error      error
+	}
+
+	testData := []*InterpolateQueryAssert{
+		{
+			"Numbers and strings",
+			"SELECT * FROM t WHERE id > ? AND name <> ? AND price < ?",
+			[]PreparedParameter{
+				{FieldType: fieldTypeLongLong, Value: "1"},
+				{FieldType: fieldTypeString, Value: "zz"},
+				{FieldType: fieldTypeDouble, Value: "9.500000", Float: 9.5},
+			},
+			utf8GeneralCi,
+			"SELECT * FROM t WHERE id > 1 AND name <> 'zz' AND price < 9.5",
+			nil,
+		},
+		{
+			"NULL and dates",
+			"UPDATE t SET created = ?, name = ? WHERE id = ?",
+			[]PreparedParameter{
+				{FieldType: fieldTypeDateTime, Value: "2018-01-02 03:04:05"},
+				{FieldType: fieldTypeNull, Value: "NULL", Null: true},
+				{FieldType: fieldTypeLong, Value: "-2"},
+			},
+			utf8GeneralCi,
+			"UPDATE t SET created = '2018-01-02 03:04:05', name = NULL WHERE id = -2",
+			nil,
+		},
+		{
+			"Special characters are escaped",
+			"INSERT INTO t VALUES (?)",
+			[]PreparedParameter{{FieldType: fieldTypeVarString, Value: "it's \"a\"\\\n\r\x00\x1a"}},
+			utf8GeneralCi,
+			`INSERT INTO t VALUES ('it\'s \"a\"\\\n\r\0\Z')`,
+			nil,
+		},
+		{
+			"Decimals",
+			"SELECT ?, ?",
+			[]PreparedParameter{
+				{FieldType: fieldTypeNewDecimal, Value: "-12.50"},
+				{FieldType: fieldTypeNewDecimal, Value: "1; DROP TABLE t"},
+			},
+			utf8GeneralCi,
+			"SELECT -12.50, '1; DROP TABLE t'",
+			nil,
+		},
+		{
+			"Placeholders in literals, identifiers and comments are skipped",
+			"SELECT '?', \"a\\\"?\", `?`, 'it''s ?' /* ? */, ? -- ?\n, ? # ?",
+			[]PreparedParameter{
+				{FieldType: fieldTypeTiny, Value: "1"},
+				{FieldType: fieldTypeTiny, Value: "2"},
+			},
+			utf8GeneralCi,
+			"SELECT '?', \"a\\\"?\", `?`, 'it''s ?' /* ? */, 1 -- ?\n, 2 # ?",
+			nil,
+		},
+		{
+			"Double dash without space is not comment",
+			"SELECT 1--?",
+			[]PreparedParameter{{FieldType: fieldTypeTiny, Value: "1"}},
+			utf8GeneralCi,
+			"SELECT 1--1",
+			nil,
+		},
+		{
+			"Binary value is written as hex literal",
+			"INSERT INTO t VALUES (?)",
+			[]PreparedParameter{{FieldType: fieldTypeBlob, Value: "\xff\x00a"}},
+			utf8GeneralCi,
+			"INSERT INTO t VALUES (X'ff0061')",
+			nil,
+		},
+		{
+			"Strings are written as hex literals for GBK",
+			"SELECT ?",
+			[]PreparedParameter{{FieldType: fieldTypeVarString, Value: "a'"}},
+			28,
+			"SELECT X'6127'",
+			nil,
+		},
+		{
+			"Floats are written exactly",
+			"SELECT ?, ?, ?",
+			[]PreparedParameter{
+				{FieldType: fieldTypeDouble, Value: "0.000000", Float: 1e-10},
+				{FieldType: fieldTypeDouble, Value: "-123456789.123457", Float: -123456789.123456789},
+				{FieldType: fieldTypeFloat, Value: "0.100000", Float: float64(float32(0.1))},
+			},
+			utf8GeneralCi,
+			"SELECT 1e-10, -1.2345678912345679e+08, 0.1",
+			nil,
+		},
+		{
+			"NaN is not runnable",
+			"SELECT ?",
+			[]PreparedParameter{{FieldType: fieldTypeDouble, Value: "NaN", Float: math.NaN()}},
+			utf8GeneralCi,
+			"",
+			errNotFiniteFloat,
+		},
+		{
+			"Infinity is not runnable",
+			"SELECT ?",
+			[]PreparedParameter{{FieldType: fieldTypeFloat, Value: "+Inf", Float: math.Inf(1)}},
+			utf8GeneralCi,
+			"",
+			errNotFiniteFloat,
+		},
+		{
+			"Too few parameters",
+			"SELECT ?, ?",
+			[]PreparedParameter{{FieldType: fieldTypeTiny, Value: "1"}},
+			utf8GeneralCi,
+			"",
+			errParametersMismatch,
+		},
+		{
+			"Too many parameters",
+			"SELECT ?",
+			[]PreparedParameter{{FieldType: fieldTypeTiny, Value: "1"}, {FieldType: fieldTypeTiny, Value: "2"}},
+			utf8GeneralCi,
+			"",
+			errParametersMismatch,
+		},
+	}
+
+	for _, asserted := range testData {
+		interpolated, err := InterpolateQuery(asserted.Query, asserted.Parameters, asserted.Charset)
+
+		assert.Equal(t, asserted.Error, err, asserted.Name)
+		assert.Equal(t, asserted.Expected, interpolated, asserted.Name)
+	}
+}
@@ -2,16 +2,29 @@ package protocol
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"io"
 	"net"
+	"time"
 )
 
 //...
 type ConnSettings struct {
 	ClientCapabilities uint32
 	ServerCapabilities uint32
+	ClientCharset      byte
 	SelectedDb         string
+	User               string            // Account client is authenticated as
+	ConnectAttrs       map[string]string // Client attributes sent in handshake response or COM_CHANGE_USER
+}
+
+// ConnectAttrProgramName is connection attribute naming client program, e.g. mysql or service name
+const ConnectAttrProgramName = "program_name"
+
+// Service returns name of client program announced in connection attributes, empty if not announced
+func (h *ConnSettings) Service() string {
+	return h.ConnectAttrs[ConnectAttrProgramName]
 }
 
 //...
@@ -20,43 +33,273 @@ func (h *ConnSettings) DeprecateEOFSet() bool {
 		((clientDeprecateEOF & h.ClientCapabilities) != 0)
 }
 
+// SessionTrackSet returns true if both client and server agreed on CLIENT_SESSION_TRACK,
+// so server reports session state changes in OK packets.
+func (h *ConnSettings) SessionTrackSet() bool {
+	return h.Capabilities()&clientSessionTrack != 0
+}
+
+// Compression returns compression algorithm agreed by client and server.
+// Compression is on once handshake is over.
+func (h *ConnSettings) Compression() byte {
+	switch capabilities := h.Capabilities(); {
+	case capabilities&clientZstdCompressionAlgorithm != 0:
+		return CompressionZstd
+	case capabilities&clientCompress != 0:
+		return CompressionZlib
+	}
+
+	return CompressionNone
+}
+
+// Capabilities returns capability flags negotiated by client and server,
+// i.e. flags announced by server and requested by client at the same time.
+func (h *ConnSettings) Capabilities() uint32 {
+	return h.ServerCapabilities & h.ClientCapabilities
+}
+
+// CapabilityNames returns human readable names of capability flags set in flags
+func CapabilityNames(flags uint32) []string {
+	var names []string
+	for bit, name := range capabilityNames {
+		if flags&(1<<uint(bit)) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// TLSConfig holds TLS settings of proxied connections.
+type TLSConfig struct {
+	Listener *tls.Config // Config of TLS sessions with clients, nil disables TLS
+	Upstream *tls.Config // Config of TLS sessions with MySQL server
+}
+
+// Handshake holds results of connection phase relayed between client and server.
+type Handshake struct {
+	Server     *HandshakeV10        // Initial handshake sent by server
+	Client     *HandshakeResponse41 // Handshake response sent by client
+	ClientConn net.Conn             // Connection to client, TLS connection if client requested TLS
+	ServerConn net.Conn             // Connection to server, TLS connection if client requested TLS
+	TLS        bool                 // True if connection switched to TLS
+	Auth       *Auth                // Outcome of authentication exchange
+	Packets    []HandshakePacket    // Packets relayed in order, SSLRequest is left out
+}
+
+// HandshakePacket represents packet relayed during handshake as seen by proxy, i.e. decrypted.
+type HandshakePacket struct {
+	Time       time.Time
+	FromServer bool
+	Data       []byte
+}
+
 // ProcessHandshake handles handshake between server and client.
-// Returns server and client handshake responses
-func ProcessHandshake(client net.Conn, mysql net.Conn) (*HandshakeV10, *HandshakeResponse41, error) {
+// If tlsConfig.Listener is set and server supports SSL, client may request TLS: the proxy then terminates
+// client TLS session and opens own TLS session to server, so packets are still seen decrypted.
+// Otherwise SSL support is hidden from client, since encrypted traffic can't be inspected.
+// Authentication exchange is relayed until server accepts or rejects client, rejection is not an error.
+// Returns handshake packets timed with now and connections to be used after handshake.
+// Handshake is returned on error too: packets read so far are forwarded already,
+// so the rest of traffic is relayed over its connections.
+func ProcessHandshake(client net.Conn, mysql net.Conn, tlsConfig *TLSConfig, now func() time.Time) (*Handshake, error) {
+	handshake := &Handshake{ClientConn: client, ServerConn: mysql}
+	record := func(fromServer bool, packet []byte) {
+		handshake.Packets = append(handshake.Packets, HandshakePacket{now(), fromServer, packet})
+	}
 
 	// Read server handshake
-	packet, err := ProxyPacket(mysql, client)
+	packet, err := ReadPacket(mysql)
 	if err != nil {
-		println(err.Error())
-		return nil, nil, err
+		return handshake, err
 	}
 
-	serverHandshake, err := DecodeHandshakeV10(packet)
-	if err != nil {
-		println(err.Error())
-		return nil, nil, err
+	// Handshake which can't be inspected, e.g. ERR sent by server refusing connection, is forwarded as is
+	serverHandshake, inspectErr := DecodeHandshakeV10(packet)
+
+	// TLS is possible only if both proxy and server support it
+	sslSupported := inspectErr == nil && tlsConfig != nil && tlsConfig.Listener != nil &&
+		serverHandshake.ServerCapabilities&clientSSL != 0
+	if inspectErr == nil {
+		inspectErr = setHandshakeV10SSL(packet, sslSupported)
+	}
+
+	if _, err = WritePacket(packet, client); err != nil {
+		return handshake, err
+	}
+	record(true, packet)
+
+	if inspectErr != nil {
+		return handshake, inspectErr
 	}
+	handshake.Server = serverHandshake
 
-	// Read client handshake response
+	// Read client handshake response or SSLRequest
 	packet, err = ProxyPacket(client, mysql)
 	if err != nil {
-		println(err.Error())
-		return nil, nil, err
+		return handshake, err
 	}
 
 	clientHandshake, err := DecodeHandshakeResponse41(packet)
 	if err != nil {
-		println(err.Error())
-		return nil, nil, err
+		return handshake, err
 	}
 
-	// Read server OK response
-	if _, err = ProxyPacket(mysql, client); err != nil {
-		println(err.Error())
-		return nil, nil, err
+	if clientHandshake.ClientCapabilities&clientSSL != 0 {
+		if !sslSupported {
+			return handshake, errSSLNotSupported
+		}
+
+		// Server expects TLS session right after SSLRequest, so does client.
+		// Connections are switched before TLS handshakes, failed TLS connection fails any read or write then.
+		serverTLS := tls.Client(mysql, tlsConfig.Upstream)
+		clientTLS := tls.Server(client, tlsConfig.Listener)
+		handshake.ClientConn, handshake.ServerConn, handshake.TLS = clientTLS, serverTLS, true
+
+		if err := serverTLS.Handshake(); err != nil {
+			return handshake, err
+		}
+
+		if err := clientTLS.Handshake(); err != nil {
+			return handshake, err
+		}
+
+		// Read actual client handshake response sent over TLS
+		packet, err = ProxyPacket(handshake.ClientConn, handshake.ServerConn)
+		if err != nil {
+			return handshake, err
+		}
+
+		if clientHandshake, err = DecodeHandshakeResponse41(packet); err != nil {
+			return handshake, err
+		}
 	}
+	handshake.Client = clientHandshake
+	record(false, packet)
+
+	// Relay auth exchange, server may switch plugin or request more data before OK or ERR
+	auth := NewAuthDecoder(serverHandshake, clientHandshake)
+	for {
+		packet, err = ProxyPacket(handshake.ServerConn, handshake.ClientConn)
+		if err != nil {
+			return handshake, err
+		}
+		record(true, packet)
+
+		done, err := auth.DecodeServerPacket(packet)
+		if err != nil {
+			return handshake, err
+		}
+
+		if done {
+			break
+		}
+
+		if auth.ClientTurn() {
+			if packet, err = ProxyPacket(handshake.ClientConn, handshake.ServerConn); err != nil {
+				return handshake, err
+			}
+			record(false, packet)
+
+			if err = auth.DecodeClientPacket(packet); err != nil {
+				return handshake, err
+			}
+		}
+	}
+	handshake.Auth = &auth.Auth
+
+	return handshake, nil
+}
+
+// PlainHandshakeResponse41 returns copy of HandshakeResponse41 packet with TLS and compression capability flags cleared,
+// so packets relayed decrypted and decompressed read as plain connection.
+// AuthResponse is emptied, so packet doesn't hold credentials, e.g. password sent by mysql_clear_password.
+// See DecodeHandshakeResponse41 for packet structure.
+func PlainHandshakeResponse41(packet []byte) ([]byte, error) {
+	if err := checkPacketLength(8, packet); err != nil {
+		return nil, err
+	}
+
+	capabilities := binary.LittleEndian.Uint32(packet[4:])
+	plain := withoutAuthResponse(packet, capabilities)
+
+	capabilities &^= clientSSL | clientCompress | clientZstdCompressionAlgorithm
+	binary.LittleEndian.PutUint32(plain[4:], capabilities)
 
-	return serverHandshake, clientHandshake, nil
+	return plain, nil
+}
+
+// withoutAuthResponse returns copy of HandshakeResponse41 packet with empty AuthResponse.
+// Packet without username, e.g. SSLRequest, is copied as is.
+func withoutAuthResponse(packet []byte, capabilities uint32) []byte {
+	// Username follows capabilities, max packet size, charset and filler
+	start := 4 + 4 + 4 + 1 + 23
+	userEnd := -1
+	if start < len(packet) {
+		userEnd = bytes.IndexByte(packet[start:], 0x00)
+	}
+	if userEnd < 0 {
+		return append([]byte{}, packet...)
+	}
+	start += userEnd + 1
+
+	// Length-prefixed AuthResponse is replaced by zero length, null-terminated one keeps terminator only
+	r := bytes.NewReader(packet[start:])
+	empty := []byte{0x00}
+	var length uint64
+	switch {
+	case capabilities&clientPluginAuthLenEncClientData != 0:
+		length, _ = ReadLenEncodedInteger(r)
+	case capabilities&clientSecureConnection != 0:
+		b, _ := r.ReadByte()
+		length = uint64(b)
+	default:
+		empty = nil
+		length = uint64(r.Len())
+		if end := bytes.IndexByte(packet[start:], 0x00); end >= 0 {
+			length = uint64(end)
+		}
+	}
+
+	if length > uint64(r.Len()) {
+		length = uint64(r.Len())
+	}
+	end := len(packet) - r.Len() + int(length)
+
+	plain := append(append(append([]byte{}, packet[:start]...), empty...), packet[end:]...)
+	payloadLen := len(plain) - 4
+	plain[0], plain[1], plain[2] = byte(payloadLen), byte(payloadLen>>8), byte(payloadLen>>16)
+
+	return plain
+}
+
+// setHandshakeV10SSL sets or clears CLIENT_SSL capability flag in HandshakeV10 packet.
+// See DecodeHandshakeV10 for packet structure.
+func setHandshakeV10SSL(packet []byte, enabled bool) error {
+	if err := checkPacketLength(5, packet); err != nil {
+		return err
+	}
+
+	// Lower part of ServerCapabilities follows ServerVersion, ConnectionID, AuthPluginDataPart1 and filler
+	versionEnd := bytes.IndexByte(packet[5:], 0x00)
+	if versionEnd < 0 {
+		return errInvalidPacketLength
+	}
+
+	offset := 5 + versionEnd + 1 + 4 + 8 + 1
+	if err := checkPacketLength(offset+2, packet); err != nil {
+		return err
+	}
+
+	capabilities := binary.LittleEndian.Uint16(packet[offset:])
+	if enabled {
+		capabilities |= uint16(clientSSL)
+	} else {
+		capabilities &^= uint16(clientSSL)
+	}
+	binary.LittleEndian.PutUint16(packet[offset:], capabilities)
+
+	return nil
 }
 
 // ReadPrepareResponse reads response from MySQL server for COM_STMT_PREPARE
@@ -111,45 +354,20 @@ func ReadErrMessage(errPacket []byte) string {
 }
 
 func ReadShowFieldsResponse(conn net.Conn) ([]byte, byte, error) {
-	return ReadResponse(conn, true)
+	return readResponse(conn, ComFieldList, false)
 }
 
-// ReadResponse ...
+// ReadResponse reads complete response to COM_QUERY from conn.
+// Returns raw packets of response and response kind.
 func ReadResponse(conn net.Conn, deprecateEof bool) ([]byte, byte, error) {
-	pkt, err := ReadPacket(conn)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	switch pkt[4] {
-	case ResponseOk:
-		return pkt, ResponseOk, nil
-
-	case ResponseErr:
-		return pkt, ResponseErr, nil
-
-	case responseLocalinfile:
-	}
+	return readResponse(conn, ComQuery, deprecateEof)
+}
 
+// readResponse reads packets from conn until ResponseDecoder reports response to command is complete.
+func readResponse(conn net.Conn, command byte, deprecateEof bool) ([]byte, byte, error) {
 	var data []byte
 
-	data = append(data, pkt...)
-
-	if !deprecateEof {
-		pktReader := bytes.NewReader(pkt[4:])
-		columns, _ := ReadLenEncodedInteger(pktReader)
-
-		toRead := int(columns) + 1
-
-		for i := 0; i < toRead; i++ {
-			pkt, err := ReadPacket(conn)
-			if err != nil {
-				return nil, 0, err
-			}
-
-			data = append(data, pkt...)
-		}
-	}
+	decoder := NewResponseDecoder(command, deprecateEof)
 
 	for {
 		pkt, err := ReadPacket(conn)
@@ -159,15 +377,19 @@ func ReadResponse(conn net.Conn, deprecateEof bool) ([]byte, byte, error) {
 
 		data = append(data, pkt...)
 
-		if pkt[4] == responseEof {
-			break
+		done, err := decoder.Decode(pkt)
+		if err != nil {
+			return nil, 0, err
 		}
-	}
 
-	return data, responseResultset, nil
+		if done {
+			return data, decoder.Result, nil
+		}
+	}
 }
 
-// ReadPacket ...
+// ReadPacket reads single MySQL packet from conn.
+// Returned slice holds 4 bytes header(length and sequence id) followed by packet body.
 func ReadPacket(conn net.Conn) ([]byte, error) {
 
 	// Read packet header
@@ -189,7 +411,7 @@ func ReadPacket(conn net.Conn) ([]byte, error) {
 	return append(header, body[0:n]...), nil
 }
 
-// WritePacket ...
+// WritePacket writes packet previously read by ReadPacket to conn as is.
 func WritePacket(pkt []byte, conn net.Conn) (int, error) {
 	n, err := conn.Write(pkt)
 	if err != nil {
@@ -213,3 +435,44 @@ func ProxyPacket(src, dst net.Conn) ([]byte, error) {
 
 	return pkt, nil
 }
+
+// maxFramePayload is max payload length of single frame, longer payloads continue in next frames
+const maxFramePayload = 0xffffff
+
+// Packet represents logical MySQL packet which payload may be split into several frames.
+type Packet struct {
+	Data   []byte // Header of the first frame followed by whole payload
+	Frames int    // Number of frames the packet was sent in
+}
+
+// PacketAssembler joins frames of payloads not shorter than 16MB into logical packets.
+// Frame of max length means payload continues in the next frame, the last frame is shorter and may be empty.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_packets.html
+type PacketAssembler struct {
+	packet *Packet
+}
+
+// Add consumes next frame read with ReadPacket.
+// Returns logical packet once its last frame is consumed, nil otherwise.
+func (a *PacketAssembler) Add(frame []byte) *Packet {
+	if len(frame) < 4 {
+		return nil
+	}
+
+	if a.packet == nil {
+		a.packet = &Packet{Data: frame}
+	} else {
+		a.packet.Data = append(a.packet.Data, frame[4:]...)
+	}
+	a.packet.Frames++
+
+	// Payload continues in the next frame
+	if len(frame)-4 == maxFramePayload {
+		return nil
+	}
+
+	packet := a.packet
+	a.packet = nil
+
+	return packet
+}
@@ -0,0 +1,412 @@
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// makeHandshakeV10 builds initial handshake packet of server with given capabilities
+func makeHandshakeV10(capabilities uint32) []byte {
+	payload := []byte{0x0a}
+	payload = append(payload, "8.0.0\x00"...)
+	payload = append(payload, 0x07, 0x00, 0x00, 0x00)
+	payload = append(payload, make([]byte, 8+1)...)
+	payload = append(payload, byte(capabilities), byte(capabilities>>8), 0x21, 0x02, 0x00, byte(capabilities>>16), byte(capabilities>>24), 21)
+	payload = append(payload, make([]byte, 10+13)...)
+	payload = append(payload, "mysql_native_password\x00"...)
+
+	return makePacket(0, payload...)
+}
+
+// makeHandshakeResponse41 builds handshake response packet with given capabilities and database,
+// it's cut to SSLRequest if sslRequest is set
+func makeHandshakeResponse41(seq byte, capabilities uint32, database string, sslRequest bool) []byte {
+	payload := []byte{byte(capabilities), byte(capabilities >> 8), byte(capabilities >> 16), byte(capabilities >> 24)}
+	payload = append(payload, 0x00, 0x00, 0x00, 0x01, 0x21)
+	payload = append(payload, make([]byte, 23)...)
+
+	if !sslRequest {
+		payload = append(payload, "root\x00"...)
+		payload = append(payload, 0x00)
+		payload = append(payload, database+"\x00"...)
+	}
+
+	return makePacket(seq, payload...)
+}
+
+// makeTestCertificate generates self-signed certificate for TLS tests
+func makeTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSetHandshakeV10SSL(t *testing.T) {
+	capabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth | clientSSL
+	packet := makeHandshakeV10(capabilities)
+
+	assert.Nil(t, setHandshakeV10SSL(packet, false))
+	decoded, err := DecodeHandshakeV10(packet)
+	assert.Nil(t, err)
+	assert.Equal(t, capabilities&^clientSSL, decoded.ServerCapabilities)
+	assert.Equal(t, "mysql_native_password", decoded.AuthPlugin)
+
+	assert.Nil(t, setHandshakeV10SSL(packet, true))
+	decoded, err = DecodeHandshakeV10(packet)
+	assert.Nil(t, err)
+	assert.Equal(t, capabilities, decoded.ServerCapabilities)
+
+	assert.Equal(t, errInvalidPacketLength, setHandshakeV10SSL(makePacket(0, 0x0a, '8', 0x00, 0x01), true))
+}
+
+func TestProcessHandshake(t *testing.T) {
+
+	type ProcessHandshakeAssert struct {
+		Name         string
+		ServerSSL    bool // Server announces SSL support
+		ProxyTLS     bool // Proxy is configured for TLS
+		ClientSSL    bool // Client requests TLS if it's announced
+		AnnouncedSSL bool // SSL support seen by client
+		TLS          bool
+	}
+
+	testData := []*ProcessHandshakeAssert{
+		{"Plain connection", false, false, false, false, false},
+		{"Server SSL is hidden if proxy has no certificate", true, false, true, false, false},
+		{"Proxy certificate is useless if server has no SSL", false, true, true, false, false},
+		{"Client doesn't want TLS", true, true, false, true, false},
+		{"TLS on both sides", true, true, true, true, true},
+	}
+
+	cert := makeTestCertificate(t)
+
+	for _, asserted := range testData {
+		clientSide, proxyClient := net.Pipe()
+		proxyServer, serverSide := net.Pipe()
+
+		config := &TLSConfig{Upstream: &tls.Config{InsecureSkipVerify: true}}
+		if asserted.ProxyTLS {
+			config.Listener = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+
+		serverCapabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth | clientConnectWithDB
+		if asserted.ServerSSL {
+			serverCapabilities |= clientSSL
+		}
+
+		// Fake MySQL server
+		go func() {
+			var conn net.Conn = serverSide
+			WritePacket(makeHandshakeV10(serverCapabilities), conn)
+
+			packet, _ := ReadPacket(conn)
+			if decoded, err := DecodeHandshakeResponse41(packet); err == nil && decoded.ClientCapabilities&clientSSL != 0 {
+				serverTLS := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				if serverTLS.Handshake() != nil {
+					return
+				}
+				conn = serverTLS
+				ReadPacket(conn)
+			}
+
+			WritePacket(makeOK(2, 0x00, 0, 0, serverStatusAutocommit), conn)
+		}()
+
+		type result struct {
+			handshake *Handshake
+			err       error
+		}
+		results := make(chan result)
+		go func() {
+			handshake, err := ProcessHandshake(proxyClient, proxyServer, config, time.Now)
+			results <- result{handshake, err}
+		}()
+
+		// Fake MySQL client
+		var conn net.Conn = clientSide
+		packet, err := ReadPacket(conn)
+		assert.Nil(t, err, asserted.Name)
+
+		announced, err := DecodeHandshakeV10(packet)
+		assert.Nil(t, err, asserted.Name)
+		assert.Equal(t, asserted.AnnouncedSSL, announced.ServerCapabilities&clientSSL != 0, asserted.Name)
+
+		clientCapabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth | clientConnectWithDB
+		seq := byte(1)
+
+		if asserted.ClientSSL && announced.ServerCapabilities&clientSSL != 0 {
+			clientCapabilities |= clientSSL
+			WritePacket(makeHandshakeResponse41(seq, clientCapabilities, "", true), conn)
+
+			clientTLS := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+			assert.Nil(t, clientTLS.Handshake(), asserted.Name)
+			conn = clientTLS
+			seq++
+		}
+
+		WritePacket(makeHandshakeResponse41(seq, clientCapabilities, "shop", false), conn)
+
+		_, err = ReadPacket(conn)
+		assert.Nil(t, err, asserted.Name)
+
+		processed := <-results
+		assert.Nil(t, processed.err, asserted.Name)
+		assert.Equal(t, asserted.TLS, processed.handshake.TLS, asserted.Name)
+		assert.Equal(t, "shop", processed.handshake.Client.Database, asserted.Name)
+		assert.Equal(t, serverCapabilities, processed.handshake.Server.ServerCapabilities, asserted.Name)
+
+		clientSide.Close()
+		serverSide.Close()
+	}
+}
+
+func TestPacketAssembler(t *testing.T) {
+	var packets PacketAssembler
+
+	// Short packet is sent in single frame
+	small := makePacket(0, 0x03, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1')
+	packet := packets.Add(small)
+	assert.Equal(t, &Packet{Data: small, Frames: 1}, packet)
+
+	// Payload of max frame length is followed by empty frame
+	first := makePacket(0, make([]byte, maxFramePayload)...)
+	first[4] = ComQuery
+	assert.Nil(t, packets.Add(first))
+
+	packet = packets.Add(makePacket(1))
+	assert.Equal(t, 2, packet.Frames)
+	assert.Equal(t, 4+maxFramePayload, len(packet.Data))
+	assert.Equal(t, byte(0), GetPacketSequence(packet.Data))
+	assert.Equal(t, ComQuery, GetPacketType(packet.Data))
+
+	// Payload spanning three frames
+	assert.Nil(t, packets.Add(first))
+	assert.Nil(t, packets.Add(makePacket(1, make([]byte, maxFramePayload)...)))
+
+	packet = packets.Add(makePacket(2, 'a', 'b'))
+	assert.Equal(t, 3, packet.Frames)
+	assert.Equal(t, 4+2*maxFramePayload+2, len(packet.Data))
+	assert.Equal(t, []byte("ab"), packet.Data[len(packet.Data)-2:])
+
+	// Assembler is ready for next packet
+	assert.Equal(t, &Packet{Data: small, Frames: 1}, packets.Add(small))
+}
+
+func TestProcessHandshakeAuth(t *testing.T) {
+	clientSide, proxyClient := net.Pipe()
+	proxyServer, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	capabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth
+
+	// Fake MySQL server asking for full auth and rejecting password
+	go func() {
+		WritePacket(makeHandshakeV10(capabilities), serverSide)
+		ReadPacket(serverSide)
+		WritePacket(makePacket(2, authMoreData, cachingSha2PerformFullAuth), serverSide)
+		ReadPacket(serverSide)
+		WritePacket(makePacket(4, 0xff, 0x15, 0x04, '#', '2', '8', '0', '0', '0', 'D', 'e', 'n', 'i', 'e', 'd'), serverSide)
+	}()
+
+	type result struct {
+		handshake *Handshake
+		err       error
+	}
+	results := make(chan result)
+	go func() {
+		handshake, err := ProcessHandshake(proxyClient, proxyServer, nil, time.Now)
+		results <- result{handshake, err}
+	}()
+
+	// Fake MySQL client
+	ReadPacket(clientSide)
+	WritePacket(makeHandshakeResponse41(1, capabilities, "", false), clientSide)
+
+	packet, err := ReadPacket(clientSide)
+	assert.Nil(t, err)
+	assert.Equal(t, makePacket(2, authMoreData, cachingSha2PerformFullAuth), packet)
+
+	WritePacket(makePacket(3, 's', 'e', 'c', 'r', 'e', 't', 0x00), clientSide)
+
+	packet, err = ReadPacket(clientSide)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(ResponseErr), packet[4])
+
+	processed := <-results
+	assert.Nil(t, processed.err)
+	assert.Equal(t, &Auth{
+		User:       "root",
+		Plugin:     AuthNativePassword,
+		Result:     ResponseErr,
+		Error:      "#28000Denied",
+		RoundTrips: 2,
+	}, processed.handshake.Auth)
+
+	// All relayed packets are kept in order
+	var fromServer []bool
+	for _, packet := range processed.handshake.Packets {
+		fromServer = append(fromServer, packet.FromServer)
+	}
+	assert.Equal(t, []bool{true, false, true, false, true}, fromServer)
+	assert.Equal(t, makePacket(3, 's', 'e', 'c', 'r', 'e', 't', 0x00), processed.handshake.Packets[3].Data)
+}
+
+func TestProcessHandshakeServerError(t *testing.T) {
+	clientSide, proxyClient := net.Pipe()
+	proxyServer, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	// Server refuses connection with ERR instead of handshake
+	refused := makePacket(0, 0xff, 0x10, 0x04, 'T', 'o', 'o', ' ', 'm', 'a', 'n', 'y', ' ', 'c', 'o', 'n', 'n', 'e', 'c', 't', 'i', 'o', 'n', 's')
+	go WritePacket(refused, serverSide)
+
+	type result struct {
+		handshake *Handshake
+		err       error
+	}
+	results := make(chan result)
+	go func() {
+		handshake, err := ProcessHandshake(proxyClient, proxyServer, nil, time.Now)
+		results <- result{handshake, err}
+	}()
+
+	// Client gets ERR of server unchanged
+	packet, err := ReadPacket(clientSide)
+	assert.Nil(t, err)
+	assert.Equal(t, refused, packet)
+
+	processed := <-results
+	assert.Equal(t, errInvalidPacketType, processed.err)
+	assert.Equal(t, proxyClient, processed.handshake.ClientConn)
+	assert.Equal(t, proxyServer, processed.handshake.ServerConn)
+	assert.Equal(t, []HandshakePacket{{processed.handshake.Packets[0].Time, true, refused}}, processed.handshake.Packets)
+}
+
+func TestProcessHandshakeTLSError(t *testing.T) {
+	clientSide, proxyClient := net.Pipe()
+	proxyServer, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	cert := makeTestCertificate(t)
+	config := &TLSConfig{
+		Listener: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Upstream: &tls.Config{InsecureSkipVerify: true},
+	}
+	capabilities := clientProtocol41 | clientSecureConnection | clientPluginAuth | clientSSL
+
+	// Fake MySQL server accepting TLS
+	go func() {
+		WritePacket(makeHandshakeV10(capabilities), serverSide)
+		ReadPacket(serverSide)
+		tls.Server(serverSide, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+
+	type result struct {
+		handshake *Handshake
+		err       error
+	}
+	results := make(chan result)
+	go func() {
+		handshake, err := ProcessHandshake(proxyClient, proxyServer, config, time.Now)
+		results <- result{handshake, err}
+	}()
+
+	// Fake MySQL client requests TLS but sends plain packet instead of TLS handshake
+	ReadPacket(clientSide)
+	WritePacket(makeHandshakeResponse41(1, capabilities, "", true), clientSide)
+	go WritePacket(makeHandshakeResponse41(2, capabilities, "", false), clientSide)
+	go ReadPacket(clientSide)
+
+	processed := <-results
+	assert.NotNil(t, processed.err)
+	assert.True(t, processed.handshake.TLS)
+
+	// Connections switched to TLS are returned, so raw connections aren't relayed over
+	_, isTLS := processed.handshake.ClientConn.(*tls.Conn)
+	assert.True(t, isTLS)
+	_, isTLS = processed.handshake.ServerConn.(*tls.Conn)
+	assert.True(t, isTLS)
+
+	_, err := processed.handshake.ClientConn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+}
+
+func TestPlainHandshakeResponse41(t *testing.T) {
+	capabilities := clientProtocol41 | clientSecureConnection | clientSSL | clientCompress
+	packet := makeHandshakeResponse41(1, capabilities, "", false)
+
+	plain, err := PlainHandshakeResponse41(packet)
+	assert.Nil(t, err)
+
+	response, err := DecodeHandshakeResponse41(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, clientProtocol41|clientSecureConnection, response.ClientCapabilities)
+
+	// Original packet is left intact
+	response, _ = DecodeHandshakeResponse41(packet)
+	assert.Equal(t, capabilities, response.ClientCapabilities)
+
+	_, err = PlainHandshakeResponse41([]byte{0x01, 0x00, 0x00, 0x01, 0x00})
+	assert.Equal(t, errInvalidPacketLength, err)
+}
+
+func TestPlainHandshakeResponse41AuthResponse(t *testing.T) {
+	// Everything but AuthResponse is kept
+	header := []byte{0x00, 0x00, 0x00, 0x01, 0x21}
+	header = append(header, make([]byte, 23)...)
+	rest := "shop\x00mysql_clear_password\x00"
+
+	tests := []struct {
+		Name         string
+		Capabilities uint32
+		AuthResponse string
+	}{
+		{"Length-encoded", clientProtocol41 | clientPluginAuthLenEncClientData | clientConnectWithDB | clientPluginAuth, "\x07secret\x00"},
+		{"Length-prefixed", clientProtocol41 | clientSecureConnection | clientConnectWithDB | clientPluginAuth, "\x07secret\x00"},
+		{"Null-terminated", clientProtocol41 | clientConnectWithDB | clientPluginAuth, "secret\x00"},
+	}
+
+	for _, test := range tests {
+		capabilities := []byte{byte(test.Capabilities), byte(test.Capabilities >> 8), byte(test.Capabilities >> 16), byte(test.Capabilities >> 24)}
+		payload := append(append(capabilities, header...), "root\x00"...)
+		packet := makePacket(1, append(append(payload, test.AuthResponse...), rest...)...)
+
+		plain, err := PlainHandshakeResponse41(packet)
+		assert.Nil(t, err, test.Name)
+		assert.NotContains(t, string(plain), "secret", test.Name)
+		assert.Equal(t, len(plain)-4, int(plain[0]), test.Name)
+
+		response, err := DecodeHandshakeResponse41(plain)
+		assert.Nil(t, err, test.Name)
+		assert.Equal(t, "root", response.User, test.Name)
+		assert.Equal(t, "shop", response.Database, test.Name)
+		assert.Equal(t, "mysql_clear_password", response.AuthPlugin, test.Name)
+	}
+
+	// Truncated AuthResponse is cut up to packet end
+	packet := makePacket(1, append(append([]byte{0x00, 0x82, 0x00, 0x00}, header...), "root\x00\x20secret"...)...)
+	plain, err := PlainHandshakeResponse41(packet)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(plain), "secret")
+}
+
+func TestCapabilityNames(t *testing.T) {
+	assert.Equal(t, []string{"CLIENT_PROTOCOL_41", "CLIENT_QUERY_ATTRIBUTES"}, CapabilityNames(clientProtocol41|clientQueryAttributes))
+	assert.Len(t, capabilityNames, 28)
+}
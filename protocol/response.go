@@ -0,0 +1,396 @@
+package protocol
+
+import (
+	"bytes"
+)
+
+// Response decoding states
+const (
+	stateFirstPacket = iota
+	stateColumns
+	stateColumnsEOF
+	stateRows
+	stateLocalInfile
+	stateFieldList
+	statePrepareParams
+	statePrepareParamsEOF
+	statePrepareColumns
+	statePrepareColumnsEOF
+	stateAuth
+)
+
+// maxColumns is max number of columns of MySQL table
+const maxColumns = 4096
+
+// Response represents summary of complete server response to single command.
+type Response struct {
+	Result        byte                // ResponseOk, ResponseErr, ResponseResultset or ResponseLocalinfile
+	Error         string              // Error message if Result is ResponseErr
+	Columns       []*ColumnDefinition // Columns of first result set
+	Rows          uint64              // Rows sent in all result sets
+	AffectedRows  uint64
+	LastInsertID  uint64
+	Warnings      uint16
+	StatusFlags   uint16
+	PrepareOk     *ComStmtPrepareOkResponse // Set only for COM_STMT_PREPARE
+	Sample        [][]string                // First rows of the first result set
+	SampleCut     bool                      // True if not all rows of the first result set made it into Sample
+	Schema        string                    // Current database reported with session state tracking
+	SchemaChanged bool                      // True if server reported current database change
+}
+
+// CursorOpened returns true if COM_STMT_EXECUTE opened a cursor, so rows are to be fetched with COM_STMT_FETCH.
+func (r *Response) CursorOpened() bool {
+	return r.StatusFlags&serverStatusCursorExists != 0
+}
+
+// ResponseDecoder incrementally decodes server response to single command
+// packet by packet, so response may be inspected while it's being relayed.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query_response.html
+type ResponseDecoder struct {
+	Response
+	SampleRows   int  // Max number of rows kept in Sample, 0 disables sampling
+	SampleBytes  int  // Max total size of values kept in Sample
+	SessionTrack bool // Must be set if both client and server agreed on CLIENT_SESSION_TRACK
+	command      byte
+	deprecateEOF bool
+	state        int
+	columnsLeft  uint64
+	paramsLeft   uint64
+	resultsets   int // Number of result sets started so far
+	sampleSize   int // Total size of values kept in Sample
+}
+
+// NewResponseDecoder creates decoder for response to command.
+// deprecateEOF must be set if both client and server agreed on CLIENT_DEPRECATE_EOF.
+func NewResponseDecoder(command byte, deprecateEOF bool) *ResponseDecoder {
+	d := &ResponseDecoder{command: command, deprecateEOF: deprecateEOF, state: stateFirstPacket}
+
+	switch command {
+	case ComStmtFetch:
+		// Rows of opened cursor are sent right away without result set metadata
+		d.Result = ResponseResultset
+		d.state = stateRows
+	case ComFieldList:
+		d.state = stateFieldList
+	}
+
+	return d
+}
+
+// ExpectsResponse returns true if server answers command with at least one packet.
+func ExpectsResponse(command byte) bool {
+	switch command {
+	case ComQuit, ComStmtClose, ComStmtSendLongData:
+		return false
+	}
+
+	return true
+}
+
+// Decode consumes next packet of response.
+// Returns true once the last packet of response is consumed.
+func (d *ResponseDecoder) Decode(packet []byte) (bool, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return false, err
+	}
+
+	// Error may be sent in place of any packet, even in the middle of result set.
+	// Neither column definitions nor rows may start with 0xFF.
+	if packet[4] == ResponseErr {
+		d.Result = ResponseErr
+		d.Error, _ = DecodeErrResponse(packet)
+		return true, nil
+	}
+
+	switch d.state {
+	case stateFirstPacket:
+		return d.decodeFirstPacket(packet)
+
+	case stateColumns:
+		column, err := DecodeColumnDefinition41(packet)
+		if err != nil {
+			return false, err
+		}
+
+		// Only columns of the first result set are collected
+		if len(d.Columns) < cap(d.Columns) {
+			d.Columns = append(d.Columns, column)
+		}
+
+		if d.columnsLeft--; d.columnsLeft == 0 {
+			d.state = stateRows
+			if !d.deprecateEOF {
+				d.state = stateColumnsEOF
+			}
+		}
+
+	case stateColumnsEOF:
+		eof, err := DecodeEOFResponse(packet)
+		if err != nil {
+			return false, err
+		}
+
+		// COM_STMT_EXECUTE opened a cursor, rows will be requested with COM_STMT_FETCH
+		if eof.StatusFlags&serverStatusCursorExists != 0 {
+			d.StatusFlags = eof.StatusFlags
+			return true, nil
+		}
+
+		d.state = stateRows
+
+	case stateRows:
+		if !isEOFPacket(packet) {
+			d.Rows++
+			d.sampleRow(packet)
+			return false, nil
+		}
+
+		return d.decodeEnd(packet)
+
+	case stateLocalInfile:
+		return d.decodeEnd(packet)
+
+	case stateFieldList:
+		if isEOFPacket(packet) {
+			return d.decodeEnd(packet)
+		}
+
+		column, err := DecodeColumnDefinition41(packet)
+		if err != nil {
+			return false, err
+		}
+		d.Result = ResponseResultset
+		d.Columns = append(d.Columns, column)
+
+	case statePrepareParams:
+		if d.paramsLeft--; d.paramsLeft == 0 {
+			d.state = statePrepareParamsEOF
+			if d.deprecateEOF {
+				return d.prepareColumnsNext()
+			}
+		}
+
+	case statePrepareParamsEOF:
+		return d.prepareColumnsNext()
+
+	case statePrepareColumns:
+		column, err := DecodeColumnDefinition41(packet)
+		if err != nil {
+			return false, err
+		}
+		d.Columns = append(d.Columns, column)
+
+		if d.columnsLeft--; d.columnsLeft == 0 {
+			if d.deprecateEOF {
+				return true, nil
+			}
+			d.state = statePrepareColumnsEOF
+		}
+
+	case statePrepareColumnsEOF:
+		return true, nil
+
+	case stateAuth:
+		// Authentication is over once OK is received, anything else is part of auth exchange
+		if packet[4] == ResponseOk {
+			return d.decodeEnd(packet)
+		}
+	}
+
+	return false, nil
+}
+
+// decodeFirstPacket decodes packet which starts response or next result of multi-result response.
+func (d *ResponseDecoder) decodeFirstPacket(packet []byte) (bool, error) {
+	switch d.command {
+	case comStatistics:
+		// Statistics are sent as plain string without any header
+		d.Result = ResponseOk
+		return true, nil
+
+	case ComStmtPrepare:
+		if packet[4] != responsePrepareOk {
+			return false, errInvalidPacketType
+		}
+
+		prepareOk, err := DecodeComStmtPrepareOkResponse(packet)
+		if err != nil {
+			return false, err
+		}
+
+		d.Result = ResponseOk
+		d.PrepareOk = prepareOk
+		d.paramsLeft = uint64(prepareOk.ParametersNum)
+		d.columnsLeft = uint64(prepareOk.ColumnsNum)
+		d.Columns = make([]*ColumnDefinition, 0, columnsCap(d.columnsLeft))
+
+		if d.paramsLeft > 0 {
+			d.state = statePrepareParams
+			return false, nil
+		}
+
+		return d.prepareColumnsNext()
+	}
+
+	switch packet[4] {
+	case ResponseOk:
+		return d.decodeEnd(packet)
+
+	case ResponseLocalinfile:
+		// Client sends file contents and server replies with OK or ERR afterwards
+		d.Result = ResponseLocalinfile
+		d.state = stateLocalInfile
+		return false, nil
+
+	case responseEof:
+		// COM_CHANGE_USER may be answered with AuthSwitchRequest
+		if d.command == ComChangeUser {
+			d.state = stateAuth
+			return false, nil
+		}
+
+		// COM_SET_OPTION and COM_DEBUG are answered with EOF
+		return d.decodeEnd(packet)
+	}
+
+	if d.command == ComChangeUser {
+		// AuthMoreData or any other auth exchange packet
+		d.state = stateAuth
+		return false, nil
+	}
+
+	// Anything else starts result set with column count
+	columns, _ := ReadLenEncodedInteger(bytes.NewReader(packet[4:]))
+	if columns == 0 {
+		return false, errInvalidPacketType
+	}
+
+	if d.Result != ResponseResultset {
+		d.Columns = make([]*ColumnDefinition, 0, columnsCap(columns))
+	}
+
+	d.Result = ResponseResultset
+	d.columnsLeft = columns
+	d.state = stateColumns
+	d.resultsets++
+
+	return false, nil
+}
+
+// columnsCap returns capacity of columns slice, column count comes from packet and is never trusted beyond MySQL limit
+func columnsCap(columns uint64) uint64 {
+	if columns > maxColumns {
+		return maxColumns
+	}
+
+	return columns
+}
+
+// decodeEnd decodes packet terminating response or single result of multi-result response.
+// It may be OK packet, EOF packet or OK packet with EOF header if CLIENT_DEPRECATE_EOF is set.
+func (d *ResponseDecoder) decodeEnd(packet []byte) (bool, error) {
+	if packet[4] == ResponseOk || d.deprecateEOF && packet[4] == responseEof && d.state != stateFieldList {
+		ok, err := DecodeOkResponse(packet)
+		if err != nil {
+			return false, err
+		}
+
+		d.AffectedRows += ok.AffectedRows
+		if ok.LastInsertID != 0 {
+			d.LastInsertID = ok.LastInsertID
+		}
+		d.Warnings += ok.Warnings
+		d.StatusFlags = ok.StatusFlags
+
+		if d.SessionTrack {
+			state, err := DecodeOkSessionState(packet)
+			if err != nil {
+				return false, err
+			}
+
+			if state.SchemaChanged {
+				d.Schema = state.Schema
+				d.SchemaChanged = true
+			}
+		}
+	} else {
+		eof, err := DecodeEOFResponse(packet)
+		if err != nil {
+			return false, err
+		}
+
+		d.Warnings += eof.Warnings
+		d.StatusFlags = eof.StatusFlags
+	}
+
+	// Multi-statement query or stored procedure call sends one more result
+	if d.StatusFlags&serverMoreResultsExists != 0 && d.state != stateFieldList {
+		d.state = stateFirstPacket
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// prepareColumnsNext switches to reading column definitions of prepared statement
+// or completes response if statement has no result set.
+func (d *ResponseDecoder) prepareColumnsNext() (bool, error) {
+	if d.columnsLeft == 0 {
+		return true, nil
+	}
+
+	d.state = statePrepareColumns
+	return false, nil
+}
+
+// sampleRow decodes row and keeps it in Sample while limits allow.
+// Rows of result sets other than the first one are never sampled.
+func (d *ResponseDecoder) sampleRow(packet []byte) {
+	if d.SampleRows == 0 || d.resultsets > 1 || d.SampleCut {
+		return
+	}
+
+	if len(d.Sample) >= d.SampleRows {
+		d.SampleCut = true
+		return
+	}
+
+	// Cursor rows fetched with COM_STMT_FETCH come without column definitions
+	if len(d.Columns) == 0 {
+		return
+	}
+
+	var row []string
+	var err error
+
+	if d.command == ComStmtExecute || d.command == ComStmtFetch {
+		row, err = DecodeBinaryRow(packet, d.Columns)
+	} else {
+		row, err = DecodeTextRow(packet, len(d.Columns))
+	}
+
+	if err != nil {
+		d.SampleCut = true
+		return
+	}
+
+	size := 0
+	for _, value := range row {
+		size += len(value)
+	}
+
+	if d.sampleSize+size > d.SampleBytes {
+		d.SampleCut = true
+		return
+	}
+
+	d.sampleSize += size
+	d.Sample = append(d.Sample, row)
+}
+
+// isEOFPacket checks if packet is EOF packet or OK packet with EOF header.
+// Rows may also start with 0xFE but such row is at least 0xFFFFFF bytes long.
+func isEOFPacket(packet []byte) bool {
+	return packet[4] == responseEof && len(packet)-4 < 0xffffff
+}
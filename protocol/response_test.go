@@ -0,0 +1,344 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// makePacket prepends MySQL packet header to payload
+func makePacket(seq byte, payload ...byte) []byte {
+	l := len(payload)
+	return append([]byte{byte(l), byte(l >> 8), byte(l >> 16), seq}, payload...)
+}
+
+// makeColumnDefinition builds ColumnDefinition41 packet for column without schema and table
+func makeColumnDefinition(seq byte, name string, fieldType byte) []byte {
+	payload := []byte{0x03, 'd', 'e', 'f', 0x00, 0x00, 0x00, byte(len(name))}
+	payload = append(payload, name...)
+	payload = append(payload, 0x00, 0x0c, 0x21, 0x00, 0x0b, 0x00, 0x00, 0x00, fieldType, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	return makePacket(seq, payload...)
+}
+
+// makeEOF builds EOF packet with given status flags
+func makeEOF(seq byte, status uint16) []byte {
+	return makePacket(seq, 0xfe, 0x00, 0x00, byte(status), byte(status>>8))
+}
+
+// makeOK builds OK packet with given packet type, affected rows, last insert id and status flags
+func makeOK(seq byte, header byte, affected, lastInsertID byte, status uint16) []byte {
+	return makePacket(seq, header, affected, lastInsertID, byte(status), byte(status>>8), 0x00, 0x00)
+}
+
+func TestResponseDecoder(t *testing.T) {
+
+	type ResponseDecoderAssert struct {
+		Name         string
+		Command      byte
+		DeprecateEOF bool
+		Packets      [][]byte
+		Result       byte
+		Error        string
+		Columns      []string
+		Rows         uint64
+		AffectedRows uint64
+		LastInsertID uint64
+	}
+
+	testData := []*ResponseDecoderAssert{
+		{
+			"OK response to UPDATE",
+			ComQuery,
+			false,
+			[][]byte{makeOK(1, 0x00, 3, 0, serverStatusAutocommit)},
+			ResponseOk,
+			"",
+			nil,
+			0,
+			3,
+			0,
+		},
+		{
+			"ERR response",
+			ComQuery,
+			false,
+			[][]byte{makePacket(1, 0xff, 0x7a, 0x04, '#', '4', '2', 'S', '0', '2', 'N', 'o', 'p', 'e')},
+			ResponseErr,
+			"#42S02Nope",
+			nil,
+			0,
+			0,
+			0,
+		},
+		{
+			"Text result set terminated by EOF",
+			ComQuery,
+			false,
+			[][]byte{
+				makePacket(1, 0x02),
+				makeColumnDefinition(2, "id", fieldTypeLong),
+				makeColumnDefinition(3, "name", fieldTypeVarString),
+				makeEOF(4, serverStatusAutocommit),
+				makePacket(5, 0x01, '1', 0x03, 'f', 'o', 'o'),
+				makePacket(6, 0x01, '2', 0xfb),
+				makePacket(7, 0x01, '3', 0x03, 'b', 'a', 'z'),
+				makeEOF(8, serverStatusAutocommit),
+			},
+			ResponseResultset,
+			"",
+			[]string{"id", "name"},
+			3,
+			0,
+			0,
+		},
+		{
+			"Text result set with CLIENT_DEPRECATE_EOF",
+			ComQuery,
+			true,
+			[][]byte{
+				makePacket(1, 0x01),
+				makeColumnDefinition(2, "id", fieldTypeLong),
+				makePacket(3, 0x01, '1'),
+				makePacket(4, 0x01, '2'),
+				makeOK(5, 0xfe, 0, 0, serverStatusAutocommit),
+			},
+			ResponseResultset,
+			"",
+			[]string{"id"},
+			2,
+			0,
+			0,
+		},
+		{
+			"Binary result set of COM_STMT_EXECUTE",
+			ComStmtExecute,
+			false,
+			[][]byte{
+				makePacket(1, 0x01),
+				makeColumnDefinition(2, "id", fieldTypeLongLong),
+				makeEOF(3, serverStatusAutocommit),
+				makePacket(4, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00),
+				makeEOF(5, serverStatusAutocommit),
+			},
+			ResponseResultset,
+			"",
+			[]string{"id"},
+			1,
+			0,
+			0,
+		},
+		{
+			"Multiple results of CALL",
+			ComQuery,
+			false,
+			[][]byte{
+				makePacket(1, 0x01),
+				makeColumnDefinition(2, "a", fieldTypeLong),
+				makeEOF(3, serverStatusAutocommit|serverMoreResultsExists),
+				makePacket(4, 0x01, '1'),
+				makeEOF(5, serverStatusAutocommit|serverMoreResultsExists),
+				makePacket(6, 0x01),
+				makeColumnDefinition(7, "b", fieldTypeLong),
+				makeEOF(8, serverStatusAutocommit|serverMoreResultsExists),
+				makePacket(9, 0x01, '2'),
+				makeEOF(10, serverStatusAutocommit|serverMoreResultsExists),
+				makeOK(11, 0x00, 0, 0, serverStatusAutocommit),
+			},
+			ResponseResultset,
+			"",
+			[]string{"a"},
+			2,
+			0,
+			0,
+		},
+		{
+			"Error in the middle of result set",
+			ComQuery,
+			false,
+			[][]byte{
+				makePacket(1, 0x01),
+				makeColumnDefinition(2, "a", fieldTypeLong),
+				makeEOF(3, serverStatusAutocommit),
+				makePacket(4, 0x01, '1'),
+				makePacket(5, 0xff, 0x25, 0x05, '#', '7', '0', '1', '0', '0', 'K', 'i', 'l', 'l', 'e', 'd'),
+			},
+			ResponseErr,
+			"#70100Killed",
+			[]string{"a"},
+			1,
+			0,
+			0,
+		},
+		{
+			"LOAD DATA LOCAL INFILE",
+			ComQuery,
+			false,
+			[][]byte{
+				makePacket(1, 0xfb, 'a', '.', 'c', 's', 'v'),
+				makeOK(4, 0x00, 2, 0, serverStatusAutocommit),
+			},
+			ResponseLocalinfile,
+			"",
+			nil,
+			0,
+			2,
+			0,
+		},
+		{
+			"COM_STMT_PREPARE with parameters and columns",
+			ComStmtPrepare,
+			false,
+			[][]byte{
+				makePacket(1, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00),
+				makeColumnDefinition(2, "?", fieldTypeVarString),
+				makeEOF(3, serverStatusAutocommit),
+				makeColumnDefinition(4, "id", fieldTypeLong),
+				makeEOF(5, serverStatusAutocommit),
+			},
+			ResponseOk,
+			"",
+			[]string{"id"},
+			0,
+			0,
+			0,
+		},
+		{
+			"COM_FIELD_LIST",
+			ComFieldList,
+			false,
+			[][]byte{
+				makeColumnDefinition(1, "id", fieldTypeLong),
+				makeColumnDefinition(2, "name", fieldTypeVarString),
+				makeEOF(3, serverStatusAutocommit),
+			},
+			ResponseResultset,
+			"",
+			[]string{"id", "name"},
+			0,
+			0,
+			0,
+		},
+		{
+			"INSERT with last insert id",
+			ComStmtExecute,
+			true,
+			[][]byte{makeOK(1, 0x00, 1, 42, serverStatusAutocommit)},
+			ResponseOk,
+			"",
+			nil,
+			0,
+			1,
+			42,
+		},
+	}
+
+	for _, asserted := range testData {
+		decoder := NewResponseDecoder(asserted.Command, asserted.DeprecateEOF)
+
+		for index, packet := range asserted.Packets {
+			done, err := decoder.Decode(packet)
+
+			assert.Nil(t, err, asserted.Name)
+			assert.Equal(t, index == len(asserted.Packets)-1, done, "%s: packet #%d", asserted.Name, index)
+		}
+
+		var columns []string
+		for _, column := range decoder.Columns {
+			columns = append(columns, column.Name)
+		}
+
+		assert.Equal(t, asserted.Result, decoder.Result, asserted.Name)
+		assert.Equal(t, asserted.Error, decoder.Error, asserted.Name)
+		assert.Equal(t, asserted.Columns, columns, asserted.Name)
+		assert.Equal(t, asserted.Rows, decoder.Rows, asserted.Name)
+		assert.Equal(t, asserted.AffectedRows, decoder.AffectedRows, asserted.Name)
+		assert.Equal(t, asserted.LastInsertID, decoder.LastInsertID, asserted.Name)
+	}
+}
+
+func TestResponseDecoderSample(t *testing.T) {
+
+	type ResponseDecoderSampleAssert struct {
+		Name        string
+		SampleRows  int
+		SampleBytes int
+		Sample      [][]string
+		SampleCut   bool
+	}
+
+	packets := [][]byte{
+		makePacket(1, 0x02),
+		makeColumnDefinition(2, "id", fieldTypeLong),
+		makeColumnDefinition(3, "name", fieldTypeVarString),
+		makeEOF(4, serverStatusAutocommit),
+		makePacket(5, 0x01, '1', 0x03, 'f', 'o', 'o'),
+		makePacket(6, 0x01, '2', 0xfb),
+		makePacket(7, 0x01, '3', 0x03, 'b', 'a', 'z'),
+		makeEOF(8, serverStatusAutocommit),
+	}
+
+	testData := []*ResponseDecoderSampleAssert{
+		{"Sampling disabled", 0, 1024, nil, false},
+		{"All rows fit", 10, 1024, [][]string{{"1", "foo"}, {"2", "NULL"}, {"3", "baz"}}, false},
+		{"Rows limit", 2, 1024, [][]string{{"1", "foo"}, {"2", "NULL"}}, true},
+		{"Bytes limit", 10, 9, [][]string{{"1", "foo"}, {"2", "NULL"}}, true},
+	}
+
+	for _, asserted := range testData {
+		decoder := NewResponseDecoder(ComQuery, false)
+		decoder.SampleRows = asserted.SampleRows
+		decoder.SampleBytes = asserted.SampleBytes
+
+		for _, packet := range packets {
+			decoder.Decode(packet)
+		}
+
+		assert.Equal(t, asserted.Sample, decoder.Sample, asserted.Name)
+		assert.Equal(t, asserted.SampleCut, decoder.SampleCut, asserted.Name)
+		assert.Equal(t, uint64(3), decoder.Rows, asserted.Name)
+	}
+}
+
+func TestResponseDecoderBogusPackets(t *testing.T) {
+	// Column count of 2^63 doesn't get columns preallocated
+	decoder := NewResponseDecoder(ComQuery, false)
+	assert.NotPanics(t, func() {
+		decoder.Decode(makePacket(1, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80))
+	})
+	assert.True(t, cap(decoder.Columns) <= maxColumns)
+
+	// Truncated row is not sampled
+	decoder = NewResponseDecoder(ComQuery, false)
+	decoder.SampleRows, decoder.SampleBytes = 10, 1024
+	packets := [][]byte{
+		makePacket(1, 0x01),
+		makeColumnDefinition(2, "id", fieldTypeVarString),
+		makeEOF(3, serverStatusAutocommit),
+		makePacket(4, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 'a'),
+	}
+	for _, packet := range packets {
+		assert.NotPanics(t, func() { decoder.Decode(packet) })
+	}
+	assert.Empty(t, decoder.Sample)
+}
+
+func TestResponseDecoderSessionTrack(t *testing.T) {
+	packet := makePacket(1, 0x00, 0x00, 0x00, 0x02, 0x40, 0x00, 0x00, 0x00, 0x07, 0x01, 0x05, 0x04, 's', 'h', 'o', 'p')
+
+	decoder := NewResponseDecoder(ComQuery, false)
+	decoder.SessionTrack = true
+
+	done, err := decoder.Decode(packet)
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.True(t, decoder.SchemaChanged)
+	assert.Equal(t, "shop", decoder.Schema)
+
+	// Session state is not decoded unless CLIENT_SESSION_TRACK is agreed
+	decoder = NewResponseDecoder(ComQuery, false)
+
+	done, err = decoder.Decode(packet)
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.False(t, decoder.SchemaChanged)
+}
@@ -10,61 +10,166 @@ import (
 	"time"
 )
 
+// packetParser inspects logical MySQL packets relayed over connection.
+type packetParser interface {
+	WritePacket(packet *protocol.Packet)
+}
+
+// RequestPacketParser inspects packets sent from client to MySQL server.
+// Write must be called with exactly one MySQL packet at a time.
 type RequestPacketParser struct {
-	connId        string
-	queryId       *int
-	queryChan     chan chat.Cmd
-	connStateChan chan chat.ConnState
-	timer         *time.Time
+	session *connSession
+	events  *eventPublisher
+}
+
+// WritePacket inspects logical packet, command sent in several frames is reported along with their number
+func (pp *RequestPacketParser) WritePacket(packet *protocol.Packet) {
+	pp.write(packet.Data, packet.Frames)
 }
 
 func (pp *RequestPacketParser) Write(p []byte) (n int, err error) {
-	*pp.queryId++
-	*pp.timer = time.Now()
-
-	switch protocol.GetPacketType(p) {
-	case protocol.ComStmtPrepare:
-	case protocol.ComQuery:
-		decoded, err := protocol.DecodeQueryRequest(p)
-		if err == nil {
-			pp.queryChan <- chat.Cmd{pp.connId, *pp.queryId, "", decoded.Query, nil, false}
-		}
-	case protocol.ComQuit:
-		pp.connStateChan <- chat.ConnState{pp.connId, protocol.ConnStateFinished}
-	}
+	pp.write(p, 1)
 
 	return len(p), nil
 }
 
+func (pp *RequestPacketParser) write(p []byte, frames int) {
+	// Only the first packet of a command carries command byte.
+	// Packets with non-zero sequence id belong to handshake or to data stream of previous command.
+	if len(p) < 5 || protocol.GetPacketSequence(p) != 0 {
+		return
+	}
+
+	s := pp.session
+	s.Lock()
+	cmd := s.beginCommand(p)
+	s.Unlock()
+
+	if cmd != nil {
+		if frames > 1 {
+			cmd.Frames = frames
+		}
+		pp.events.cmd(*cmd)
+	}
+}
+
+// ResponsePacketParser inspects packets sent from MySQL server to client.
+// Write must be called with exactly one MySQL packet at a time.
 type ResponsePacketParser struct {
-	connId          string
-	queryId         *int
-	queryResultChan chan chat.CmdResult
-	timer           *time.Time
+	session *connSession
+	events  *eventPublisher
+}
+
+// WritePacket inspects logical packet
+func (pp *ResponsePacketParser) WritePacket(packet *protocol.Packet) {
+	pp.Write(packet.Data)
 }
 
 func (pp *ResponsePacketParser) Write(p []byte) (n int, err error) {
-	duration := fmt.Sprintf("%.3f", time.Since(*pp.timer).Seconds())
+	s := pp.session
+	s.Lock()
 
-	switch protocol.GetPacketType(p) {
-	case protocol.ResponseErr:
-		decoded, _ := protocol.DecodeErrResponse(p)
-		pp.queryResultChan <- chat.CmdResult{pp.connId, *pp.queryId, protocol.ResponseErr, decoded, duration}
-	default:
-		pp.queryResultChan <- chat.CmdResult{pp.connId, *pp.queryId, protocol.ResponseOk, "", duration}
+	// Packets which are not part of command response, e.g. rest of authentication exchange
+	if s.response == nil {
+		s.Unlock()
+		return len(p), nil
+	}
+
+	done, err := s.response.Decode(p)
+	if err != nil {
+		log.Printf("%s: response to command #%d: %s", s.connId, s.cmdId, err.Error())
+		s.response = nil
+	}
+
+	// Result is reported once the last packet of response is received
+	if err != nil || !done {
+		s.Unlock()
+		return len(p), nil
 	}
 
+	response := &s.response.Response
+	s.finishCommand(response)
+	s.recorder.flush(true)
+
+	result := newCmdResult(s.connId, s.cmdId, response)
+	result.Time = s.now()
+	result.Duration = fmt.Sprintf("%.3f", result.Time.Sub(s.timer).Seconds())
+	s.Unlock()
+
+	pp.events.cmdResult(result)
+
 	return len(p), nil
 }
 
+// newCmdResult converts decoded response into CmdResult
+func newCmdResult(connId string, cmdId int, response *protocol.Response) chat.CmdResult {
+	result := chat.CmdResult{
+		ConnId:       connId,
+		CmdId:        cmdId,
+		Result:       response.Result,
+		Error:        response.Error,
+		Rows:         response.Rows,
+		AffectedRows: response.AffectedRows,
+		LastInsertID: response.LastInsertID,
+		Warnings:     response.Warnings,
+		Sample:       response.Sample,
+		SampleCut:    response.SampleCut,
+	}
+
+	for _, column := range response.Columns {
+		result.Columns = append(result.Columns, chat.Column{Name: column.Name, Type: protocol.FieldTypeName(column.FieldType)})
+	}
+
+	return result
+}
+
+// eventPublisher hands captured events over to hub without waiting, so relaying never waits for observers.
+// Events are dropped and counted if hub falls behind.
+type eventPublisher struct {
+	events   chan chat.Event
+	counters *chat.Counters
+	wait     bool // Wait for hub instead of dropping events, there's no live traffic to hold up when capture is analyzed
+}
+
+func (p *eventPublisher) publish(event chat.Event) {
+	if p.wait {
+		p.events <- event
+		return
+	}
+
+	select {
+	case p.events <- event:
+	default:
+		p.counters.CaptureDropped()
+	}
+}
+
+func (p *eventPublisher) cmd(cmd chat.Cmd) {
+	p.publish(chat.Event{Cmd: &cmd})
+}
+
+func (p *eventPublisher) cmdResult(result chat.CmdResult) {
+	p.publish(chat.Event{CmdResult: &result})
+}
+
+func (p *eventPublisher) connState(state chat.ConnState) {
+	p.publish(chat.Event{ConnState: &state})
+}
+
+func (p *eventPublisher) data(data chat.Data) {
+	p.publish(chat.Event{Data: &data})
+}
+
 // MySQLProxyServer implements server for capturing and forwarding MySQL traffic.
 type MySQLProxyServer struct {
-	cmdChan       chan chat.Cmd
-	cmdResultChan chan chat.CmdResult
-	connStateChan chan chat.ConnState
-	appReadyChan  chan bool
-	mysqlHost     string
-	proxyHost     string
+	events       *eventPublisher
+	appReadyChan chan bool
+	mysqlHost    string
+	proxyHost    string
+	sampleRows   int
+	sampleBytes  int
+	tlsConfig    *protocol.TLSConfig // TLS settings, nil if TLS is disabled
+	recordData   bool                // Whether relayed bytes are published for export
 }
 
 // run starts accepting TCP connection and forwarding it to MySQL server.
@@ -91,7 +196,7 @@ func (p *MySQLProxyServer) run() {
 	}
 }
 
-// handleConnection ...
+// handleConnection connects to MySQL server and relays connection of client to it.
 func (p *MySQLProxyServer) handleConnection(client net.Conn) {
 	defer client.Close()
 
@@ -103,16 +208,155 @@ func (p *MySQLProxyServer) handleConnection(client net.Conn) {
 	}
 	defer server.Close()
 
-	connId := fmt.Sprintf("%s => %s", client.RemoteAddr().String(), server.RemoteAddr().String())
+	p.proxyConnection(client, server, time.Now)
+}
+
+// proxyConnection relays MySQL packets between client and MySQL server
+// and reports every inspected packet to parsers. Events are timed with now.
+func (p *MySQLProxyServer) proxyConnection(client, server net.Conn, now func() time.Time) {
+	connId := client.RemoteAddr().String() + chat.ConnIdSeparator + server.RemoteAddr().String()
+
+	defer func() {
+		p.events.connState(chat.ConnState{ConnId: connId, Time: now(), State: protocol.ConnStateFinished})
+	}()
+
+	recorder := newDataRecorder(connId, p.events, now, p.recordData)
+	defer recorder.close()
+
+	// Handshake packets are relayed and decoded before any command may be sent.
+	// Failed decoding is not fatal: packets are forwarded already so relaying just goes on
+	// over connections handshake ended with, i.e. over TLS if it was switched on.
+	settings := &protocol.ConnSettings{}
+	session := newConnSession(connId, settings)
+	session.sampleRows = p.sampleRows
+	session.sampleBytes = p.sampleBytes
+	session.recorder = recorder
+	session.now = now
+	handshake, err := protocol.ProcessHandshake(client, server, p.tlsConfig, now)
+
+	// Rest of traffic goes over TLS if client requested it
+	client, server = handshake.ClientConn, handshake.ServerConn
+	recorder.recordHandshake(handshake.Packets)
+
+	if err != nil {
+		log.Printf("%s: handshake: %s", connId, err.Error())
+	} else {
+		serverHandshake := handshake.Server
+		clientHandshake := handshake.Client
+		auth := handshake.Auth
+
+		settings.ServerCapabilities = serverHandshake.ServerCapabilities
+		settings.ClientCapabilities = clientHandshake.ClientCapabilities
+		settings.ClientCharset = clientHandshake.ClientCharset
+		settings.SelectedDb = clientHandshake.Database
+		settings.User = clientHandshake.User
+		settings.ConnectAttrs = clientHandshake.ConnectAttrs
+
+		p.events.connState(chat.ConnState{
+			ConnId: connId,
+			Time:   now(),
+			State:  protocol.ConnStateStarted,
+			Info: &chat.ConnInfo{
+				ServerVersion: serverHandshake.ServerVersion,
+				ConnectionID:  serverHandshake.ConnectionID,
+				User:          auth.User,
+				Database:      clientHandshake.Database,
+				Service:       settings.Service(),
+				ConnectAttrs:  clientHandshake.ConnectAttrs,
+				AuthPlugin:    auth.Plugin,
+				AuthPath:      auth.Path,
+				AuthPublicKey: auth.PublicKey,
+				AuthError:     auth.Error,
+				AuthTrips:     auth.RoundTrips,
+				Capabilities:  protocol.CapabilityNames(settings.Capabilities()),
+				TLS:           handshake.TLS,
+				Compression:   protocol.CompressionName(settings.Compression()),
+			},
+		})
+	}
+
+	// Everything after handshake is sent in compressed frames if compression was agreed
+	compression := settings.Compression()
+
+	// Relay packets from client to server and requestParser.
+	// Closing server side makes the opposite relay return as well.
+	go func() {
+		relay(client, server, &RequestPacketParser{session, p.events}, recorder.writer(false), compression)
+		server.Close()
+	}()
+
+	// Relay packets from server to client and responseParser
+	relay(server, client, &ResponsePacketParser{session, p.events}, recorder.writer(true), compression)
+}
+
+// relay forwards traffic from src to dst with relayPackets or relayCompressedFrames
+// depending on compression used by connection.
+func relay(src, dst net.Conn, parser packetParser, recorder io.Writer, compression byte) error {
+	if compression == protocol.CompressionNone {
+		return relayPackets(src, dst, parser, recorder)
+	}
+
+	return relayCompressedFrames(src, dst, parser, recorder, compression)
+}
+
+// relayPackets reads MySQL packets from src one by one and forwards them to dst.
+// Each packet is passed to parser before it's forwarded, so parser never sees partial
+// or glued packets and request is always inspected before the response to it arrives.
+// Packets split into several frames are passed to parser once the last frame is read.
+// Every frame is passed to recorder as is before parser sees it.
+// Returns first read or write error, io.EOF on clean close.
+func relayPackets(src, dst net.Conn, parser packetParser, recorder io.Writer) error {
+	var packets protocol.PacketAssembler
+
+	for {
+		pkt, err := protocol.ReadPacket(src)
+		if err != nil {
+			return err
+		}
+
+		recorder.Write(pkt)
+		if packet := packets.Add(pkt); packet != nil {
+			parser.WritePacket(packet)
+		}
+
+		if _, err = protocol.WritePacket(pkt, dst); err != nil {
+			return err
+		}
+	}
+}
 
-	defer func() { p.connStateChan <- chat.ConnState{connId, protocol.ConnStateFinished} }()
+// relayCompressedFrames reads frames of compressed protocol from src one by one and forwards them to dst as is.
+// MySQL packets decompressed from each frame are passed to parser before the frame is forwarded.
+// Frame which can't be decompressed is forwarded without inspection.
+// Decompressed packets are passed to recorder, so recorded traffic reads as uncompressed.
+// Returns first read or write error, io.EOF on clean close.
+func relayCompressedFrames(src, dst net.Conn, parser packetParser, recorder io.Writer, compression byte) error {
+	var packets protocol.PacketBuffer
+	var assembler protocol.PacketAssembler
 
-	var queryId int
-	var timer time.Time
+	for {
+		frame, err := protocol.ReadCompressedFrame(src)
+		if err != nil {
+			return err
+		}
 
-	// Copy bytes from client to server and requestParser
-	go io.Copy(io.MultiWriter(server, &RequestPacketParser{connId, &queryId, p.cmdChan, p.connStateChan, &timer}), client)
+		payload, err := protocol.DecompressFrame(frame, compression)
+		if err != nil {
+			log.Printf("%s => %s: compressed frame: %s", src.RemoteAddr().String(), dst.RemoteAddr().String(), err.Error())
+			packets.Reset()
+			assembler = protocol.PacketAssembler{}
+		} else {
+			packets.Write(payload)
+			for pkt := packets.Next(); pkt != nil; pkt = packets.Next() {
+				recorder.Write(pkt)
+				if packet := assembler.Add(pkt); packet != nil {
+					parser.WritePacket(packet)
+				}
+			}
+		}
 
-	// Copy bytes from server to client and responseParser
-	io.Copy(io.MultiWriter(client, &ResponsePacketParser{connId, &queryId, p.cmdResultChan, &timer}), server)
+		if _, err = protocol.WritePacket(frame, dst); err != nil {
+			return err
+		}
+	}
 }
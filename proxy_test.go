@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelayPacketsFrames(t *testing.T) {
+	events := make(chan chat.Event, 10)
+	parser := &RequestPacketParser{newConnSession("1", &protocol.ConnSettings{}), &eventPublisher{events: events, counters: &chat.Counters{}, wait: true}}
+
+	client, src := net.Pipe()
+	dst, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+	go relayPackets(src, dst, parser, ioutil.Discard)
+
+	// COM_QUERY of 16MB is sent in two frames, the second one is empty
+	query := "SELECT '" + string(bytes.Repeat([]byte{'a'}, 0xffffff-10)) + "'"
+	client.Write(append([]byte{0xff, 0xff, 0xff, 0x00, protocol.ComQuery}, query...))
+	client.Write([]byte{0x00, 0x00, 0x00, 0x01})
+
+	// Short one fits single frame
+	client.Write([]byte{0x09, 0x00, 0x00, 0x00, protocol.ComQuery, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1'})
+
+	cmd := (<-events).Cmd
+	if assert.NotNil(t, cmd) {
+		assert.Equal(t, 2, cmd.Frames)
+		assert.Equal(t, query, cmd.Query)
+	}
+
+	cmd = (<-events).Cmd
+	if assert.NotNil(t, cmd) {
+		assert.Equal(t, 0, cmd.Frames)
+		assert.Equal(t, "SELECT 1", cmd.Query)
+	}
+}
+
+func TestProxyConnectionQuit(t *testing.T) {
+	events := make(chan chat.Event, 100)
+	proxy := &MySQLProxyServer{events: &eventPublisher{events: events, counters: &chat.Counters{}, wait: true}}
+
+	client, proxyClient := net.Pipe()
+	proxyServer, server := net.Pipe()
+	go io.Copy(ioutil.Discard, client)
+	go io.Copy(ioutil.Discard, server)
+
+	done := make(chan struct{})
+	go func() {
+		proxy.proxyConnection(proxyClient, proxyServer, time.Now)
+		close(done)
+	}()
+
+	// Client quits once query is answered and closes connection
+	packets := append(mySQLSession("SELECT 1"), mySQLPacket{false, []byte{0x01, 0x00, 0x00, 0x00, protocol.ComQuit}})
+	for _, packet := range packets {
+		if packet.FromServer {
+			server.Write(packet.Data)
+		} else {
+			client.Write(packet.Data)
+		}
+	}
+	client.Close()
+	<-done
+
+	var states []byte
+	for len(events) > 0 {
+		if event := <-events; event.ConnState != nil {
+			states = append(states, event.ConnState.State)
+		}
+	}
+	assert.Equal(t, []byte{protocol.ConnStateStarted, protocol.ConnStateFinished}, states)
+}
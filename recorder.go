@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/protocol"
+)
+
+// maxDataChunk is max number of bytes published as single Data event
+const maxDataChunk = 64 << 10
+
+// dataRecorder publishes bytes relayed over connection so connection can be exported as capture later.
+// Bytes sent by server are gathered till response is complete, client speaks or chunk grows big,
+// so large result sets don't turn into event per packet. Bytes sent by client are published right away.
+// Nil recorder records nothing.
+type dataRecorder struct {
+	sync.Mutex
+	connId  string
+	events  *eventPublisher
+	now     func() time.Time
+	pending [2]*chat.Data // Bytes not published yet, sent by client and by server
+	sent    [2]uint64     // Number of bytes recorded so far, sent by client and by server
+}
+
+// newDataRecorder returns recorder of connection timing bytes with now, nil if recording is disabled
+func newDataRecorder(connId string, events *eventPublisher, now func() time.Time, enabled bool) *dataRecorder {
+	if !enabled {
+		return nil
+	}
+
+	return &dataRecorder{connId: connId, events: events, now: now}
+}
+
+// recordHandshake records packets relayed during handshake.
+// Capabilities of TLS and compression are cleared since recorded packets are decrypted and decompressed.
+// Credentials sent by client are left out: auth response of handshake response is emptied
+// and the rest of client packets of auth exchange, e.g. password sent in clear text over TLS, are recorded without payload.
+func (r *dataRecorder) recordHandshake(packets []protocol.HandshakePacket) {
+	response := true
+	for _, packet := range packets {
+		data := packet.Data
+
+		// The first packet sent by client is handshake response
+		if !packet.FromServer && response {
+			if plain, err := protocol.PlainHandshakeResponse41(data); err == nil {
+				data = plain
+			}
+			response = false
+		} else if !packet.FromServer && len(data) >= 4 {
+			data = []byte{0x00, 0x00, 0x00, data[3]}
+		}
+
+		r.record(packet.Time, packet.FromServer, data)
+	}
+}
+
+// record appends packet sent by client or server at time t
+func (r *dataRecorder) record(t time.Time, fromServer bool, packet []byte) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// The other side is done for now
+	r.flushLocked(!fromServer)
+
+	side := dataSide(fromServer)
+	chunk := r.pending[side]
+	if chunk == nil {
+		chunk = &chat.Data{ConnId: r.connId, Time: t, FromServer: fromServer, Offset: r.sent[side]}
+		r.pending[side] = chunk
+	}
+	chunk.Bytes = append(chunk.Bytes, packet...)
+	r.sent[side] += uint64(len(packet))
+
+	if !fromServer || len(chunk.Bytes) >= maxDataChunk {
+		r.flushLocked(fromServer)
+	}
+}
+
+// flush publishes bytes sent by one side which are not published yet
+func (r *dataRecorder) flush(fromServer bool) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.flushLocked(fromServer)
+}
+
+// close publishes the rest of bytes followed by end of connection
+func (r *dataRecorder) close() {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.flushLocked(false)
+	r.flushLocked(true)
+	r.events.data(chat.Data{ConnId: r.connId, Time: r.now(), Closed: true})
+}
+
+func (r *dataRecorder) flushLocked(fromServer bool) {
+	if chunk := r.pending[dataSide(fromServer)]; chunk != nil {
+		r.events.data(*chunk)
+		r.pending[dataSide(fromServer)] = nil
+	}
+}
+
+// writer returns writer recording packets sent by one side at the moment they're written
+func (r *dataRecorder) writer(fromServer bool) *dataWriter {
+	return &dataWriter{r, fromServer}
+}
+
+// dataWriter records each packet written to it as sent by one side of connection.
+type dataWriter struct {
+	recorder   *dataRecorder
+	fromServer bool
+}
+
+func (w *dataWriter) Write(p []byte) (n int, err error) {
+	if w.recorder != nil {
+		w.recorder.record(w.recorder.now(), w.fromServer, p)
+	}
+
+	return len(p), nil
+}
+
+// dataSide returns index of side in dataRecorder.pending
+func dataSide(fromServer bool) int {
+	if fromServer {
+		return 1
+	}
+
+	return 0
+}
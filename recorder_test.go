@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// publishedData returns Data events published so far
+func publishedData(events chan chat.Event) []chat.Data {
+	var chunks []chat.Data
+	for {
+		select {
+		case event := <-events:
+			chunks = append(chunks, *event.Data)
+		default:
+			return chunks
+		}
+	}
+}
+
+func TestDataRecorderCredentials(t *testing.T) {
+	events := make(chan chat.Event, 100)
+	r := newDataRecorder("1", &eventPublisher{events: events, counters: &chat.Counters{}}, time.Now, true)
+
+	// Handshake response with auth response, then password sent on server request of caching_sha2_password full auth
+	capabilities := []byte{0x00, 0xa2, 0x08, 0x00}
+	payload := append(capabilities, 0x00, 0x00, 0x00, 0x01, 0x21)
+	payload = append(payload, make([]byte, 23)...)
+	payload = append(payload, "root\x00\x06secretcaching_sha2_password\x00"...)
+	response := append([]byte{byte(len(payload)), 0x00, 0x00, 0x01}, payload...)
+	moreData := []byte{0x02, 0x00, 0x00, 0x02, 0x01, 0x04}
+	password := []byte{0x07, 0x00, 0x00, 0x03, 's', 'e', 'c', 'r', 'e', 't', 0x00}
+
+	r.recordHandshake([]protocol.HandshakePacket{
+		{FromServer: true, Data: []byte{0x01, 0x00, 0x00, 0x00, 0x0a}},
+		{FromServer: false, Data: response},
+		{FromServer: true, Data: moreData},
+		{FromServer: false, Data: password},
+	})
+
+	chunks := publishedData(events)
+	if assert.Len(t, chunks, 4) {
+		assert.NotContains(t, string(chunks[1].Bytes), "secret")
+		assert.Contains(t, string(chunks[1].Bytes), "caching_sha2_password")
+		assert.Equal(t, moreData, chunks[2].Bytes)
+		assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x03}, chunks[3].Bytes)
+	}
+}
+
+func TestDataRecorder(t *testing.T) {
+	events := make(chan chat.Event, 100)
+	r := newDataRecorder("1", &eventPublisher{events: events, counters: &chat.Counters{}}, time.Now, true)
+
+	// Handshake response is recorded as plain connection
+	greeting := []byte{0x01, 0x00, 0x00, 0x00, 0x0a}
+	response := []byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x0a, 0x00, 0x00, 0x00}
+	r.recordHandshake([]protocol.HandshakePacket{{FromServer: true, Data: greeting}, {FromServer: false, Data: response}})
+
+	chunks := publishedData(events)
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, greeting, chunks[0].Bytes)
+	assert.Equal(t, []byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00}, chunks[1].Bytes)
+
+	// Bytes sent by client are published right away, bytes sent by server are gathered
+	r.writer(false).Write([]byte{0x01})
+	r.writer(true).Write([]byte{0x02})
+	r.writer(true).Write([]byte{0x03})
+	chunks = publishedData(events)
+	assert.Len(t, chunks, 1)
+	assert.Equal(t, []byte{0x01}, chunks[0].Bytes)
+
+	r.flush(true)
+	chunks = publishedData(events)
+	assert.Len(t, chunks, 1)
+	assert.Equal(t, []byte{0x02, 0x03}, chunks[0].Bytes)
+	assert.True(t, chunks[0].FromServer)
+
+	// Offsets count bytes of each side separately
+	r.writer(false).Write([]byte{0x08, 0x09})
+	r.writer(true).Write([]byte{0x0a})
+	r.flush(true)
+	chunks = publishedData(events)
+	assert.Equal(t, uint64(len(response)+1), chunks[0].Offset)
+	assert.Equal(t, uint64(len(greeting)+2), chunks[1].Offset)
+
+	// Client speaking flushes server bytes first
+	r.writer(true).Write(make([]byte, maxDataChunk-1))
+	assert.Empty(t, publishedData(events))
+	r.writer(true).Write([]byte{0x04, 0x05})
+	assert.Len(t, publishedData(events), 1)
+
+	r.writer(true).Write([]byte{0x06})
+	r.writer(false).Write([]byte{0x07})
+	r.close()
+	chunks = publishedData(events)
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, []byte{0x06}, chunks[0].Bytes)
+	assert.Equal(t, []byte{0x07}, chunks[1].Bytes)
+	assert.True(t, chunks[2].Closed)
+
+	// Nil recorder records nothing
+	r = newDataRecorder("1", &eventPublisher{events: events, counters: &chat.Counters{}}, time.Now, false)
+	r.writer(false).Write([]byte{0x01})
+	r.close()
+	assert.Empty(t, publishedData(events))
+}
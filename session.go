@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/orderbynull/lottip/chat"
+	"github.com/orderbynull/lottip/fingerprint"
+	"github.com/orderbynull/lottip/protocol"
+)
+
+// longDataDisplayBytes is max size of parameter value sent with COM_STMT_SEND_LONG_DATA kept for display
+const longDataDisplayBytes = 1024
+
+// longData holds chunks of single parameter value sent with COM_STMT_SEND_LONG_DATA.
+// Only the beginning of value is kept, size counts all bytes sent.
+type longData struct {
+	data []byte
+	size int
+}
+
+// value returns parameter value for display, truncated values are suffixed with full size
+func (d *longData) value() string {
+	if d.size > len(d.data) {
+		return fmt.Sprintf("%s... (%d bytes)", d.data, d.size)
+	}
+
+	return string(d.data)
+}
+
+// preparedStatement represents statement prepared by client with COM_STMT_PREPARE.
+type preparedStatement struct {
+	query      string
+	paramsNum  uint16
+	paramTypes []protocol.PreparedParameter // Parameters of last execution, their types are reused if client doesn't resend them
+	cursor     []*protocol.ColumnDefinition // Columns of cursor opened by COM_STMT_EXECUTE, nil if there's no open cursor
+	longData   map[uint16]*longData         // Values sent with COM_STMT_SEND_LONG_DATA for next execution by parameter index
+}
+
+// connSession holds state of single proxied connection shared by request and response parsers.
+// Parsers run in separate goroutines so session must be locked while it's accessed.
+type connSession struct {
+	sync.Mutex
+	connId   string
+	settings *protocol.ConnSettings
+	cmdId    int
+	timer    time.Time
+	response *protocol.ResponseDecoder // Decoder of response to command in flight, nil if no response expected
+
+	statements map[uint32]*preparedStatement // Statements prepared on this connection by StatementID
+	preparing  string                        // SQL of COM_STMT_PREPARE waiting for response
+	executing  *preparedStatement            // Statement of COM_STMT_EXECUTE waiting for response
+
+	selecting string // Database selected by command waiting for response
+	selectsDb bool   // True if command waiting for response selects database
+
+	changingUser *protocol.ComChangeUserRequest // COM_CHANGE_USER waiting for response
+
+	recorder *dataRecorder    // Recorder of relayed bytes, nil if bytes are not recorded
+	now      func() time.Time // Clock events are timed with
+
+	sampleRows  int
+	sampleBytes int
+}
+
+// newConnSession creates session of connection identified by connId
+func newConnSession(connId string, settings *protocol.ConnSettings) *connSession {
+	return &connSession{
+		connId:     connId,
+		settings:   settings,
+		statements: make(map[uint32]*preparedStatement),
+		now:        time.Now,
+	}
+}
+
+// beginCommand registers command sent by client and prepares decoding of response to it.
+// Returns Cmd to be reported or nil if command can't be decoded.
+func (s *connSession) beginCommand(p []byte) *chat.Cmd {
+	command := protocol.GetPacketType(p)
+
+	s.cmdId++
+	s.timer = s.now()
+	s.response = nil
+	s.preparing = ""
+	s.executing = nil
+	s.selecting = ""
+	s.selectsDb = false
+	s.changingUser = nil
+
+	if protocol.ExpectsResponse(command) {
+		s.response = protocol.NewResponseDecoder(command, s.settings.DeprecateEOFSet())
+		s.response.SampleRows = s.sampleRows
+		s.response.SampleBytes = s.sampleBytes
+		s.response.SessionTrack = s.settings.SessionTrackSet()
+	}
+
+	request, err := protocol.DecodeCommandRequest(p, s.settings.Capabilities())
+	if err != nil {
+		log.Printf("%s: command #%d %s: %s", s.connId, s.cmdId, protocol.CommandName(command), err.Error())
+		return nil
+	}
+
+	cmd := &chat.Cmd{
+		ConnId:        s.connId,
+		CmdId:         s.cmdId,
+		Time:          s.timer,
+		Command:       request.Name,
+		Database:      s.settings.SelectedDb,
+		User:          s.settings.User,
+		Service:       s.settings.Service(),
+		Query:         request.Statement,
+		Arguments:     request.Arguments,
+		ExpectsResult: s.response != nil,
+	}
+
+	switch command {
+	case protocol.ComQuery:
+		// Database is changed once server replies with OK
+		if db := getUseDatabaseValue(request.Statement); db != "" {
+			s.selecting, s.selectsDb = db, true
+		}
+
+	case protocol.ComInitDB:
+		s.selecting, s.selectsDb = request.Arguments["Schema"], true
+
+	case protocol.ComChangeUser:
+		s.selecting, s.selectsDb = request.Arguments["Schema"], true
+		s.changingUser, _ = protocol.DecodeComChangeUserRequest(p, s.settings.Capabilities())
+
+	case protocol.ComStmtPrepare:
+		s.preparing = request.Statement
+
+	case protocol.ComStmtExecute:
+		s.executeStatement(p, cmd)
+
+	case protocol.ComStmtSendLongData:
+		s.appendLongData(p)
+
+	case protocol.ComStmtClose, protocol.ComStmtReset, protocol.ComStmtFetch:
+		decoded, err := protocol.DecodeComStmtRequest(p)
+		if err != nil {
+			break
+		}
+
+		stmt, ok := s.statements[decoded.StatementID]
+		if !ok {
+			break
+		}
+
+		// Statement commands are shown with SQL of the statement
+		cmd.Query = stmt.query
+
+		switch command {
+		case protocol.ComStmtClose:
+			delete(s.statements, decoded.StatementID)
+
+		case protocol.ComStmtReset:
+			// Reset closes cursor opened by COM_STMT_EXECUTE and discards long data
+			stmt.cursor = nil
+			stmt.longData = nil
+
+		case protocol.ComStmtFetch:
+			// Fetched rows are sent without columns definitions, so they're taken from cursor
+			s.response.Columns = stmt.cursor
+		}
+	}
+
+	// Queries of the same shape are grouped by digest, statement SQL is unknown if it was prepared before capture
+	switch command {
+	case protocol.ComQuery, protocol.ComStmtPrepare, protocol.ComStmtExecute:
+		if cmd.Query != request.Name {
+			cmd.Fingerprint = fingerprint.Fingerprint(cmd.Query)
+			cmd.Digest = fingerprint.Digest(cmd.Fingerprint)
+		}
+	}
+
+	return cmd
+}
+
+// executeStatement decodes COM_STMT_EXECUTE of previously prepared statement
+// and fills cmd with statement SQL and values of parameters.
+func (s *connSession) executeStatement(p []byte, cmd *chat.Cmd) {
+	// Parameters may be decoded only once their count is known from statement
+	decoded, err := protocol.DecodeComStmtExecuteRequest(p, 0, 0, nil, nil)
+	if err != nil {
+		return
+	}
+
+	statementID := decoded.StatementID
+
+	stmt, ok := s.statements[statementID]
+	if !ok {
+		log.Printf("%s: execute of unknown statement #%d", s.connId, statementID)
+		return
+	}
+
+	s.executing = stmt
+	cmd.Query = stmt.query
+
+	// Server discards long data once statement is executed
+	sentLongData := stmt.longData
+	stmt.longData = nil
+
+	longDataParams := make(map[uint16]bool, len(sentLongData))
+	for index := range sentLongData {
+		longDataParams[index] = true
+	}
+
+	decoded, err = protocol.DecodeComStmtExecuteRequest(p, s.settings.Capabilities(), stmt.paramsNum, stmt.paramTypes, longDataParams)
+	if err != nil {
+		log.Printf("%s: execute of statement #%d: %s", s.connId, statementID, err.Error())
+		return
+	}
+
+	stmt.paramTypes = decoded.PreparedParameters
+
+	cmd.Executable = true
+	for index := range decoded.PreparedParameters {
+		parameter := &decoded.PreparedParameters[index]
+
+		if parameter.LongData {
+			data := sentLongData[uint16(index)]
+			parameter.Value = data.value()
+
+			// Truncated value can't be used to execute query again
+			if data.size > len(data.data) {
+				cmd.Executable = false
+			}
+		}
+
+		// NULL is kept apart from string "NULL"
+		if parameter.Null {
+			cmd.Parameters = append(cmd.Parameters, nil)
+		} else {
+			value := parameter.Value
+			cmd.Parameters = append(cmd.Parameters, &value)
+		}
+	}
+
+	// Statement which parameters can't be written as SQL, e.g. NaN, can't be run again either
+	if cmd.Executable {
+		cmd.RunnableQuery, err = protocol.InterpolateQuery(stmt.query, decoded.PreparedParameters, s.settings.ClientCharset)
+		if err != nil {
+			cmd.Executable = false
+			log.Printf("%s: execute of statement #%d: %s", s.connId, statementID, err.Error())
+		}
+	}
+}
+
+// appendLongData buffers chunk of parameter value sent with COM_STMT_SEND_LONG_DATA
+// until the statement is executed.
+func (s *connSession) appendLongData(p []byte) {
+	decoded, err := protocol.DecodeComStmtSendLongDataRequest(p)
+	if err != nil {
+		return
+	}
+
+	stmt, ok := s.statements[decoded.StatementID]
+	if !ok || decoded.ParamID >= stmt.paramsNum {
+		log.Printf("%s: long data for unknown parameter #%d of statement #%d", s.connId, decoded.ParamID, decoded.StatementID)
+		return
+	}
+
+	if stmt.longData == nil {
+		stmt.longData = make(map[uint16]*longData)
+	}
+
+	data, ok := stmt.longData[decoded.ParamID]
+	if !ok {
+		data = &longData{}
+		stmt.longData[decoded.ParamID] = data
+	}
+
+	if free := longDataDisplayBytes - len(data.data); free > 0 {
+		if free > len(decoded.Data) {
+			free = len(decoded.Data)
+		}
+		data.data = append(data.data, decoded.Data[:free]...)
+	}
+	data.size += len(decoded.Data)
+}
+
+// finishCommand updates session with complete response to command sent last.
+func (s *connSession) finishCommand(response *protocol.Response) {
+	if response.PrepareOk != nil && s.preparing != "" {
+		s.statements[response.PrepareOk.StatementID] = &preparedStatement{
+			query:     s.preparing,
+			paramsNum: response.PrepareOk.ParametersNum,
+		}
+	}
+
+	if s.executing != nil && response.Result == protocol.ResponseResultset {
+		s.executing.cursor = nil
+		if response.CursorOpened() {
+			s.executing.cursor = response.Columns
+		}
+	}
+
+	if s.selectsDb && response.Result == protocol.ResponseOk {
+		s.settings.SelectedDb = s.selecting
+	}
+
+	// Connection attributes are replaced only if client sent new ones
+	if s.changingUser != nil && response.Result == protocol.ResponseOk {
+		s.settings.User = s.changingUser.User
+		if s.changingUser.ConnectAttrs != nil {
+			s.settings.ConnectAttrs = s.changingUser.ConnectAttrs
+		}
+	}
+
+	// Session state tracking reports database changed any way, e.g. inside stored procedure
+	if response.SchemaChanged {
+		s.settings.SelectedDb = response.Schema
+	}
+
+	s.response = nil
+	s.preparing = ""
+	s.executing = nil
+	s.selecting = ""
+	s.selectsDb = false
+	s.changingUser = nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/orderbynull/lottip/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExecuteStatementNull(t *testing.T) {
+	s := newConnSession("1", &protocol.ConnSettings{})
+	s.statements[1] = &preparedStatement{query: "SELECT ?, ?", paramsNum: 2}
+
+	// Statement 1 executed with LONG NULL and VAR_STRING "NULL"
+	payload := []byte{
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x01,
+		0x01,
+		0x03, 0x00, 0xfd, 0x00,
+		0x04, 'N', 'U', 'L', 'L',
+	}
+	packet := append([]byte{byte(len(payload)), 0x00, 0x00, 0x00}, payload...)
+
+	cmd := s.beginCommand(packet)
+	if assert.NotNil(t, cmd) && assert.Len(t, cmd.Parameters, 2) {
+		assert.Nil(t, cmd.Parameters[0])
+		assert.Equal(t, "NULL", *cmd.Parameters[1])
+		assert.Equal(t, "SELECT NULL, 'NULL'", cmd.RunnableQuery)
+	}
+}
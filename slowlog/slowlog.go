@@ -0,0 +1,246 @@
+// Package slowlog writes completed commands in MySQL slow query log format,
+// so tools like pt-query-digest or mysqldumpslow can be run over captured traffic.
+// Log file is rotated once it grows bigger than size limit, rotated files are numbered
+// like log.1, log.2 with log.1 being the most recent one.
+package slowlog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands logged as SQL, other commands are logged as administrator commands like MySQL does
+var sqlCommands = map[string]bool{
+	"COM_QUERY":        true,
+	"COM_STMT_PREPARE": true,
+	"COM_STMT_EXECUTE": true,
+}
+
+// Names of administrator commands as MySQL writes them to slow log
+var adminCommands = map[string]string{
+	"COM_SLEEP":               "Sleep",
+	"COM_QUIT":                "Quit",
+	"COM_INIT_DB":             "Init DB",
+	"COM_FIELD_LIST":          "Field List",
+	"COM_CREATE_DB":           "Create DB",
+	"COM_DROP_DB":             "Drop DB",
+	"COM_REFRESH":             "Refresh",
+	"COM_SHUTDOWN":            "Shutdown",
+	"COM_STATISTICS":          "Statistics",
+	"COM_PROCESS_INFO":        "Processlist",
+	"COM_CONNECT":             "Connect",
+	"COM_PROCESS_KILL":        "Kill",
+	"COM_DEBUG":               "Debug",
+	"COM_PING":                "Ping",
+	"COM_TIME":                "Time",
+	"COM_DELAYED_INSERT":      "Delayed insert",
+	"COM_CHANGE_USER":         "Change user",
+	"COM_BINLOG_DUMP":         "Binlog Dump",
+	"COM_TABLE_DUMP":          "Table Dump",
+	"COM_CONNECT_OUT":         "Connect Out",
+	"COM_REGISTER_SLAVE":      "Register Slave",
+	"COM_STMT_SEND_LONG_DATA": "Long Data",
+	"COM_STMT_CLOSE":          "Close stmt",
+	"COM_STMT_RESET":          "Reset stmt",
+	"COM_SET_OPTION":          "Set option",
+	"COM_STMT_FETCH":          "Fetch",
+	"COM_DAEMON":              "Daemon",
+	"COM_BINLOG_DUMP_GTID":    "Binlog Dump GTID",
+	"COM_RESET_CONNECTION":    "Reset Connection",
+}
+
+// Entry represents single command logged along with its result.
+type Entry struct {
+	Time         time.Time     // Moment command was sent by client
+	Duration     time.Duration // Time it took server to reply
+	User         string
+	Host         string // IP address of client, empty if it's unknown
+	Database     string
+	Command      string // Command name as it's defined in MySQL source code, e.g. COM_QUERY
+	Query        string // SQL of command, ignored for administrator commands
+	RowsSent     uint64
+	RowsAffected uint64
+}
+
+// Options holds settings of log, zero value disables setting.
+type Options struct {
+	MinDuration time.Duration // Commands taking less than MinDuration are not logged
+	MaxSize     int64         // Log file is rotated once it grows bigger than MaxSize bytes
+	MaxFiles    int           // Number of rotated files kept, the oldest ones are removed
+}
+
+// Log appends entries to slow log file. Log is safe for concurrent use.
+type Log struct {
+	sync.Mutex
+	path    string
+	options Options
+	file    *os.File // Nil if log file failed to reopen after rotation
+	size    int64
+	closed  bool
+}
+
+// Open opens slow log file at path, entries are appended to file if it exists
+func Open(path string, options Options) (*Log, error) {
+	l := &Log{path: path, options: options}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Write appends entry to log unless command took less than MinDuration
+func (l *Log) Write(entry Entry) error {
+	if entry.Duration < l.options.MinDuration {
+		return nil
+	}
+
+	data := Format(entry)
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.closed {
+		return os.ErrClosed
+	}
+
+	// Log file which failed to reopen is retried on every write
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	// Entry goes to current file if rotation fails, rotation is retried on the next write
+	var rotateErr error
+	if l.options.MaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.options.MaxSize {
+		if rotateErr = l.rotate(); l.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+
+	return err
+}
+
+// Close closes log file
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+// open opens log file for appending
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file, l.size = file, info.Size()
+
+	return nil
+}
+
+// rotate renames log file to log.1, shifting files rotated earlier, and opens new log file.
+// Log file is reopened at the same path if rotation fails.
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		err = l.shift()
+	}
+
+	if openErr := l.open(); openErr != nil {
+		return openErr
+	}
+
+	return err
+}
+
+// shift renames log file to log.1, shifting files rotated earlier and removing ones beyond MaxFiles
+func (l *Log) shift() error {
+
+	// Number of files rotated so far
+	rotated := 0
+	for {
+		if _, err := os.Stat(l.rotatedPath(rotated + 1)); err != nil {
+			break
+		}
+		rotated++
+	}
+
+	for n := rotated; n >= 1; n-- {
+		var err error
+		if l.options.MaxFiles > 0 && n >= l.options.MaxFiles {
+			err = os.Remove(l.rotatedPath(n))
+		} else {
+			err = os.Rename(l.rotatedPath(n), l.rotatedPath(n+1))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(l.path, l.rotatedPath(1))
+}
+
+// rotatedPath returns path of n-th most recent rotated file
+func (l *Log) rotatedPath(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+// Format returns entry in MySQL slow query log format.
+// Lock time and number of examined rows are not seen by proxy, so Lock_time is always zero and Rows_examined is omitted.
+func Format(entry Entry) []byte {
+	buf := &bytes.Buffer{}
+
+	// MySQL logs time command completed at, and the moment it started as timestamp of session
+	fmt.Fprintf(buf, "# Time: %s\n", entry.Time.Add(entry.Duration).UTC().Format("2006-01-02T15:04:05.000000Z"))
+	fmt.Fprintf(buf, "# User@Host: %s[%s] @  [%s]\n", entry.User, entry.User, entry.Host)
+	fmt.Fprintf(buf, "# Query_time: %.6f  Lock_time: 0.000000 Rows_sent: %d  Rows_affected: %d\n", entry.Duration.Seconds(), entry.RowsSent, entry.RowsAffected)
+
+	if entry.Database != "" {
+		fmt.Fprintf(buf, "use %s;\n", entry.Database)
+	}
+	fmt.Fprintf(buf, "SET timestamp=%d;\n", entry.Time.Unix())
+
+	if sqlCommands[entry.Command] {
+		buf.WriteString(strings.TrimRight(strings.TrimSpace(entry.Query), ";"))
+		buf.WriteString(";\n")
+	} else {
+		name, ok := adminCommands[entry.Command]
+		if !ok {
+			name = entry.Command
+		}
+		fmt.Fprintf(buf, "# administrator command: %s;\n", name)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,151 @@
+package slowlog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	type FormatAssert struct {
+		Name   string
+		Entry  Entry
+		Output string
+	}
+
+	at := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []FormatAssert{
+		{
+			"Query",
+			Entry{
+				Time: at, Duration: 1500 * time.Microsecond, User: "root", Host: "127.0.0.1", Database: "shop",
+				Command: "COM_QUERY", Query: "SELECT * FROM t", RowsSent: 3,
+			},
+			"# Time: 2020-01-01T10:00:00.001500Z\n" +
+				"# User@Host: root[root] @  [127.0.0.1]\n" +
+				"# Query_time: 0.001500  Lock_time: 0.000000 Rows_sent: 3  Rows_affected: 0\n" +
+				"use shop;\n" +
+				"SET timestamp=1577872800;\n" +
+				"SELECT * FROM t;\n",
+		},
+		{
+			"Query ending with semicolon, no database",
+			Entry{Time: at, Duration: 2 * time.Second, User: "app", Command: "COM_STMT_EXECUTE", Query: " DELETE FROM t; ", RowsAffected: 2},
+			"# Time: 2020-01-01T10:00:02.000000Z\n" +
+				"# User@Host: app[app] @  []\n" +
+				"# Query_time: 2.000000  Lock_time: 0.000000 Rows_sent: 0  Rows_affected: 2\n" +
+				"SET timestamp=1577872800;\n" +
+				"DELETE FROM t;\n",
+		},
+		{
+			"Administrator command",
+			Entry{Time: at, User: "root", Host: "::1", Command: "COM_INIT_DB", Query: "USE shop"},
+			"# Time: 2020-01-01T10:00:00.000000Z\n" +
+				"# User@Host: root[root] @  [::1]\n" +
+				"# Query_time: 0.000000  Lock_time: 0.000000 Rows_sent: 0  Rows_affected: 0\n" +
+				"SET timestamp=1577872800;\n" +
+				"# administrator command: Init DB;\n",
+		},
+		{
+			"Unknown command",
+			Entry{Time: at, User: "root", Command: "COM_UNKNOWN(0xf0)"},
+			"# Time: 2020-01-01T10:00:00.000000Z\n" +
+				"# User@Host: root[root] @  []\n" +
+				"# Query_time: 0.000000  Lock_time: 0.000000 Rows_sent: 0  Rows_affected: 0\n" +
+				"SET timestamp=1577872800;\n" +
+				"# administrator command: COM_UNKNOWN(0xf0);\n",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, string(Format(test.Entry)), test.Name)
+	}
+}
+
+// readLogs returns contents of log file and files rotated from it, missing files are returned as empty
+func readLogs(path string, rotated int) []string {
+	var contents []string
+	for n := 0; n <= rotated; n++ {
+		file := path
+		if n > 0 {
+			file = (&Log{path: path}).rotatedPath(n)
+		}
+
+		data, _ := ioutil.ReadFile(file)
+		contents = append(contents, string(data))
+	}
+
+	return contents
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-slowlog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "slow.log")
+	entry := func(query string, duration time.Duration) Entry {
+		return Entry{Time: time.Now(), Duration: duration, User: "root", Command: "COM_QUERY", Query: query}
+	}
+	size := int64(len(Format(entry("SELECT 1", time.Second))))
+
+	// Each file holds two entries
+	l, err := Open(path, Options{MinDuration: time.Second, MaxSize: 2 * size, MaxFiles: 2})
+	assert.Nil(t, err)
+
+	for i := 1; i <= 7; i++ {
+		assert.Nil(t, l.Write(entry("SELECT "+string(rune('0'+i)), time.Second)))
+
+		// Faster commands are not logged
+		assert.Nil(t, l.Write(entry("SELECT 0", time.Second-time.Nanosecond)))
+	}
+	assert.Nil(t, l.Close())
+	assert.Equal(t, os.ErrClosed, l.Write(entry("SELECT 8", time.Second)))
+
+	logs := readLogs(path, 3)
+	assert.Regexp(t, "(?s)^# Time.*SELECT 7;\n$", logs[0])
+	assert.Regexp(t, "(?s)^# Time.*SELECT 5;\n# Time.*SELECT 6;\n$", logs[1])
+	assert.Regexp(t, "(?s)^# Time.*SELECT 3;\n# Time.*SELECT 4;\n$", logs[2])
+	assert.Empty(t, logs[3])
+	assert.NotContains(t, logs[0]+logs[1]+logs[2], "SELECT 0")
+
+	// Existing log is appended to
+	l, err = Open(path, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, l.Write(entry("SELECT 8", 0)))
+	assert.Nil(t, l.Close())
+	assert.Regexp(t, "(?s)^# Time.*SELECT 7;\n# Time.*SELECT 8;\n$", readLogs(path, 0)[0])
+}
+
+func TestLogRotationFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-slowlog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "slow.log")
+	entry := func(query string) Entry {
+		return Entry{Time: time.Now(), Duration: time.Second, User: "root", Command: "COM_QUERY", Query: query}
+	}
+
+	l, err := Open(path, Options{MaxSize: 1, MaxFiles: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, l.Write(entry("SELECT 1")))
+
+	// Rotated file can't be removed, entry goes to current file
+	assert.Nil(t, os.MkdirAll(filepath.Join(path+".1", "busy"), 0755))
+	assert.NotNil(t, l.Write(entry("SELECT 2")))
+	assert.Regexp(t, "(?s)^# Time.*SELECT 1;\n# Time.*SELECT 2;\n$", readLogs(path, 0)[0])
+
+	// Rotation is retried once it's possible
+	assert.Nil(t, os.RemoveAll(path+".1"))
+	assert.Nil(t, l.Write(entry("SELECT 3")))
+	assert.Nil(t, l.Close())
+
+	logs := readLogs(path, 1)
+	assert.Regexp(t, "(?s)^# Time.*SELECT 3;\n$", logs[0])
+	assert.Regexp(t, "(?s)^# Time.*SELECT 1;\n# Time.*SELECT 2;\n$", logs[1])
+}
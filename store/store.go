@@ -0,0 +1,347 @@
+// Package store implements on-disk history of captured events.
+// Events are appended as JSON lines to segment files, oldest segments are removed once
+// they outlive retention age or total size of segments exceeds retention size.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Max size of single segment, store limited by size gets at least 4 segments
+	maxSegmentSize = 64 << 20
+	minSegmentSize = 64 << 10
+
+	// Segment limited by age is closed once it holds records of 1/8 of retention age
+	segmentAgeParts = 8
+
+	// Retention limits are checked at most once per cleanupPeriod
+	cleanupPeriod = time.Minute
+
+	segmentExt = ".log"
+)
+
+// Record represents single event kept in store.
+type Record struct {
+	Time  time.Time
+	Type  string          // Type of event, e.g. cmd or result
+	Event json.RawMessage // Event encoded as JSON
+}
+
+// Options holds retention limits of store, zero value disables limit.
+type Options struct {
+	MaxAge  time.Duration // Records older than MaxAge are removed
+	MaxSize int64         // Oldest records are removed once size of store exceeds MaxSize bytes
+}
+
+// segment represents single file of store.
+type segment struct {
+	path    string
+	seq     uint64
+	size    int64
+	created time.Time // Time of the first record
+	updated time.Time // Time of the last record
+}
+
+// Store appends records to segment files in directory and reads them back.
+// Store is safe for concurrent use.
+type Store struct {
+	sync.Mutex
+	dir         string
+	options     Options
+	segmentSize int64
+	segments    []*segment // Oldest first, the last one is being appended to
+	file        *os.File   // File of the last segment, nil until the first record is appended
+	cleaned     time.Time
+	closed      bool
+}
+
+// Open opens store in dir, the directory is created if it doesn't exist.
+// Records kept in dir by previous runs are retained.
+func Open(dir string, options Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, options: options, segmentSize: maxSegmentSize}
+	if options.MaxSize > 0 && options.MaxSize/4 < s.segmentSize {
+		s.segmentSize = options.MaxSize / 4
+		if s.segmentSize < minSegmentSize {
+			s.segmentSize = minSegmentSize
+		}
+	}
+
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), segmentExt), 10, 64)
+		if err != nil || file.IsDir() || filepath.Ext(file.Name()) != segmentExt {
+			continue
+		}
+
+		// Modification time is close enough to time of the last record, time of the first one is read from segment
+		seg := &segment{path: filepath.Join(dir, file.Name()), seq: seq, size: file.Size(), updated: file.ModTime()}
+		if seg.created, err = firstRecordTime(seg.path); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if err := s.cleanup(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append appends event of given type to store.
+// Event is encoded as JSON unless it's json.RawMessage already.
+func (s *Store) Append(t time.Time, eventType string, event interface{}) error {
+	data, ok := event.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(&Record{Time: t, Type: eventType, Event: data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+
+	if s.file == nil || s.segmentFull(t) {
+		if err := s.rotate(t); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	current := s.segments[len(s.segments)-1]
+	current.size += int64(n)
+	current.updated = t
+	if err != nil {
+		return err
+	}
+
+	if time.Since(s.cleaned) >= cleanupPeriod {
+		return s.cleanup(time.Now())
+	}
+
+	return nil
+}
+
+// Scan calls fn for every record kept in store with time in range [from, to), oldest first.
+// Zero from or to leaves range open. Scan stops once fn returns false.
+// Records appended while scanning may be skipped.
+func (s *Store) Scan(from, to time.Time, fn func(*Record) bool) error {
+	s.Lock()
+	segments := make([]segment, 0, len(s.segments))
+	for _, seg := range s.segments {
+		segments = append(segments, *seg)
+	}
+	s.Unlock()
+
+	for _, seg := range segments {
+		if !from.IsZero() && seg.updated.Before(from) || !to.IsZero() && !seg.created.Before(to) {
+			continue
+		}
+
+		more, err := scanSegment(&seg, from, to, fn)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Size returns total size of records kept in store in bytes
+func (s *Store) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+
+	return size
+}
+
+// Close closes segment being appended to, records can't be appended afterwards
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// scanSegment reads records of single segment up to its size at the moment scan started.
+// Returns false if scan is over.
+// Segment removed by retention meanwhile is skipped.
+func scanSegment(seg *segment, from, to time.Time, fn func(*Record) bool) (bool, error) {
+	file, err := os.Open(seg.path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// Record being appended may be written partially.
+	// Lines are read whole whatever their length, record of huge query takes as much as it took to append it.
+	reader := bufio.NewReader(io.LimitReader(file, seg.size))
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		// Line cut by crash of previous run is skipped
+		record := &Record{}
+		if len(line) > 0 && json.Unmarshal(line, record) == nil && record.inRange(from, to) && !fn(record) {
+			return false, nil
+		}
+
+		if err == io.EOF {
+			return true, nil
+		}
+	}
+}
+
+// inRange returns true if record time is in range [from, to), zero from or to leaves range open.
+// Events of different connections may be appended slightly out of time order, so every record is checked.
+func (r *Record) inRange(from, to time.Time) bool {
+	return (from.IsZero() || !r.Time.Before(from)) && (to.IsZero() || r.Time.Before(to))
+}
+
+// firstRecordTime returns time of the first record of segment file, zero time if segment has no complete record
+func firstRecordTime(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return time.Time{}, err
+	}
+
+	record := &Record{}
+	if json.Unmarshal(line, record) != nil {
+		return time.Time{}, nil
+	}
+
+	return record.Time, nil
+}
+
+// segmentFull returns true if record appended at t has to go to new segment
+func (s *Store) segmentFull(t time.Time) bool {
+	current := s.segments[len(s.segments)-1]
+	if current.size >= s.segmentSize {
+		return true
+	}
+
+	return s.options.MaxAge > 0 && t.Sub(current.created) >= s.options.MaxAge/segmentAgeParts
+}
+
+// rotate closes segment being appended to and starts new one.
+// Segment left by previous run is never appended to, so its modification time keeps time of its last record.
+func (s *Store) rotate(t time.Time) error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.file = file
+	s.segments = append(s.segments, &segment{path: path, seq: seq, created: t, updated: t})
+
+	return nil
+}
+
+// cleanup removes segments which records are all older than retention age
+// and the oldest segments while store exceeds retention size.
+// Segment being appended to is never removed.
+func (s *Store) cleanup(now time.Time) error {
+	s.cleaned = now
+
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+
+	for len(s.segments) > 0 {
+		oldest := s.segments[0]
+		if s.file != nil && len(s.segments) == 1 {
+			break
+		}
+
+		expired := s.options.MaxAge > 0 && now.Sub(oldest.updated) > s.options.MaxAge
+		oversized := s.options.MaxSize > 0 && size > s.options.MaxSize
+		if !expired && !oversized {
+			break
+		}
+
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		size -= oldest.size
+		s.segments = s.segments[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int
+}
+
+// scanIDs returns IDs of events kept in store with time in range [from, to)
+func scanIDs(t *testing.T, s *Store, from, to time.Time) []int {
+	var ids []int
+	err := s.Scan(from, to, func(record *Record) bool {
+		event := testEvent{}
+		assert.Nil(t, json.Unmarshal(record.Event, &event))
+		ids = append(ids, event.ID)
+		return true
+	})
+	assert.Nil(t, err)
+
+	return ids
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-store")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, Options{})
+	assert.Nil(t, err)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := 1; id <= 5; id++ {
+		assert.Nil(t, s.Append(start.Add(time.Duration(id)*time.Second), "test", &testEvent{id}))
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, scanIDs(t, s, time.Time{}, time.Time{}))
+	assert.Equal(t, []int{2, 3}, scanIDs(t, s, start.Add(2*time.Second), start.Add(4*time.Second)))
+
+	// Scan stops once callback returns false
+	calls := 0
+	assert.Nil(t, s.Scan(time.Time{}, time.Time{}, func(record *Record) bool {
+		calls++
+		return false
+	}))
+	assert.Equal(t, 1, calls)
+
+	// Records survive reopening, new ones go to new segment
+	assert.Nil(t, s.Close())
+	s, err = Open(dir, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Append(start.Add(6*time.Second), "test", &testEvent{6}))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, scanIDs(t, s, time.Time{}, time.Time{}))
+
+	// Segment of previous run is found by time of its records, not by its modification time
+	assert.Equal(t, []int{2, 3}, scanIDs(t, s, start.Add(2*time.Second), start.Add(4*time.Second)))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, files, 2)
+
+	// Line cut by crash is skipped
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	f.WriteString(`{"Time":"2020-01-01T00:00:10Z","Type":"test","Ev`)
+	f.Close()
+	assert.Nil(t, s.Close())
+
+	s, err = Open(dir, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, scanIDs(t, s, time.Time{}, time.Time{}))
+
+	// Record of any length is read back
+	big := make([]byte, 8<<20)
+	assert.Nil(t, s.Append(start.Add(7*time.Second), "test", struct {
+		ID   int
+		Data []byte
+	}{7, big}))
+	assert.Nil(t, s.Append(start.Add(8*time.Second), "test", &testEvent{8}))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, scanIDs(t, s, time.Time{}, time.Time{}))
+
+	// Nothing is appended once store is closed
+	assert.Nil(t, s.Close())
+	assert.Equal(t, os.ErrClosed, s.Append(start.Add(9*time.Second), "test", &testEvent{9}))
+}
+
+func TestStoreRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lottip-store")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Segments are limited by both size and age
+	s, err := Open(dir, Options{MaxSize: 4 * minSegmentSize, MaxAge: 8 * time.Hour})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(minSegmentSize), s.segmentSize)
+
+	now := time.Now()
+
+	// Records older than retention age, each hour goes to own segment
+	for id := 1; id <= 3; id++ {
+		assert.Nil(t, s.Append(now.Add(time.Duration(id-12)*time.Hour), "test", &testEvent{id}))
+	}
+	assert.Len(t, s.segments, 3)
+
+	assert.Nil(t, s.Append(now, "test", &testEvent{4}))
+	assert.Nil(t, s.cleanup(now))
+	assert.Equal(t, []int{4}, scanIDs(t, s, time.Time{}, time.Time{}))
+
+	// Segment being appended to is kept even if it's expired
+	assert.Nil(t, s.cleanup(now.Add(24*time.Hour)))
+	assert.Equal(t, []int{4}, scanIDs(t, s, time.Time{}, time.Time{}))
+
+	// Oldest segments are removed once size is exceeded
+	big := make([]byte, minSegmentSize)
+	for id := 5; id <= 10; id++ {
+		assert.Nil(t, s.Append(now, "test", struct {
+			ID   int
+			Data []byte
+		}{id, big}))
+	}
+	assert.Nil(t, s.cleanup(now))
+	assert.True(t, s.Size() <= 4*minSegmentSize)
+	assert.Equal(t, []int{9, 10}, scanIDs(t, s, time.Time{}, time.Time{}))
+
+	assert.Nil(t, s.Close())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/orderbynull/lottip/protocol"
+)
+
+// newTLSConfig builds TLS settings of proxied connections.
+// Returns nil if TLS is disabled, i.e. neither certificate nor self-signed one is requested.
+// MySQL server certificate is verified only if caFile is set, the same way MySQL clients do with --ssl-mode=REQUIRED.
+func newTLSConfig(certFile, keyFile string, selfSigned bool, caFile, mysqlHost string) (*protocol.TLSConfig, error) {
+	var cert tls.Certificate
+	var err error
+
+	switch {
+	case certFile != "" || keyFile != "":
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	case selfSigned:
+		cert, err = generateSelfSignedCert()
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	upstream := &tls.Config{InsecureSkipVerify: true}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+
+		host, _, err := net.SplitHostPort(mysqlHost)
+		if err != nil {
+			return nil, err
+		}
+
+		upstream = &tls.Config{RootCAs: roots, ServerName: host}
+	}
+
+	return &protocol.TLSConfig{
+		Listener: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Upstream: upstream,
+	}, nil
+}
+
+// generateSelfSignedCert generates certificate for TLS sessions with clients, valid for one year.
+// Clients must not verify server certificate to accept it, e.g. MySQL clients with --ssl-mode=REQUIRED.
+func generateSelfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "lottip"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 
 	"fmt"
@@ -9,7 +10,7 @@ import (
 )
 
 //...
-func getQueryResults(database, query string, params []string, dsn string) ([]string, [][]string, error) {
+func getQueryResults(database, query string, params []*string, dsn string) ([]string, [][]string, error) {
 	//isPrepared := true
 
 	// Open database
@@ -19,21 +20,30 @@ func getQueryResults(database, query string, params []string, dsn string) ([]str
 	}
 	defer db.Close()
 
+	// USE affects single connection only, so query must be run on the same connection
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
 	if len(database) > 0 {
-		_, err = db.Exec(fmt.Sprintf("USE %s;", database))
+		_, err = conn.ExecContext(context.Background(), fmt.Sprintf("USE `%s`;", strings.Replace(database, "`", "``", -1)))
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
-	// Prepare params
+	// Prepare params, NULL is bound as nil
 	var interfaceSlice = make([]interface{}, len(params))
 	for i, d := range params {
-		interfaceSlice[i] = d
+		if d != nil {
+			interfaceSlice[i] = *d
+		}
 	}
 
 	// Execute query
-	rows, err := db.Query(query, interfaceSlice...)
+	rows, err := conn.QueryContext(context.Background(), query, interfaceSlice...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -87,12 +97,17 @@ func getQueryResults(database, query string, params []string, dsn string) ([]str
 	return columns, resultRows, nil
 }
 
+// getUseDatabaseValue returns database name selected by USE statement, e.g. "USE `shop`;",
+// or empty string if query is not USE statement.
 func getUseDatabaseValue(query string) string {
 	var db = ""
 
-	words := strings.Fields(query)
+	words := strings.Fields(strings.TrimRight(query, "; \t\r\n"))
 	if len(words) == 2 && strings.ToUpper(words[0]) == "USE" {
 		db = words[1]
+		if len(db) > 1 && db[0] == '`' && db[len(db)-1] == '`' {
+			db = strings.Replace(db[1:len(db)-1], "``", "`", -1)
+		}
 	}
 
 	return db
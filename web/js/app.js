@@ -1,6 +1,8 @@
 const connStateStarted = 0xf4;
 const connStateFinished = 0xf5;
 const cmdResultError = 0xff;
+const cmdResultResultset = 0xbb;
+const comQuery = 'COM_QUERY';
 const typingMessage = 'Typing...';
 const copyDoneMessage = 'Copied to clipboard';
 const executeUrl = '/execute';
@@ -15,8 +17,10 @@ new Vue({
         connections: {},
         backupConnections: null,
         connectionsStates: {},
+        connectionsInfo: {},
         queriesCount: 0,
         filterQuery: '',
+        groupBy: 'connection',
         tipMessage: '',
         modalQueryResult: ''
     },
@@ -28,15 +32,43 @@ new Vue({
         }
     },
 
+    computed: {
+        // Queries grouped by connection, client service or MySQL user
+        groups: function () {
+            if (this.groupBy === 'connection') {
+                return this.connections;
+            }
+
+            var groups = {};
+            for (conn in this.connections) {
+                if (this.connections.hasOwnProperty(conn)) {
+
+                    for (query in this.connections[conn]) {
+                        if (this.connections[conn].hasOwnProperty(query)) {
+                            var key = this.connections[conn][query][this.groupBy];
+                            if (!(groups[key])) {
+                                groups[key] = {};
+                            }
+                            groups[key][conn + '/' + query] = this.connections[conn][query];
+                        }
+                    }
+                }
+            }
+
+            return groups;
+        }
+    },
+
     // Fired after app created
     created: function () {
         this.connect();
     },
 
     methods: {
-        // Copies query string into clipboard
+        // Copies query string into clipboard, prepared statements are copied with parameters inlined
         copyQuery: function (connId, queryId) {
-            if (clipboard.copy(this.connections[connId][queryId]['query']) && 'Notification' in window) {
+            var cmd = this.connections[connId][queryId];
+            if (clipboard.copy(cmd['runnableQuery'] || cmd['query']) && 'Notification' in window) {
 
                 const notify = function () {
                     var notification = new Notification(copyDoneMessage, {requireInteraction: false});
@@ -60,6 +92,7 @@ new Vue({
         executeQuery: function (connId, queryId) {
             if (this.connections[connId][queryId]['executable']) {
                 var vue = this;
+                var cmd = this.connections[connId][queryId];
 
                 vue.modalQueryResult = '';
 
@@ -69,9 +102,9 @@ new Vue({
                     executeUrl,
                     {
                         data: JSON.stringify({
-                            databse:this.connections[connId][queryId]['database'],
-                            query: this.connections[connId][queryId]['query'],
-                            parameters: this.connections[connId][queryId]['parameters']
+                            database: cmd['database'],
+                            query: cmd['runnableQuery'] || cmd['query'],
+                            parameters: cmd['runnableQuery'] ? [] : cmd['parameters']
                         })
                     },
                     function (data) {
@@ -108,7 +141,7 @@ new Vue({
                     for (query in connections[conn]) {
                         if (connections[conn].hasOwnProperty(query)) {
 
-                            if (connections[conn][query]['query'].toLowerCase().indexOf(this.filterQuery.toLowerCase()) >= 0) {
+                            if (this.matchesFilter(connections[conn][query])) {
                                 if (!(result[conn])) {
                                     result[conn] = {};
                                 }
@@ -123,6 +156,15 @@ new Vue({
             this.connections = result;
         }, 500),
 
+        // Returns true if query text, user or service of query contains filter string
+        matchesFilter: function (query) {
+            var filter = this.filterQuery.toLowerCase();
+
+            return [query.query, query.user, query.service].some(function (value) {
+                return value.toLowerCase().indexOf(filter) >= 0;
+            });
+        },
+
         // Disconnects from websocket server
         disconnect: function () {
             this.connected && ws.close();
@@ -141,21 +183,27 @@ new Vue({
             ws.onmessage = function (evt) {
                 var data = JSON.parse(evt.data);
 
+                //Subscription rejected
+                if ('Error' in data && !('CmdId' in data)) {
+                    console.error(data.Error);
+                    return;
+                }
+
                 //Cmd received
                 if ('Query' in data) {
-                    app.cmdReceived(data.ConnId, data.CmdId, data.Database, data.Query, data.Parameters, data.Executable);
+                    app.cmdReceived(data.ConnId, data.CmdId, data.Database, data.Query, data.Parameters, data.Executable, data.RunnableQuery, data);
                     return;
                 }
 
                 //CmdResult received
                 if ('Result' in data) {
-                    app.cmdResultReceived(data.ConnId, data.CmdId, data.Result, data.Error, data.Duration);
+                    app.cmdResultReceived(data.ConnId, data.CmdId, data.Result, data.Error, data.Duration, data);
                     return;
                 }
 
                 // ConnState received
                 if ('State' in data) {
-                    app.connStateReceived(data.ConnId, data.State);
+                    app.connStateReceived(data.ConnId, data.State, data.Info);
                 }
             };
 
@@ -186,31 +234,46 @@ new Vue({
         },
 
         // Fired when received Cmd data from websocket
-        cmdReceived: function (connId, cmdId, database, query, parameters, executable) {
+        cmdReceived: function (connId, cmdId, database, query, parameters, executable, runnableQuery, data) {
             if (!(connId in this.connections)) {
                 Vue.set(this.connections, connId, {});
             }
 
+            // Recent commands are sent again on reconnect
+            if (!(cmdId in this.connections[connId])) {
+                this.queriesCount++;
+            }
+
             Vue.set(this.connections[connId], cmdId, {
                 connId: connId,
                 cmdId: cmdId,
+                command: data.Command === comQuery ? '' : data.Command,
+                arguments: data.Arguments,
                 database: database,
+                user: data.User,
+                service: data.Service,
                 query: query,
                 parameters: parameters,
                 expanded: true,
                 executable: executable,
-                result: 'result-pending',
-                duration: '?.??',
+                runnableQuery: runnableQuery,
+                // Set for requests of 16MB or longer only
+                frames: data.Frames || 0,
+                // Server doesn't reply to some commands, e.g. COM_STMT_CLOSE, so they're done once sent
+                result: data.ExpectsResult ? 'result-pending' : 'result-ok',
+                duration: data.ExpectsResult ? '?.??' : '',
+                summary: '',
+                columns: [],
+                sample: null,
+                sampleCut: false,
                 error: ''
             });
 
-            this.queriesCount++;
-
             Vue.set(this.connectionsStates, connId, connStateStarted);
         },
 
         // Fired when received CmdResult from websocket
-        cmdResultReceived: function (connId, cmdId, result, error, duration) {
+        cmdResultReceived: function (connId, cmdId, result, error, duration, data) {
             if (this.connections[connId] !== undefined &&
                 this.connections[connId][cmdId] !== undefined) {
                 switch (result) {
@@ -224,12 +287,63 @@ new Vue({
 
                 this.connections[connId][cmdId].duration = duration;
                 this.connections[connId][cmdId].error = error;
+                this.connections[connId][cmdId].columns = data.Columns || [];
+                this.connections[connId][cmdId].sample = data.Sample;
+                this.connections[connId][cmdId].sampleCut = data.SampleCut;
+                this.connections[connId][cmdId].summary = result === cmdResultResultset
+                    ? data.Rows + ' rows'
+                    : (result === cmdResultError ? '' : data.AffectedRows + ' affected');
+            }
+        },
+
+        // Returns header of queries group
+        groupTitle: function (key, index) {
+            switch (this.groupBy) {
+                case 'service':
+                    return 'Service ' + (key || '(not announced)');
+                case 'user':
+                    return 'User ' + key;
             }
+
+            var title = 'Connection #' + (index + 1) + ' / ' + (this.isConnectionActive(key) ? 'active' : 'finished');
+            var info = this.connectionInfo(key);
+
+            return info ? title + ' / ' + info : title;
+        },
+
+        // Returns short description of MySQL session negotiated during handshake
+        connectionInfo: function (connId) {
+            var info = this.connectionsInfo[connId];
+            if (info === undefined) {
+                return '';
+            }
+
+            return (info.Service ? info.Service + ' / ' : '') +
+                'MySQL ' + info.ServerVersion + ' / thread ' + info.ConnectionID + ' / ' + info.User + ' / ' + this.authInfo(info) +
+                (info.TLS ? ' / TLS' : '') + (info.Compression ? ' / ' + info.Compression : '');
+        },
+
+        // Returns short description of authentication exchange
+        authInfo: function (info) {
+            var auth = info.AuthPlugin;
+            if (info.AuthPath) {
+                auth += ' ' + info.AuthPath + ' auth';
+            }
+            if (info.AuthPublicKey) {
+                auth += ' with RSA key';
+            }
+            auth += ' in ' + info.AuthTrips + (info.AuthTrips === 1 ? ' round trip' : ' round trips');
+
+            return info.AuthError ? auth + ' failed: ' + info.AuthError : auth;
         },
 
         // Fired when received ConnState from websocket
-        connStateReceived: function (connId, state) {
+        connStateReceived: function (connId, state, info) {
             Vue.set(this.connectionsStates, connId, state);
+
+            if (info) {
+                Vue.set(this.connectionsInfo, connId, info);
+            }
         }
     }
 });
\ No newline at end of file